@@ -0,0 +1,186 @@
+package memo
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+const (
+	defaultAnthropicURL = "https://api.anthropic.com"
+	anthropicVersion    = "2023-06-01"
+	defaultMaxTokens    = 1024
+)
+
+// AnthropicLLM completes with anthropic's messages api, or any server compatible with it
+type AnthropicLLM struct {
+	BaseURL   string // e.g. https://api.anthropic.com
+	APIKey    string
+	Model     string // default model
+	MaxTokens int    // the reply is cut at the limit, which is required by the api
+
+	client *http.Client
+}
+
+func NewAnthropicLLM(baseURL, key, model string, maxTokens int) *AnthropicLLM {
+	if baseURL == "" {
+		baseURL = defaultAnthropicURL
+	}
+	if maxTokens <= 0 {
+		maxTokens = defaultMaxTokens
+	}
+	return &AnthropicLLM{
+		BaseURL:   strings.TrimRight(baseURL, "/"),
+		APIKey:    key,
+		Model:     model,
+		MaxTokens: maxTokens,
+		client:    http.DefaultClient,
+	}
+}
+
+type anthropicRequest struct {
+	Model      string               `json:"model"`
+	System     string               `json:"system,omitempty"`
+	Messages   []Message            `json:"messages"`
+	MaxTokens  int                  `json:"max_tokens"`
+	Tools      []anthropicTool      `json:"tools,omitempty"`
+	ToolChoice *anthropicToolChoice `json:"tool_choice,omitempty"`
+}
+
+type anthropicTool struct {
+	Name        string          `json:"name"`
+	Description string          `json:"description,omitempty"`
+	InputSchema json.RawMessage `json:"input_schema"`
+}
+
+type anthropicToolChoice struct {
+	Type string `json:"type"` // "tool" forces the model to use the named one
+	Name string `json:"name"`
+}
+
+type anthropicResponse struct {
+	Content []struct {
+		Type  string          `json:"type"` // text or tool_use
+		Text  string          `json:"text"`
+		Input json.RawMessage `json:"input"` // of the tool_use
+	} `json:"content"`
+}
+
+type anthropicError struct {
+	Type    string `json:"type"`
+	Message string `json:"message"`
+}
+
+func (l *AnthropicLLM) Complete(ctx context.Context, model string, messages []Message) (string, error) {
+	var resp anthropicResponse
+	if err := l.send(ctx, l.request(model, messages), &resp); err != nil {
+		return "", err
+	}
+	return resp.text()
+}
+
+// CompleteFunction forces the model to use the function as a tool
+func (l *AnthropicLLM) CompleteFunction(ctx context.Context, model string, messages []Message, fn Function) (string, error) {
+	req := l.request(model, messages)
+	req.Tools = []anthropicTool{{Name: fn.Name, Description: fn.Description, InputSchema: fn.Parameters}}
+	req.ToolChoice = &anthropicToolChoice{Type: "tool", Name: fn.Name}
+
+	var resp anthropicResponse
+	if err := l.send(ctx, req, &resp); err != nil {
+		return "", err
+	}
+	for _, c := range resp.Content {
+		if c.Type == "tool_use" {
+			return string(c.Input), nil
+		}
+	}
+	return resp.text()
+}
+
+func (l *AnthropicLLM) request(model string, messages []Message) anthropicRequest {
+	if model == "" {
+		model = l.Model
+	}
+
+	// the system prompts are given separately, and the roles of the messages must alternate
+	req := anthropicRequest{Model: model, MaxTokens: l.MaxTokens}
+	var system []string
+	for _, m := range messages {
+		if m.Role == RoleSystem {
+			system = append(system, m.Content)
+			continue
+		}
+		if n := len(req.Messages); n > 0 && req.Messages[n-1].Role == m.Role {
+			req.Messages[n-1].Content += "\n\n" + m.Content
+			continue
+		}
+		req.Messages = append(req.Messages, m)
+	}
+	req.System = strings.Join(system, "\n\n")
+	return req
+}
+
+func (l *AnthropicLLM) send(ctx context.Context, req anthropicRequest, resp *anthropicResponse) error {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+	return l.do(ctx, http.MethodPost, "/v1/messages", body, resp)
+}
+
+// text joins the text blocks of the reply
+func (r *anthropicResponse) text() (string, error) {
+	var reply strings.Builder
+	for _, c := range r.Content {
+		if c.Type == "text" {
+			reply.WriteString(c.Text)
+		}
+	}
+	if reply.Len() == 0 {
+		return "", ErrEmptyCompletion
+	}
+	return reply.String(), nil
+}
+
+// Ping lists the models, which checks the api key too
+func (l *AnthropicLLM) Ping(ctx context.Context) error {
+	return l.do(ctx, http.MethodGet, "/v1/models", nil, &struct{}{})
+}
+
+// do sends the request, and decodes the response into v
+func (l *AnthropicLLM) do(ctx context.Context, method, path string, body []byte, v any) error {
+	req, err := http.NewRequestWithContext(ctx, method, l.BaseURL+path, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-API-Key", l.APIKey)
+	req.Header.Set("Anthropic-Version", anthropicVersion)
+
+	res, err := l.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode == http.StatusTooManyRequests {
+		return fmt.Errorf("anthropic error: %w", ErrRateLimited)
+	}
+	if res.StatusCode != http.StatusOK {
+		var e struct {
+			Error *anthropicError `json:"error"`
+		}
+		if json.NewDecoder(res.Body).Decode(&e) == nil && e.Error != nil {
+			return fmt.Errorf("anthropic error (status %d): %s", res.StatusCode, e.Error.Message)
+		}
+		return fmt.Errorf("anthropic error: status %d", res.StatusCode)
+	}
+
+	if err := json.NewDecoder(res.Body).Decode(v); err != nil {
+		return fmt.Errorf("can't decode anthropic response: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,77 @@
+package memo
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	apiKeyHeader = "X-API-Key"
+	tenantKey    = "memo.tenant"  // context key of the authenticated tenant
+	sessionKey   = "memo.session" // context key of the authorized session
+)
+
+// Authenticate maps the api key to its tenant, the key is given by "X-API-Key" or "Authorization: Bearer" header,
+// all requests belong to the default tenant "" only if the authentication is disabled explicitly,
+// otherwise they are rejected without a valid key, even if no key is configured
+func (hs *Handlers) Authenticate(c *gin.Context) {
+	if hs.AuthDisabled {
+		c.Set(tenantKey, "")
+		return
+	}
+
+	key := c.GetHeader(apiKeyHeader)
+	if key == "" {
+		if auth := c.GetHeader("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+			key = strings.TrimSpace(strings.TrimPrefix(auth, "Bearer "))
+		}
+	}
+
+	tenant, ok := hs.lookupKey(key)
+	if !ok {
+		NewError(c, http.StatusUnauthorized, ErrUnauthorized)
+		c.Abort()
+		return
+	}
+	c.Set(tenantKey, tenant)
+}
+
+// AuthorizeSession makes sure the session of the path belongs to the tenant,
+// the sessions of other tenants are not found
+func (hs *Handlers) AuthorizeSession(c *gin.Context) {
+	sess, err := hs.findSession(c.Request.Context(), tenant(c), c.Param("session"))
+	if err != nil {
+		sessionError(c, err)
+		c.Abort()
+		return
+	}
+	c.Set(sessionKey, sess)
+}
+
+// lookupKey compares all the keys in constant time, so the timing doesn't leak them
+func (hs *Handlers) lookupKey(key string) (string, bool) {
+	if key == "" {
+		return "", false
+	}
+
+	tenant, found := "", false
+	for k, t := range hs.APIKeys {
+		if subtle.ConstantTimeCompare([]byte(k), []byte(key)) == 1 {
+			tenant, found = t, true
+		}
+	}
+	return tenant, found
+}
+
+// authorizedSession of the path, set by AuthorizeSession
+func authorizedSession(c *gin.Context) *Session {
+	return c.MustGet(sessionKey).(*Session)
+}
+
+// tenant of the request, set by Authenticate
+func tenant(c *gin.Context) string {
+	return c.GetString(tenantKey)
+}
@@ -0,0 +1,98 @@
+package memo
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTenantIsolation(t *testing.T) {
+	hs := newTestHandlers(t)
+	hs.APIKeys = map[string]string{"key-a": "a", "key-b": "b"}
+	hs.AuthDisabled = false
+
+	router := newTestRouter(hs)
+
+	// health checks are public
+	assert.Equal(t, 200, serveAs(router, "", "GET", "/healthz", nil).Code)
+
+	assert.Equal(t, 401, serveAs(router, "", "GET", "/s", nil).Code)
+	assert.Equal(t, 401, serveAs(router, "key-c", "GET", "/s", nil).Code)
+
+	w := serveAs(router, "key-a", "POST", "/s/add", strings.NewReader(`{"name":"aspirin", "owner":"b"}`))
+	assert.Equal(t, 200, w.Code)
+	var added SessionAddResponse
+	assert.NoError(t, json.NewDecoder(w.Body).Decode(&added))
+	sid := added.ID.Hex()
+
+	// the owner can't be forged
+	w = serveAs(router, "key-a", "GET", "/s/"+sid, nil)
+	assert.Equal(t, 200, w.Code)
+	var sess Session
+	assert.NoError(t, json.NewDecoder(w.Body).Decode(&sess))
+	assert.Equal(t, "a", sess.Owner)
+
+	// bearer token works too
+	w = httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/s", nil)
+	req.Header.Set("Authorization", "Bearer key-a")
+	router.ServeHTTP(w, req)
+	assert.Equal(t, 200, w.Code)
+	var sessions []Session
+	assert.NoError(t, json.NewDecoder(w.Body).Decode(&sessions))
+	assert.Equal(t, 1, len(sessions))
+
+	// tenant b can't see or touch the session
+	w = serveAs(router, "key-b", "GET", "/s", nil)
+	assert.Equal(t, 200, w.Code)
+	sessions = nil
+	assert.NoError(t, json.NewDecoder(w.Body).Decode(&sessions))
+	assert.Zero(t, len(sessions))
+
+	assert.Equal(t, 404, serveAs(router, "key-b", "GET", "/s/"+sid, nil).Code)
+	assert.Equal(t, 404, serveAs(router, "key-b", "DELETE", "/s/"+sid+"/del", nil).Code)
+	assert.Equal(t, 404, serveAs(router, "key-b", "POST", "/m/"+sid+"/add", strings.NewReader(`{"memories":[{"metadata":{"content":"hello"}}]}`)).Code)
+	assert.Equal(t, 404, serveAs(router, "key-b", "GET", "/m/"+sid, nil).Code)
+	assert.Equal(t, 404, serveAs(router, "key-b", "POST", "/m/"+sid+"/search", strings.NewReader(`{"query":"hello"}`)).Code)
+	assert.Equal(t, 401, serveAs(router, "", "GET", "/m/"+sid, nil).Code)
+
+	// tenant a can
+	assert.Equal(t, 200, serveAs(router, "key-a", "POST", "/m/"+sid+"/add", strings.NewReader(`{"memories":[{"metadata":{"content":"hello"}}]}`)).Code)
+	assert.Equal(t, 200, serveAs(router, "key-a", "POST", "/m/"+sid+"/search", strings.NewReader(`{"query":"hello"}`)).Code)
+	assert.Equal(t, 400, serveAs(router, "key-a", "GET", "/m/123", nil).Code)
+	assert.Equal(t, 200, serveAs(router, "key-a", "DELETE", "/s/"+sid+"/del", nil).Code)
+}
+
+func TestAuthenticateWithoutKeys(t *testing.T) {
+	hs := newTestHandlers(t)
+	router := newTestRouter(hs)
+
+	// all requests belong to the default tenant only if it's disabled explicitly
+	assert.Equal(t, 200, serve(router, "GET", "/s", nil).Code)
+
+	hs.AuthDisabled = false
+	assert.Equal(t, 401, serveAs(router, "any", "GET", "/s", nil).Code)
+}
+
+func TestParseAPIKeys(t *testing.T) {
+	var keys map[string]string
+	assert.NoError(t, setConfigValue(&keys, "key-a:a, key-b:b"))
+	assert.Equal(t, map[string]string{"key-a": "a", "key-b": "b"}, keys)
+	assert.Error(t, setConfigValue(&keys, "key-a"))
+
+	// the spaces around ":" are trimmed
+	assert.NoError(t, setConfigValue(&keys, "key-a : a,key-b:b "))
+	assert.Equal(t, map[string]string{"key-a": "a", "key-b": "b"}, keys)
+
+	cfg := DefaultConfig()
+	cfg.OpenAI.APIKey = "key"
+	assert.NoError(t, setConfigValue(&cfg.Auth.Keys, "key-a : "))
+	assert.ErrorContains(t, cfg.Validate(), "auth.keys")
+
+	cfg.Auth.Keys = map[string]string{"key-a ": "a"}
+	assert.ErrorContains(t, cfg.Validate(), "auth.keys")
+}
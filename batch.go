@@ -0,0 +1,151 @@
+package memo
+
+import (
+	"context"
+	"log"
+	"sync"
+	"unicode/utf8"
+)
+
+const (
+	defaultBatchSize   = 100  // inputs of one embedding request
+	defaultBatchTokens = 8000 // estimated tokens of one embedding request
+	defaultConcurrency = 4    // embedding requests at the same time
+	defaultUpsertSize  = 100  // points of one upsert
+)
+
+// BatchConfig limits the requests of a large ingest, the inputs are split into the batches of size and tokens,
+// which are embedded concurrently, then upserted in batches
+type BatchConfig struct {
+	Size        int `toml:"size"`        // max inputs of one embedding request
+	Tokens      int `toml:"tokens"`      // max estimated tokens of one embedding request, a longer input is sent alone
+	Concurrency int `toml:"concurrency"` // max embedding requests at the same time
+	UpsertSize  int `toml:"upsert_size"` // max points of one upsert
+}
+
+func defaultBatchConfig() BatchConfig {
+	return BatchConfig{
+		Size:        defaultBatchSize,
+		Tokens:      defaultBatchTokens,
+		Concurrency: defaultConcurrency,
+		UpsertSize:  defaultUpsertSize,
+	}
+}
+
+// batchRange is the inputs from start to end (exclusive)
+type batchRange struct {
+	start, end int
+}
+
+// splitBatches splits the inputs in order, each batch has at most size inputs and tokens estimated tokens
+func splitBatches(inputs []string, size, tokens int) []batchRange {
+	var batches []batchRange
+	start, sum := 0, 0
+	for i, input := range inputs {
+		n := estimateTokens(input)
+		if i > start && (i-start >= size || sum+n > tokens) {
+			batches = append(batches, batchRange{start, i})
+			start, sum = i, 0
+		}
+		sum += n
+	}
+	if start < len(inputs) {
+		batches = append(batches, batchRange{start, len(inputs)})
+	}
+	return batches
+}
+
+// estimateTokens overestimates the tokens without a tokenizer,
+// about 4 ascii characters for one token, and one token for each of the others, e.g. chinese
+func estimateTokens(s string) int {
+	var ascii, others int
+	for _, r := range s {
+		if r < utf8.RuneSelf {
+			ascii++
+		} else {
+			others++
+		}
+	}
+	return (ascii+3)/4 + others
+}
+
+// embedBatches embeds the inputs in batches concurrently, the error of a failed batch is given to each of its inputs
+func (hs *Handlers) embedBatches(ctx context.Context, inputs []string) ([][]float32, []error) {
+	vectors := make([][]float32, len(inputs))
+	errs := make([]error, len(inputs))
+
+	concurrency := hs.Batch.Concurrency
+	if concurrency <= 0 {
+		concurrency = 1
+	}
+	sem := make(chan struct{}, concurrency)
+
+	var wg sync.WaitGroup
+	for _, b := range splitBatches(inputs, hs.Batch.Size, hs.Batch.Tokens) {
+		sem <- struct{}{}
+		wg.Add(1)
+		go func(b batchRange) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+
+			embedded, err := hs.embedder.Embed(ctx, inputs[b.start:b.end])
+			if err != nil {
+				log.Printf("can't embed %d inputs: %v", b.end-b.start, err)
+			}
+			for i := b.start; i < b.end; i++ {
+				if err != nil {
+					errs[i] = &embedError{cause: err}
+				} else {
+					vectors[i] = embedded[i-b.start]
+				}
+			}
+		}(b)
+	}
+	wg.Wait()
+
+	return vectors, errs
+}
+
+// embedError is ErrOpenAIEmbedding to the client, and keeps the cause, e.g. the rate limit
+type embedError struct {
+	cause error
+}
+
+func (e *embedError) Error() string   { return ErrOpenAIEmbedding.Error() }
+func (e *embedError) Unwrap() []error { return []error{ErrOpenAIEmbedding, e.cause} }
+
+// upsertBatches upserts the memories without errors in batches, the error of a failed batch is given to each of its memories
+func (hs *Handlers) upsertBatches(ctx context.Context, sid string, memories []Memory, errs []error) {
+	size := hs.Batch.UpsertSize
+	if size <= 0 {
+		size = defaultUpsertSize
+	}
+
+	var batch []Memory
+	var indices []int
+	flush := func() {
+		if err := hs.vectors.Upsert(ctx, sid, batch); err != nil {
+			log.Printf("can't upsert %d memories: %v", len(batch), err)
+			for _, i := range indices {
+				errs[i] = ErrQdrantUpsert
+			}
+		}
+		batch, indices = nil, nil
+	}
+
+	for i := range memories {
+		if errs[i] != nil {
+			continue
+		}
+		batch = append(batch, memories[i])
+		indices = append(indices, i)
+		if len(batch) == size {
+			flush()
+		}
+	}
+	if len(batch) > 0 {
+		flush()
+	}
+}
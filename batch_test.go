@@ -0,0 +1,130 @@
+package memo
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// flakyEmbedder fails the batches with a "bad" input, and records the batches and the concurrency
+type flakyEmbedder struct {
+	Embedder
+
+	mu      sync.Mutex
+	batches [][]string
+	running int
+	peak    int
+}
+
+func (e *flakyEmbedder) Embed(ctx context.Context, inputs []string) ([][]float32, error) {
+	e.mu.Lock()
+	e.batches = append(e.batches, inputs)
+	e.running++
+	if e.running > e.peak {
+		e.peak = e.running
+	}
+	e.mu.Unlock()
+
+	time.Sleep(10 * time.Millisecond)
+
+	e.mu.Lock()
+	e.running--
+	e.mu.Unlock()
+
+	for _, input := range inputs {
+		if strings.Contains(input, "bad") {
+			return nil, errors.New("invalid input")
+		}
+	}
+	return e.Embedder.Embed(ctx, inputs)
+}
+
+// countingVectorStore counts the upserts
+type countingVectorStore struct {
+	*InMemoryVectorStore
+	upserts []int
+}
+
+func (s *countingVectorStore) Upsert(ctx context.Context, collection string, memories []Memory) error {
+	s.upserts = append(s.upserts, len(memories))
+	return s.InMemoryVectorStore.Upsert(ctx, collection, memories)
+}
+
+func TestSplitBatches(t *testing.T) {
+	assert.Equal(t, 3, estimateTokens("hello world"))
+	assert.Equal(t, 4, estimateTokens("你好世界"))
+	assert.Equal(t, 0, estimateTokens(""))
+
+	inputs := []string{"a", "b", "c", "d", "e"}
+	assert.Equal(t, []batchRange{{0, 2}, {2, 4}, {4, 5}}, splitBatches(inputs, 2, 100))
+	assert.Equal(t, []batchRange{{0, 3}, {3, 5}}, splitBatches(inputs, 10, 3))
+
+	// the long one is sent alone
+	inputs = []string{"a", strings.Repeat("长", 10), "b", "c"}
+	assert.Equal(t, []batchRange{{0, 1}, {1, 2}, {2, 4}}, splitBatches(inputs, 10, 5))
+	assert.Nil(t, splitBatches(nil, 10, 5))
+}
+
+func TestAddMemoriesInBatches(t *testing.T) {
+	hs := newTestHandlers(t)
+	embedder := &flakyEmbedder{Embedder: hs.embedder}
+	vectors := &countingVectorStore{InMemoryVectorStore: NewInMemoryVectorStore()}
+	hs.embedder, hs.vectors = embedder, vectors
+	hs.Batch = BatchConfig{Size: 2, Tokens: 100, Concurrency: 2, UpsertSize: 3}
+
+	router := newTestRouter(hs)
+
+	ctx := context.TODO()
+	id, err := hs.sessions.Create(ctx, &Session{Name: "aspirin"})
+	assert.NoError(t, err)
+	_, err = hs.vectors.EnsureCollection(ctx, id.Hex(), hs.embedder.Dimension())
+	assert.NoError(t, err)
+
+	// the batch of the bad one fails, the others are added
+	body := `{"skip_scoring":true, "memories":[
+		{"metadata":{"content":"one"}}, {"metadata":{"content":"two"}},
+		{"metadata":{"content":"three"}}, {"metadata":{"content":"bad"}},
+		{"metadata":{"content":"five"}}, {"metadata":{"content":"six"}},
+		{"metadata":{"content":"seven"}}, {"metadata":{"content":"eight"}}
+	]}`
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/m/"+id.Hex()+"/add", bytes.NewBufferString(body))
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusMultiStatus, w.Code)
+
+	var res AddMemoriesResponse
+	assert.NoError(t, json.NewDecoder(w.Body).Decode(&res))
+	assert.Equal(t, 6, len(res.IDs))
+	assert.Equal(t, 8, len(res.Results))
+	for i, r := range res.Results {
+		if i == 2 || i == 3 {
+			assert.Equal(t, ErrOpenAIEmbedding.Error(), r.Error)
+			assert.Empty(t, r.ID)
+		} else {
+			assert.NotEmpty(t, r.ID)
+		}
+	}
+
+	assert.Equal(t, 4, len(embedder.batches))
+	assert.LessOrEqual(t, embedder.peak, 2)
+	assert.Equal(t, []int{3, 3}, vectors.upserts)
+
+	count, err := hs.vectors.Count(ctx, id.Hex(), nil)
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(6), count)
+
+	// nothing is added
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("POST", "/m/"+id.Hex()+"/add", bytes.NewBufferString(`{"skip_scoring":true, "memories":[{"metadata":{"content":"bad"}}]}`))
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
@@ -0,0 +1,298 @@
+package memo
+
+import (
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	defaultCacheSize = 4096              // entries of the lru cache, about 24MB of 1536 dimensions
+	cacheKeyPrefix   = "memo:embedding:" // prefix of the keys in redis
+)
+
+// EmbeddingCache stores the vectors by their keys, which are derived from the model and the content
+type EmbeddingCache interface {
+	// Get returns one vector for each key in the same order, nil if it's missing
+	Get(ctx context.Context, keys []string) ([][]float32, error)
+	// Set stores the vectors of the keys
+	Set(ctx context.Context, keys []string, vectors [][]float32) error
+	// Close releases the connections if any
+	Close() error
+}
+
+// CacheStats is the hit rate of the embedding cache since the server started
+type CacheStats struct {
+	Backend string  `json:"backend" example:"lru"`
+	Hits    uint64  `json:"hits"`
+	Misses  uint64  `json:"misses"`
+	Errors  uint64  `json:"errors"` // failed reads and writes of the cache, the inputs are embedded anyway
+	HitRate float64 `json:"hit_rate"`
+	Entries int     `json:"entries,omitempty"` // of the lru cache
+}
+
+// CachedEmbedder embeds only the contents which are not in the cache,
+// the cache is keyed by the name and dimension of the model with the hash of the content,
+// so the vectors of different models are never mixed
+type CachedEmbedder struct {
+	Embedder
+
+	backend string
+	cache   EmbeddingCache
+
+	hits     atomic.Uint64
+	misses   atomic.Uint64
+	failures atomic.Uint64
+}
+
+func NewCachedEmbedder(embedder Embedder, backend string, cache EmbeddingCache) *CachedEmbedder {
+	return &CachedEmbedder{Embedder: embedder, backend: backend, cache: cache}
+}
+
+// Embed reads the cache first, the failures of the cache are logged, and don't fail the embedding
+func (e *CachedEmbedder) Embed(ctx context.Context, inputs []string) ([][]float32, error) {
+	keys := make([]string, len(inputs))
+	for i, input := range inputs {
+		keys[i] = e.key(input)
+	}
+
+	vectors, err := e.cache.Get(ctx, keys)
+	if err != nil {
+		e.failures.Add(1)
+		log.Printf("can't read the embedding cache: %v", err)
+		vectors = make([][]float32, len(inputs))
+	}
+
+	// the duplicates are embedded once
+	missing := map[string][]int{} // key to the indices of the inputs
+	var misses, missKeys []string
+	var missed int
+	for i, v := range vectors {
+		if len(v) == e.Dimension() {
+			continue
+		}
+		vectors[i] = nil
+		missed++
+		if _, ok := missing[keys[i]]; !ok {
+			misses = append(misses, inputs[i])
+			missKeys = append(missKeys, keys[i])
+		}
+		missing[keys[i]] = append(missing[keys[i]], i)
+	}
+	e.hits.Add(uint64(len(inputs) - missed))
+	e.misses.Add(uint64(missed))
+
+	if len(misses) == 0 {
+		return vectors, nil
+	}
+
+	embedded, err := e.Embedder.Embed(ctx, misses)
+	if err != nil {
+		return nil, err
+	}
+	for j, key := range missKeys {
+		for _, i := range missing[key] {
+			vectors[i] = embedded[j]
+		}
+	}
+
+	if err := e.cache.Set(ctx, missKeys, embedded); err != nil {
+		e.failures.Add(1)
+		log.Printf("can't write the embedding cache: %v", err)
+	}
+	return vectors, nil
+}
+
+func (e *CachedEmbedder) key(input string) string {
+	sum := sha256.Sum256([]byte(input))
+	return e.Name() + ":" + strconv.Itoa(e.Dimension()) + ":" + hex.EncodeToString(sum[:])
+}
+
+func (e *CachedEmbedder) Stats() CacheStats {
+	stats := CacheStats{
+		Backend: e.backend,
+		Hits:    e.hits.Load(),
+		Misses:  e.misses.Load(),
+		Errors:  e.failures.Load(),
+	}
+	if total := stats.Hits + stats.Misses; total > 0 {
+		stats.HitRate = float64(stats.Hits) / float64(total)
+	}
+	if lru, ok := e.cache.(*LRUCache); ok {
+		stats.Entries = lru.Len()
+	}
+	return stats
+}
+
+func (e *CachedEmbedder) Close() error {
+	return e.cache.Close()
+}
+
+// LRUCache is an in-process cache, which evicts the least recently used vectors beyond its size
+type LRUCache struct {
+	size int
+
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	order   *list.List // the front is the most recently used
+}
+
+type lruEntry struct {
+	key    string
+	vector []float32
+}
+
+func NewLRUCache(size int) *LRUCache {
+	if size <= 0 {
+		size = defaultCacheSize
+	}
+	return &LRUCache{size: size, entries: map[string]*list.Element{}, order: list.New()}
+}
+
+func (c *LRUCache) Get(ctx context.Context, keys []string) ([][]float32, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	vectors := make([][]float32, len(keys))
+	for i, key := range keys {
+		if el, ok := c.entries[key]; ok {
+			c.order.MoveToFront(el)
+			vectors[i] = el.Value.(*lruEntry).vector
+		}
+	}
+	return vectors, nil
+}
+
+func (c *LRUCache) Set(ctx context.Context, keys []string, vectors [][]float32) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for i, key := range keys {
+		if el, ok := c.entries[key]; ok {
+			el.Value.(*lruEntry).vector = vectors[i]
+			c.order.MoveToFront(el)
+			continue
+		}
+
+		c.entries[key] = c.order.PushFront(&lruEntry{key: key, vector: vectors[i]})
+		if c.order.Len() > c.size {
+			oldest := c.order.Back()
+			c.order.Remove(oldest)
+			delete(c.entries, oldest.Value.(*lruEntry).key)
+		}
+	}
+	return nil
+}
+
+func (c *LRUCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.order.Len()
+}
+
+func (c *LRUCache) Close() error {
+	return nil
+}
+
+// RedisCache shares the vectors between the servers, they are stored as little endian float32s
+type RedisCache struct {
+	client redis.UniversalClient
+	ttl    time.Duration // the vectors never expire if it's 0
+}
+
+func NewRedisCache(client redis.UniversalClient, ttl time.Duration) *RedisCache {
+	return &RedisCache{client: client, ttl: ttl}
+}
+
+func (c *RedisCache) Get(ctx context.Context, keys []string) ([][]float32, error) {
+	if len(keys) == 0 {
+		return nil, nil
+	}
+
+	prefixed := make([]string, len(keys))
+	for i, key := range keys {
+		prefixed[i] = cacheKeyPrefix + key
+	}
+
+	values, err := c.client.MGet(ctx, prefixed...).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	vectors := make([][]float32, len(keys))
+	for i, v := range values {
+		s, ok := v.(string)
+		if !ok {
+			continue
+		}
+		vectors[i], err = decodeVector([]byte(s))
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", prefixed[i], err)
+		}
+	}
+	return vectors, nil
+}
+
+func (c *RedisCache) Set(ctx context.Context, keys []string, vectors [][]float32) error {
+	_, err := c.client.Pipelined(ctx, func(p redis.Pipeliner) error {
+		for i, key := range keys {
+			p.Set(ctx, cacheKeyPrefix+key, encodeVector(vectors[i]), c.ttl)
+		}
+		return nil
+	})
+	return err
+}
+
+func (c *RedisCache) Close() error {
+	return c.client.Close()
+}
+
+func encodeVector(v []float32) []byte {
+	b := make([]byte, 4*len(v))
+	for i, x := range v {
+		binary.LittleEndian.PutUint32(b[4*i:], math.Float32bits(x))
+	}
+	return b
+}
+
+func decodeVector(b []byte) ([]float32, error) {
+	if len(b)%4 != 0 {
+		return nil, errors.New("invalid vector length")
+	}
+
+	v := make([]float32, len(b)/4)
+	for i := range v {
+		v[i] = math.Float32frombits(binary.LittleEndian.Uint32(b[4*i:]))
+	}
+	return v, nil
+}
+
+// NewEmbeddingCache creates the cache of the configured backend, nil if it's disabled
+func NewEmbeddingCache(cfg CacheConfig) (EmbeddingCache, error) {
+	switch cfg.Backend {
+	case "", "none":
+		return nil, nil
+	case "lru":
+		return NewLRUCache(cfg.Size), nil
+	case "redis":
+		opts, err := redis.ParseURL(cfg.RedisURL)
+		if err != nil {
+			return nil, fmt.Errorf("invalid redis url: %w", err)
+		}
+		return NewRedisCache(redis.NewClient(opts), cfg.TTL), nil
+	default:
+		return nil, fmt.Errorf("unknown embedding cache: %q", cfg.Backend)
+	}
+}
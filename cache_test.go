@@ -0,0 +1,120 @@
+package memo
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// countingEmbedder counts the embedded inputs
+type countingEmbedder struct {
+	Embedder
+	name   string
+	inputs int
+}
+
+func (e *countingEmbedder) Embed(ctx context.Context, inputs []string) ([][]float32, error) {
+	e.inputs += len(inputs)
+	return e.Embedder.Embed(ctx, inputs)
+}
+
+func (e *countingEmbedder) Name() string {
+	return e.name
+}
+
+// brokenCache fails all the reads and writes
+type brokenCache struct{}
+
+func (brokenCache) Get(ctx context.Context, keys []string) ([][]float32, error) {
+	return nil, errors.New("connection refused")
+}
+
+func (brokenCache) Set(ctx context.Context, keys []string, vectors [][]float32) error {
+	return errors.New("connection refused")
+}
+
+func (brokenCache) Close() error { return nil }
+
+func TestLRUCache(t *testing.T) {
+	ctx := context.TODO()
+	c := NewLRUCache(2)
+	assert.NoError(t, c.Set(ctx, []string{"a", "b"}, [][]float32{{1}, {2}}))
+
+	// a is used recently, so b is evicted
+	vectors, _ := c.Get(ctx, []string{"a", "x"})
+	assert.Equal(t, [][]float32{{1}, nil}, vectors)
+	assert.NoError(t, c.Set(ctx, []string{"c"}, [][]float32{{3}}))
+
+	vectors, _ = c.Get(ctx, []string{"a", "b", "c"})
+	assert.Equal(t, [][]float32{{1}, nil, {3}}, vectors)
+	assert.Equal(t, 2, c.Len())
+
+	v := []float32{0.5, -1, 3.25}
+	decoded, err := decodeVector(encodeVector(v))
+	assert.NoError(t, err)
+	assert.Equal(t, v, decoded)
+	_, err = decodeVector([]byte{1, 2, 3})
+	assert.Error(t, err)
+}
+
+func TestCachedEmbedder(t *testing.T) {
+	ctx := context.TODO()
+	hash := NewHashEmbedder(8)
+	inner := &countingEmbedder{Embedder: hash, name: "hash"}
+	cache := NewLRUCache(10)
+	e := NewCachedEmbedder(inner, "lru", cache)
+
+	// the duplicates are embedded once
+	vectors, err := e.Embed(ctx, []string{"a", "b", "a"})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, inner.inputs)
+	expected, _ := hash.Embed(ctx, []string{"a", "b", "a"})
+	assert.Equal(t, expected, vectors)
+
+	vectors, err = e.Embed(ctx, []string{"a", "c"})
+	assert.NoError(t, err)
+	assert.Equal(t, 3, inner.inputs)
+	expected, _ = hash.Embed(ctx, []string{"a", "c"})
+	assert.Equal(t, expected, vectors)
+	assert.Equal(t, CacheStats{Backend: "lru", Hits: 1, Misses: 4, HitRate: 0.2, Entries: 3}, e.Stats())
+
+	// another model doesn't share the vectors
+	other := &countingEmbedder{Embedder: hash, name: "other"}
+	_, err = NewCachedEmbedder(other, "lru", cache).Embed(ctx, []string{"a"})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, other.inputs)
+
+	// the inputs are embedded anyway if the cache fails
+	broken := NewCachedEmbedder(inner, "redis", brokenCache{})
+	vectors, err = broken.Embed(ctx, []string{"a"})
+	assert.NoError(t, err)
+	assert.Equal(t, expected[:1], vectors)
+	assert.Equal(t, uint64(2), broken.Stats().Errors)
+}
+
+func TestMetrics(t *testing.T) {
+	hs := newTestHandlers(t)
+	router := newTestRouter(hs)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/metrics", nil)
+	router.ServeHTTP(w, req)
+	assert.Equal(t, 200, w.Code)
+	assert.JSONEq(t, `{}`, w.Body.String())
+
+	hs.embedder = NewCachedEmbedder(hs.embedder, "lru", NewLRUCache(10))
+	_, err := hs.embedder.Embed(context.TODO(), []string{"a", "a"})
+	assert.NoError(t, err)
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	var res MetricsResponse
+	assert.NoError(t, json.NewDecoder(w.Body).Decode(&res))
+	assert.Equal(t, uint64(2), res.EmbeddingCache.Misses)
+	assert.Equal(t, 1, res.EmbeddingCache.Entries)
+}
@@ -2,47 +2,153 @@ package memo
 
 import (
 	"context"
-	"strconv"
+	"fmt"
 	"strings"
 
 	openai "github.com/sashabaranov/go-openai"
 )
 
-type llm struct {
-	client *openai.Client
+// OpenAIClient is the part of openai client which memo needs, *openai.Client implements it
+type OpenAIClient interface {
+	CreateChatCompletion(ctx context.Context, request openai.ChatCompletionRequest) (openai.ChatCompletionResponse, error)
+	CreateEmbeddings(ctx context.Context, request openai.EmbeddingRequest) (openai.EmbeddingResponse, error)
+	ListModels(ctx context.Context) (openai.ModelsList, error)
 }
 
-func (l *llm) ScoreMemories(ctx context.Context, prompts []openai.ChatCompletionMessage, memories []string) (scores []int, err error) {
-	questions := openai.ChatCompletionMessage{Role: "user", Content: strings.Join(memories, ";")}
+// OpenAILLM completes with openai's chat api, the compatible servers and azure openai work with it too
+type OpenAILLM struct {
+	client OpenAIClient
+	model  string // default model
+}
 
-	resp, err := l.client.CreateChatCompletion(ctx, openai.ChatCompletionRequest{
-		Model:    openai.GPT3Dot5Turbo,
-		Messages: append(prompts, questions),
-	})
+// NewOpenAILLM creates the llm with the client, gpt-3.5-turbo is the default model if it's empty
+func NewOpenAILLM(client OpenAIClient, model string) *OpenAILLM {
+	if model == "" {
+		model = openai.GPT3Dot5Turbo
+	}
+	return &OpenAILLM{client: client, model: model}
+}
 
+func (l *OpenAILLM) Complete(ctx context.Context, model string, messages []Message) (string, error) {
+	msg, err := l.chat(ctx, l.request(model, messages))
 	if err != nil {
-		return nil, err
+		return "", err
+	}
+	return msg.Content, nil
+}
+
+func (l *OpenAILLM) CompleteFunction(ctx context.Context, model string, messages []Message, fn Function) (string, error) {
+	req := l.request(model, messages)
+	req.Functions = []openai.FunctionDefinition{{Name: fn.Name, Description: fn.Description, Parameters: fn.Parameters}}
+	req.FunctionCall = map[string]string{"name": fn.Name}
+
+	msg, err := l.chat(ctx, req)
+	if err != nil {
+		return "", err
+	}
+	if msg.FunctionCall != nil {
+		return msg.FunctionCall.Arguments, nil
+	}
+	return msg.Content, nil
+}
+
+func (l *OpenAILLM) request(model string, messages []Message) openai.ChatCompletionRequest {
+	if model == "" {
+		model = l.model
+	}
+
+	msgs := make([]openai.ChatCompletionMessage, 0, len(messages))
+	for _, m := range messages {
+		msgs = append(msgs, openai.ChatCompletionMessage{Role: m.Role, Content: m.Content})
 	}
-	return sliceAtoi(strings.Split(resp.Choices[0].Message.Content, ", "))
+	return openai.ChatCompletionRequest{Model: model, Messages: msgs}
 }
 
-// create embeddings from openai
-func (l *llm) embedding(ctx context.Context, input []string) (openai.EmbeddingResponse, error) {
-	erq := openai.EmbeddingRequest{
-		Input: input,
-		Model: openai.AdaEmbeddingV2,
+// chat returns the message of the first choice
+func (l *OpenAILLM) chat(ctx context.Context, req openai.ChatCompletionRequest) (*openai.ChatCompletionMessage, error) {
+	resp, err := l.client.CreateChatCompletion(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(resp.Choices) == 0 {
+		return nil, ErrEmptyCompletion
 	}
-	return l.client.CreateEmbeddings(ctx, erq)
+	return &resp.Choices[0].Message, nil
+}
+
+func (l *OpenAILLM) Ping(ctx context.Context) error {
+	_, err := l.client.ListModels(ctx)
+	return err
 }
 
-func sliceAtoi(sa []string) ([]int, error) {
-	si := make([]int, 0, len(sa))
-	for _, a := range sa {
-		i, err := strconv.Atoi(a)
+// llm does the tasks with the provider, each task uses its own model, or the provider's default one
+type llm struct {
+	provider  LLM
+	models    TaskModels
+	functions bool // score with function calling if the provider supports it
+}
+
+// ScoreMemories scores the importance of each memory, the memories failed to be scored in the batch are retried one by one,
+// the scores of the ones still failed are 0, with the error of ErrScoreMismatch
+func (l *llm) ScoreMemories(ctx context.Context, prompts []Message, memories []string) ([]int, error) {
+	scores, err := l.scoreBatch(ctx, prompts, memories)
+	if err != nil {
+		return nil, err
+	}
+
+	var failed int
+	for i, score := range scores {
+		if score != 0 {
+			continue
+		}
+
+		retry, err := l.scoreBatch(ctx, prompts, memories[i:i+1])
 		if err != nil {
-			return si, err
+			return scores, err
+		}
+		if scores[i] = retry[0]; scores[i] == 0 {
+			failed++
 		}
-		si = append(si, i)
 	}
-	return si, nil
+
+	if failed > 0 {
+		return scores, fmt.Errorf("%w: %d of %d memories are not scored", ErrScoreMismatch, failed, len(memories))
+	}
+	return scores, nil
+}
+
+// scoreBatch asks the model to score the memories, with function calling if it's supported,
+// the reply is parsed leniently, so only the unparsable scores are 0
+func (l *llm) scoreBatch(ctx context.Context, prompts []Message, memories []string) ([]int, error) {
+	messages := l.messages(prompts, strings.Join(memories, ";"))
+	model := l.models.model(TaskImportance)
+
+	var reply string
+	var err error
+	if fc, ok := l.provider.(FunctionCaller); ok && l.functions {
+		reply, err = fc.CompleteFunction(ctx, model, messages, scoreFunction)
+	} else {
+		reply, err = l.provider.Complete(ctx, model, messages)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return parseScores(reply, len(memories)), nil
+}
+
+// complete the chat of the task with the prompts and user's content, returns the reply
+func (l *llm) complete(ctx context.Context, task Task, prompts []Message, content string) (string, error) {
+	return l.provider.Complete(ctx, l.models.model(task), l.messages(prompts, content))
+}
+
+func (l *llm) messages(prompts []Message, content string) []Message {
+	messages := make([]Message, 0, len(prompts)+1)
+	messages = append(messages, prompts...)
+	return append(messages, Message{Role: RoleUser, Content: content})
+}
+
+// ping checks whether the llm provider is reachable and the key is valid
+func (l *llm) ping(ctx context.Context) error {
+	return l.provider.Ping(ctx)
 }
@@ -4,18 +4,133 @@ import (
 	"context"
 	"testing"
 
+	openai "github.com/sashabaranov/go-openai"
 	"github.com/stretchr/testify/assert"
 )
 
 func TestScoreMemories(t *testing.T) {
-	loadEnv(t)
-	hs := Default()
+	hs := newTestHandlers(t)
 	res, err := hs.llm.ScoreMemories(context.TODO(), hs.prompts.ScoreImportance, []string{"我今天早上吃了一顿麦当劳。", "昨天晚上我弄丢了无线耳机。"})
 	assert.NoError(t, err)
 	assert.Equal(t, 2, len(res))
 
-  // only one memory
+	// only one memory
 	res, err = hs.llm.ScoreMemories(context.TODO(), hs.prompts.ScoreImportance, []string{"我今天下午可能要开会"})
 	assert.NoError(t, err)
 	assert.Equal(t, 1, len(res))
+
+	// the stub scores deterministically
+	again, err := hs.llm.ScoreMemories(context.TODO(), hs.prompts.ScoreImportance, []string{"我今天下午可能要开会"})
+	assert.NoError(t, err)
+	assert.Equal(t, res, again)
+	assert.GreaterOrEqual(t, res[0], MinImportance)
+	assert.LessOrEqual(t, res[0], MaxImportance)
+}
+
+func TestScoreMemoriesRetry(t *testing.T) {
+	hs := newTestHandlers(t)
+	var calls int
+	hs.llm.provider = NewOpenAILLM(&StubClient{Reply: func(messages []openai.ChatCompletionMessage) string {
+		calls++
+		switch messages[len(messages)-1].Content {
+		case "a;b;c":
+			// the second one is missing
+			return "1. 3\n3) 12"
+		case "b":
+			return "8/10"
+		}
+		return "I don't know"
+	}}, "")
+
+	// only the missing one is retried
+	scores, err := hs.llm.ScoreMemories(context.TODO(), hs.prompts.ScoreImportance, []string{"a", "b", "c"})
+	assert.NoError(t, err)
+	assert.Equal(t, []int{3, 8, 10}, scores)
+	assert.Equal(t, 2, calls)
+
+	scores, err = hs.llm.ScoreMemories(context.TODO(), hs.prompts.ScoreImportance, []string{"b", "d"})
+	assert.ErrorIs(t, err, ErrScoreMismatch)
+	assert.Equal(t, []int{8, 0}, scores)
+
+	// the failed one falls back to the default importance
+	memories := []Memory{{Metadata: MemoryMetadata{Content: "b"}}, {Metadata: MemoryMetadata{Content: "d"}}}
+	assert.Equal(t, []error{nil, nil}, hs.scoreImportance(context.TODO(), memories))
+	assert.Equal(t, 8, memories[0].Metadata.Importance)
+	assert.Equal(t, defaultImportance, memories[1].Metadata.Importance)
+}
+
+func TestScoreImportanceRateLimited(t *testing.T) {
+	hs := newTestHandlers(t)
+	provider := &rateLimitedLLM{LLM: hs.llm.provider, limit: 1}
+	hs.llm.provider = provider
+
+	// two batches of the unscored memories, the second is not sent once the first is rate limited
+	memories := make([]Memory, 2*scoreBatchSize+1)
+	for i := range memories {
+		memories[i].Metadata.Content = "memory"
+	}
+	memories[0].Metadata.Importance = 3
+	errs := hs.scoreImportance(context.TODO(), memories)
+	assert.Equal(t, 1, provider.calls)
+	assert.Nil(t, errs[0])
+	assert.Equal(t, 3, memories[0].Metadata.Importance)
+	assert.Equal(t, 2*scoreBatchSize, len(errs[1:]))
+	for _, err := range errs[1:] {
+		assert.ErrorIs(t, err, ErrScoreImportance)
+		assert.True(t, isRateLimited(err))
+	}
+	for _, m := range memories[1:] {
+		assert.Zero(t, m.Metadata.Importance)
+	}
+
+	// they are not added
+	provider.limit++
+	_, errs = hs.ingest(context.TODO(), "missing", []Memory{{Metadata: MemoryMetadata{Content: "hello"}}}, false)
+	assert.ErrorIs(t, errs[0], ErrScoreImportance)
+}
+
+func TestParseScores(t *testing.T) {
+	for _, c := range []struct {
+		reply  string
+		n      int
+		scores []int
+	}{
+		{"1, 8", 2, []int{1, 8}},
+		{"1，8。", 2, []int{1, 8}},
+		{"3\n7.", 2, []int{3, 7}},
+		{"7.5, 0, 11", 3, []int{8, 1, 10}},
+		{"1. 3\n2. 7", 2, []int{3, 7}},
+		{"2: 7\n1） 3", 2, []int{3, 7}},
+		{"8/10", 1, []int{8}},
+		{"1, 8, 9", 2, []int{0, 0}},
+		{"", 1, []int{0}},
+		{`{"scores": [{"index": 2, "score": 7}, {"index": 1, "score": "3"}, {"index": 3, "score": 5}]}`, 2, []int{3, 7}},
+		{`{"scores": [{"index": 1, "score": 3}, {"index": 1, "score": 4}, {"index": 2, "score": "high"}]}`, 2, []int{0, 0}},
+		{"```json\n[2, 9.9]\n```", 2, []int{2, 10}},
+		{`{"scores": [1e300]}`, 1, []int{10}},
+	} {
+		assert.Equal(t, c.scores, parseScores(c.reply, c.n), c.reply)
+	}
+
+	assert.Equal(t, MinImportance, clampImportance(-1))
+	assert.Equal(t, MaxImportance, clampImportance(12))
+	assert.Equal(t, 5, clampImportance(5))
+}
+
+func FuzzParseScores(f *testing.F) {
+	for _, seed := range []string{"1, 8", "1，8。", "1. 3\n2. 7", "8/10", `{"scores": [{"index": 1, "score": 3}]}`, "```json\n[2, 9]\n```"} {
+		f.Add(seed, uint8(2))
+	}
+
+	f.Fuzz(func(t *testing.T, reply string, n uint8) {
+		scores := parseScores(reply, int(n))
+		if len(scores) != int(n) {
+			t.Fatalf("got %d scores of %d memories", len(scores), n)
+		}
+		for _, score := range scores {
+			if score != 0 && (score < MinImportance || score > MaxImportance) {
+				t.Fatalf("score %d is out of range", score)
+			}
+		}
+	})
 }
@@ -1,7 +1,15 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
 	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
 
 	"github.com/gin-gonic/gin"
 	"github.com/joho/godotenv"
@@ -30,27 +38,64 @@ import (
 //	@host		localhost:8080
 //	@BasePath	/api/v1
 
-//	@securityDefinitions.basic	BasicAuth
+//	@securityDefinitions.apikey	ApiKeyAuth
+//	@in							header
+//	@name						X-API-Key
+//	@description				api key of the tenant, "Authorization: Bearer <key>" is accepted too
 
 // @externalDocs.description	OpenAPI
 // @externalDocs.url			https://swagger.io/resources/open-api/
 func main() {
-	err := godotenv.Load()
+	// .env is optional, the config file and flags can be used instead
+	if err := godotenv.Load(); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		log.Fatal(err)
+	}
+
+	cfg, err := memo.LoadConfig(os.Args[1:])
 	if err != nil {
 		log.Fatal(err)
 	}
 
+	// stop on ctrl-c or SIGTERM, even during the startup retries
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if cfg.Server.GinMode != "" {
+		gin.SetMode(cfg.Server.GinMode)
+	}
 	r := gin.Default()
 	v1 := r.Group("/api/v1")
 
-	handlers := memo.Default()
+	handlers, err := memo.New(ctx, cfg)
+	if err != nil {
+		log.Fatal(err)
+	}
 
-	v1.GET("/s", handlers.GetSessions)
-	v1.POST("/s/add", handlers.AddSession)
-	v1.DELETE("/s/:id/del", handlers.DeleteSession)
-	v1.GET("/s/:id", handlers.GetSession)
+	memo.RegisterRoutes(v1, handlers)
+	handlers.StartWorkers(ctx)
 
 	r.GET("/swagger/*any", ginSwagger.WrapHandler(swaggerFiles.Handler))
 
-	r.Run()
+	srv := &http.Server{Addr: fmt.Sprintf(":%d", cfg.Server.Port), Handler: r}
+
+	go func() {
+		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Fatal(err)
+		}
+	}()
+
+	<-ctx.Done()
+	stop()
+	log.Println("shutting down, press ctrl-c again to force")
+
+	// drain the in-flight requests, then close the connections
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.Server.ShutdownTimeout)
+	defer cancel()
+
+	if err := srv.Shutdown(ctx); err != nil {
+		log.Println("can't shutdown the server gracefully:", err)
+	}
+	if err := handlers.Close(ctx); err != nil {
+		log.Println("can't close the handlers:", err)
+	}
 }
@@ -0,0 +1,63 @@
+// reconcile finds and fixes the sessions which are inconsistent between mongodb and qdrant,
+// e.g. the documents or collections left by a failed creation or deletion,
+// the missing payload indexes of the existing collections are created too,
+// it only reports them unless "-fix" is given, which deletes the orphaned collections,
+// so the qdrant must not be shared with other deployments or session collections,
+// it takes the same config as the server, e.g. "go run ./cmd/reconcile -fix -config config.toml"
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"io/fs"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/joho/godotenv"
+	"github.com/sleep2death/memo-go"
+)
+
+func main() {
+	if err := godotenv.Load(); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		log.Fatal(err)
+	}
+
+	flags := flag.NewFlagSet("reconcile", flag.ExitOnError)
+	fix := flags.Bool("fix", false, "fix the inconsistent sessions, and delete the orphaned collections, only report them by default")
+	grace := flags.Duration("grace", 10*time.Minute, "skip the sessions changed recently, since they may be in flight")
+
+	cfg, err := memo.LoadConfigFlags(flags, os.Args[1:])
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	handlers, err := memo.New(ctx, cfg)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	report, err := handlers.Reconcile(ctx, *grace, *fix)
+	if report != nil {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(report)
+	}
+	if err != nil {
+		log.Println("can't reconcile:", err)
+	}
+
+	if err := handlers.Close(context.Background()); err != nil {
+		log.Println("can't close the handlers:", err)
+	}
+	if err != nil {
+		os.Exit(1)
+	}
+}
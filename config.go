@@ -0,0 +1,476 @@
+package memo
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+)
+
+// Config is the configuration of the whole server, it's loaded from the defaults,
+// a toml file, environment variables and command line flags, the latter ones take precedence
+type Config struct {
+	Server    ServerConfig    `toml:"server"`
+	Mongo     MongoConfig     `toml:"mongo"`
+	Qdrant    QdrantConfig    `toml:"qdrant"`
+	OpenAI    OpenAIConfig    `toml:"openai"`
+	LLM       LLMConfig       `toml:"llm"`
+	Embedding EmbeddingConfig `toml:"embedding"`
+	Memory    MemoryConfig    `toml:"memory"`
+	Auth      AuthConfig      `toml:"auth"`
+	Jobs      JobsConfig      `toml:"jobs"`
+
+	Prompts string `toml:"prompts"` // path of the prompts file, the embedded prompts are used if it's empty
+}
+
+type ServerConfig struct {
+	Port            int           `toml:"port"`
+	GinMode         string        `toml:"gin_mode"`         // debug, release or test
+	ShutdownTimeout time.Duration `toml:"shutdown_timeout"` // how long to drain the in-flight requests, e.g. "30s"
+}
+
+type MongoConfig struct {
+	URI        string `toml:"uri"`
+	DB         string `toml:"db"`
+	Collection string `toml:"collection"` // collection of the sessions
+}
+
+type QdrantConfig struct {
+	URI string `toml:"uri"` // grpc address
+}
+
+type OpenAIConfig struct {
+	APIKey string `toml:"api_key"`
+}
+
+type LLMConfig struct {
+	Provider   string     `toml:"provider"`    // openai, compatible, azure or anthropic
+	BaseURL    string     `toml:"base_url"`    // for the compatible and azure providers, optional for anthropic
+	APIKey     string     `toml:"api_key"`     // the openai provider uses openai.api_key if it's empty
+	APIVersion string     `toml:"api_version"` // for azure, optional
+	Model      string     `toml:"model"`       // default model of the tasks, the deployment name for azure
+	MaxTokens  int        `toml:"max_tokens"`  // for anthropic
+	Functions  bool       `toml:"functions"`   // score with function calling, disable it if the server doesn't support it
+	Models     TaskModels `toml:"models"`
+}
+
+// TaskModels overrides the model of each task, the default model is used if it's empty
+type TaskModels struct {
+	Importance    string `toml:"importance"`
+	Reflection    string `toml:"reflection"`
+	Planning      string `toml:"planning"`
+	Summarization string `toml:"summarization"`
+}
+
+func (m TaskModels) model(task Task) string {
+	switch task {
+	case TaskImportance:
+		return m.Importance
+	case TaskReflection:
+		return m.Reflection
+	case TaskPlanning:
+		return m.Planning
+	case TaskSummarization:
+		return m.Summarization
+	}
+	return ""
+}
+
+type EmbeddingConfig struct {
+	Provider  string      `toml:"provider"` // openai or compatible, e.g. a local ollama server
+	BaseURL   string      `toml:"base_url"` // for the compatible provider
+	Model     string      `toml:"model"`    // text-embedding-ada-002 by default for the openai provider
+	APIKey    string      `toml:"api_key"`  // the openai provider uses openai.api_key if it's empty, optional for compatible
+	Dimension int         `toml:"dimension"`
+	Cache     CacheConfig `toml:"cache"`
+}
+
+// CacheConfig is the cache of the embeddings, keyed by the model and the hash of the content
+type CacheConfig struct {
+	Backend  string        `toml:"backend"`   // none, lru or redis
+	Size     int           `toml:"size"`      // max entries of the lru cache
+	RedisURL string        `toml:"redis_url"` // e.g. redis://localhost:6379/0
+	TTL      time.Duration `toml:"ttl"`       // of the entries in redis, they never expire if it's 0
+}
+
+type MemoryConfig struct {
+	SearchLimit      int64       `toml:"search_limit"`      // default limit of search and listing
+	ReflectThreshold int         `toml:"reflect_threshold"` // accumulated importance to trigger a reflection, 0 to disable
+	ImportLimit      int64       `toml:"import_limit"`      // max bytes of an imported session, both compressed and decompressed
+	Batch            BatchConfig `toml:"batch"`
+}
+
+// JobsConfig is the queue of the memories added in background
+type JobsConfig struct {
+	Backend  string        `toml:"backend"`   // none or redis, the async memories are rejected without the queue
+	Workers  int           `toml:"workers"`   // workers of the server
+	RedisURL string        `toml:"redis_url"` // e.g. redis://localhost:6379/0
+	TTL      time.Duration `toml:"ttl"`       // how long the finished jobs are kept, and the unfinished ones without progress
+}
+
+type AuthConfig struct {
+	Keys     map[string]string `toml:"keys"`     // api key to tenant, required unless the authentication is disabled
+	Disabled bool              `toml:"disabled"` // all requests belong to the default tenant "", e.g. for local development
+}
+
+// DefaultConfig works with the services of docker/docker-compose.yaml, except the openai api key
+func DefaultConfig() Config {
+	return Config{
+		Server: ServerConfig{
+			Port:            8080,
+			GinMode:         "debug",
+			ShutdownTimeout: 30 * time.Second,
+		},
+		Mongo: MongoConfig{
+			URI:        "mongodb://localhost:27017",
+			DB:         "memo_db",
+			Collection: "sessions",
+		},
+		Qdrant: QdrantConfig{
+			URI: "localhost:6334",
+		},
+		LLM: LLMConfig{
+			Provider:  "openai",
+			MaxTokens: defaultMaxTokens,
+			Functions: true,
+		},
+		Embedding: EmbeddingConfig{
+			Provider:  "openai",
+			Dimension: defaultEmbeddingDimension,
+			Cache: CacheConfig{
+				Backend:  "lru",
+				Size:     defaultCacheSize,
+				RedisURL: "redis://localhost:6379/0",
+			},
+		},
+		Memory: MemoryConfig{
+			SearchLimit:      5,
+			ReflectThreshold: defaultReflectThreshold,
+			ImportLimit:      defaultImportLimit,
+			Batch:            defaultBatchConfig(),
+		},
+		Jobs: JobsConfig{
+			Backend:  "none",
+			Workers:  defaultWorkers,
+			RedisURL: "redis://localhost:6379/0",
+			TTL:      defaultJobTTL,
+		},
+	}
+}
+
+// configField binds a field of the config to its environment variable and flag
+type configField struct {
+	flag  string
+	env   string
+	usage string
+	value func(c *Config) any // pointer to the field
+}
+
+var configFields = []configField{
+	{"port", "PORT", "port to listen", func(c *Config) any { return &c.Server.Port }},
+	{"gin-mode", "GIN_MODE", "gin mode: debug, release or test", func(c *Config) any { return &c.Server.GinMode }},
+	{"shutdown-timeout", "SHUTDOWN_TIMEOUT", "how long to drain the in-flight requests", func(c *Config) any { return &c.Server.ShutdownTimeout }},
+	{"mongo-uri", "MONGO_URI", "mongodb uri", func(c *Config) any { return &c.Mongo.URI }},
+	{"mongo-db", "MONGO_DB", "mongodb database", func(c *Config) any { return &c.Mongo.DB }},
+	{"mongo-collection", "MONGO_SESSIONS", "mongodb collection of the sessions", func(c *Config) any { return &c.Mongo.Collection }},
+	{"qdrant-uri", "QDRANT_URI", "qdrant grpc address", func(c *Config) any { return &c.Qdrant.URI }},
+	{"openai-api-key", "OPENAI_API_KEY", "openai api key", func(c *Config) any { return &c.OpenAI.APIKey }},
+	{"llm-provider", "LLM_PROVIDER", "llm provider: openai, compatible, azure or anthropic", func(c *Config) any { return &c.LLM.Provider }},
+	{"llm-base-url", "LLM_BASE_URL", "base url of the llm provider", func(c *Config) any { return &c.LLM.BaseURL }},
+	{"llm-api-key", "LLM_API_KEY", "api key of the llm provider, the openai provider uses the openai api key if it's empty", func(c *Config) any { return &c.LLM.APIKey }},
+	{"llm-api-version", "LLM_API_VERSION", "api version of azure openai", func(c *Config) any { return &c.LLM.APIVersion }},
+	{"llm-model", "LLM_MODEL", "default model of the tasks, the deployment name for azure", func(c *Config) any { return &c.LLM.Model }},
+	{"llm-max-tokens", "LLM_MAX_TOKENS", "max tokens of the replies for anthropic", func(c *Config) any { return &c.LLM.MaxTokens }},
+	{"llm-functions", "LLM_FUNCTIONS", "score with function calling, disable it if the server doesn't support it", func(c *Config) any { return &c.LLM.Functions }},
+	{"llm-model-importance", "LLM_MODEL_IMPORTANCE", "model to score the importance of memories", func(c *Config) any { return &c.LLM.Models.Importance }},
+	{"llm-model-reflection", "LLM_MODEL_REFLECTION", "model to reflect on memories", func(c *Config) any { return &c.LLM.Models.Reflection }},
+	{"llm-model-planning", "LLM_MODEL_PLANNING", "model to plan the day", func(c *Config) any { return &c.LLM.Models.Planning }},
+	{"llm-model-summarization", "LLM_MODEL_SUMMARIZATION", "model to summarize the memories for planning", func(c *Config) any { return &c.LLM.Models.Summarization }},
+	{"embedding-provider", "EMBEDDING_PROVIDER", "embedding provider: openai or compatible", func(c *Config) any { return &c.Embedding.Provider }},
+	{"embedding-base-url", "EMBEDDING_BASE_URL", "base url of the compatible embedding provider", func(c *Config) any { return &c.Embedding.BaseURL }},
+	{"embedding-model", "EMBEDDING_MODEL", "embedding model, text-embedding-ada-002 by default for openai", func(c *Config) any { return &c.Embedding.Model }},
+	{"embedding-api-key", "EMBEDDING_API_KEY", "api key of the embedding provider, openai's api key by default", func(c *Config) any { return &c.Embedding.APIKey }},
+	{"embedding-dimension", "EMBEDDING_DIMENSION", "dimension of the embeddings", func(c *Config) any { return &c.Embedding.Dimension }},
+	{"embedding-cache", "EMBEDDING_CACHE", "embedding cache: none, lru or redis", func(c *Config) any { return &c.Embedding.Cache.Backend }},
+	{"embedding-cache-size", "EMBEDDING_CACHE_SIZE", "max entries of the lru embedding cache", func(c *Config) any { return &c.Embedding.Cache.Size }},
+	{"embedding-cache-redis-url", "EMBEDDING_CACHE_REDIS_URL", "redis url of the embedding cache", func(c *Config) any { return &c.Embedding.Cache.RedisURL }},
+	{"embedding-cache-ttl", "EMBEDDING_CACHE_TTL", "ttl of the embeddings in redis, they never expire if it's 0", func(c *Config) any { return &c.Embedding.Cache.TTL }},
+	{"search-limit", "SEARCH_LIMIT", "default limit of search and listing", func(c *Config) any { return &c.Memory.SearchLimit }},
+	{"reflect-threshold", "REFLECT_THRESHOLD", "accumulated importance to trigger a reflection, 0 to disable", func(c *Config) any { return &c.Memory.ReflectThreshold }},
+	{"import-limit", "IMPORT_LIMIT", "max bytes of an imported session, both compressed and decompressed", func(c *Config) any { return &c.Memory.ImportLimit }},
+	{"batch-size", "BATCH_SIZE", "max inputs of one embedding request", func(c *Config) any { return &c.Memory.Batch.Size }},
+	{"batch-tokens", "BATCH_TOKENS", "max estimated tokens of one embedding request", func(c *Config) any { return &c.Memory.Batch.Tokens }},
+	{"batch-concurrency", "BATCH_CONCURRENCY", "max embedding requests at the same time", func(c *Config) any { return &c.Memory.Batch.Concurrency }},
+	{"batch-upsert-size", "BATCH_UPSERT_SIZE", "max points of one upsert", func(c *Config) any { return &c.Memory.Batch.UpsertSize }},
+	{"jobs", "JOBS", "job queue: none or redis", func(c *Config) any { return &c.Jobs.Backend }},
+	{"jobs-workers", "JOBS_WORKERS", "workers of the jobs", func(c *Config) any { return &c.Jobs.Workers }},
+	{"jobs-redis-url", "JOBS_REDIS_URL", "redis url of the job queue", func(c *Config) any { return &c.Jobs.RedisURL }},
+	{"jobs-ttl", "JOBS_TTL", "how long the finished jobs are kept, and the unfinished ones without progress", func(c *Config) any { return &c.Jobs.TTL }},
+	{"api-keys", "MEMO_API_KEYS", "api keys and their tenants, e.g. key1:tenant1,key2:tenant2", func(c *Config) any { return &c.Auth.Keys }},
+	{"auth-disabled", "MEMO_AUTH_DISABLED", "disable the authentication, all requests belong to the default tenant", func(c *Config) any { return &c.Auth.Disabled }},
+	{"prompts", "MEMO_PROMPTS", "path of the prompts file, the embedded prompts are used if it's empty", func(c *Config) any { return &c.Prompts }},
+}
+
+// LoadConfig loads the config from the defaults, the toml file given by "-config" flag or MEMO_CONFIG,
+// environment variables and the other flags, then validates it
+func LoadConfig(args []string) (*Config, error) {
+	return LoadConfigFlags(flag.NewFlagSet("memo", flag.ContinueOnError), args)
+}
+
+// LoadConfigFlags is LoadConfig with the flag set, so a command can define its own flags
+func LoadConfigFlags(fs *flag.FlagSet, args []string) (*Config, error) {
+	path := fs.String("config", os.Getenv("MEMO_CONFIG"), "path of the toml config file")
+
+	// flags are applied after the file and environment variables
+	flags := map[string]string{}
+	for _, f := range configFields {
+		name := f.flag
+		fs.Func(name, f.usage+" (env "+f.env+")", func(s string) error {
+			flags[name] = s
+			return nil
+		})
+	}
+
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+
+	cfg := DefaultConfig()
+	if *path != "" {
+		md, err := toml.DecodeFile(*path, &cfg)
+		if err != nil {
+			return nil, fmt.Errorf("can't load config file %s: %w", *path, err)
+		}
+		if undecoded := md.Undecoded(); len(undecoded) > 0 {
+			return nil, fmt.Errorf("unknown keys in config file %s: %v", *path, undecoded)
+		}
+	}
+
+	for _, f := range configFields {
+		if s, ok := os.LookupEnv(f.env); ok && s != "" {
+			if err := setConfigValue(f.value(&cfg), s); err != nil {
+				return nil, fmt.Errorf("invalid %s: %w", f.env, err)
+			}
+		}
+	}
+
+	for _, f := range configFields {
+		if s, ok := flags[f.flag]; ok {
+			if err := setConfigValue(f.value(&cfg), s); err != nil {
+				return nil, fmt.Errorf("invalid -%s: %w", f.flag, err)
+			}
+		}
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return &cfg, nil
+}
+
+// setConfigValue parses the string into the field
+func setConfigValue(field any, s string) error {
+	switch v := field.(type) {
+	case *string:
+		*v = s
+	case *int:
+		n, err := strconv.Atoi(s)
+		if err != nil {
+			return err
+		}
+		*v = n
+	case *int64:
+		n, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return err
+		}
+		*v = n
+	case *bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return err
+		}
+		*v = b
+	case *time.Duration:
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return err
+		}
+		*v = d
+	case *map[string]string:
+		m := map[string]string{}
+		for _, pair := range strings.Split(s, ",") {
+			k, val, ok := strings.Cut(pair, ":")
+			if !ok {
+				return fmt.Errorf("%q is not a key:value pair", pair)
+			}
+			m[strings.TrimSpace(k)] = strings.TrimSpace(val)
+		}
+		*v = m
+	default:
+		return fmt.Errorf("unsupported config type %T", field)
+	}
+	return nil
+}
+
+// Validate reports all the invalid fields at once
+func (c *Config) Validate() error {
+	var errs []error
+	invalid := func(field string, format string, args ...any) {
+		errs = append(errs, fmt.Errorf("%s: %s", field, fmt.Sprintf(format, args...)))
+	}
+
+	if c.Server.Port <= 0 || c.Server.Port > 65535 {
+		invalid("server.port", "must be between 1 and 65535, got %d", c.Server.Port)
+	}
+	switch c.Server.GinMode {
+	case "", "debug", "release", "test":
+	default:
+		invalid("server.gin_mode", "must be debug, release or test, got %q", c.Server.GinMode)
+	}
+	if c.Server.ShutdownTimeout < 0 {
+		invalid("server.shutdown_timeout", "must not be negative")
+	}
+
+	if c.Mongo.URI == "" {
+		invalid("mongo.uri", "must not be empty")
+	}
+	if c.Mongo.DB == "" {
+		invalid("mongo.db", "must not be empty")
+	}
+	if c.Mongo.Collection == "" {
+		invalid("mongo.collection", "must not be empty")
+	}
+	if c.Qdrant.URI == "" {
+		invalid("qdrant.uri", "must not be empty")
+	}
+
+	// the openai api key is needed by openai's llm and embedder
+	needOpenAI := (c.LLM.Provider == "openai" && c.LLM.APIKey == "") || (c.Embedding.Provider == "openai" && c.Embedding.APIKey == "")
+	if needOpenAI && c.OpenAI.APIKey == "" {
+		errs = append(errs, fmt.Errorf("openai.api_key: %w", ErrInvalidOpenAPIKey))
+	}
+
+	switch c.LLM.Provider {
+	case "openai":
+	case "compatible", "azure":
+		if c.LLM.BaseURL == "" {
+			invalid("llm.base_url", "must not be empty for the %s provider", c.LLM.Provider)
+		}
+		if c.LLM.Model == "" {
+			invalid("llm.model", "must not be empty for the %s provider", c.LLM.Provider)
+		}
+		if c.LLM.Provider == "azure" && c.LLM.APIKey == "" {
+			invalid("llm.api_key", "must not be empty for the azure provider")
+		}
+	case "anthropic":
+		if c.LLM.APIKey == "" {
+			invalid("llm.api_key", "must not be empty for the anthropic provider")
+		}
+		if c.LLM.Model == "" {
+			invalid("llm.model", "must not be empty for the anthropic provider")
+		}
+		if c.LLM.MaxTokens <= 0 {
+			invalid("llm.max_tokens", "must be positive, got %d", c.LLM.MaxTokens)
+		}
+	default:
+		invalid("llm.provider", "must be openai, compatible, azure or anthropic, got %q", c.LLM.Provider)
+	}
+
+	switch c.Embedding.Provider {
+	case "openai":
+	case "compatible":
+		if c.Embedding.BaseURL == "" {
+			invalid("embedding.base_url", "must not be empty for the compatible provider")
+		}
+		if c.Embedding.Model == "" {
+			invalid("embedding.model", "must not be empty for the compatible provider")
+		}
+	default:
+		invalid("embedding.provider", "must be openai or compatible, got %q", c.Embedding.Provider)
+	}
+	if c.Embedding.Dimension <= 0 {
+		invalid("embedding.dimension", "must be positive, got %d", c.Embedding.Dimension)
+	}
+
+	switch c.Embedding.Cache.Backend {
+	case "none":
+	case "lru":
+		if c.Embedding.Cache.Size <= 0 {
+			invalid("embedding.cache.size", "must be positive, got %d", c.Embedding.Cache.Size)
+		}
+	case "redis":
+		if c.Embedding.Cache.RedisURL == "" {
+			invalid("embedding.cache.redis_url", "must not be empty for the redis cache")
+		}
+		if c.Embedding.Cache.TTL < 0 {
+			invalid("embedding.cache.ttl", "must not be negative")
+		}
+	default:
+		invalid("embedding.cache.backend", "must be none, lru or redis, got %q", c.Embedding.Cache.Backend)
+	}
+
+	if c.Memory.SearchLimit <= 0 {
+		invalid("memory.search_limit", "must be positive, got %d", c.Memory.SearchLimit)
+	}
+	if c.Memory.ReflectThreshold < 0 {
+		invalid("memory.reflect_threshold", "must not be negative, got %d", c.Memory.ReflectThreshold)
+	}
+	if c.Memory.ImportLimit <= 0 {
+		invalid("memory.import_limit", "must be positive, got %d", c.Memory.ImportLimit)
+	}
+	positive := func(field string, value int) {
+		if value <= 0 {
+			invalid(field, "must be positive, got %d", value)
+		}
+	}
+	positive("memory.batch.size", c.Memory.Batch.Size)
+	positive("memory.batch.tokens", c.Memory.Batch.Tokens)
+	positive("memory.batch.concurrency", c.Memory.Batch.Concurrency)
+	positive("memory.batch.upsert_size", c.Memory.Batch.UpsertSize)
+
+	switch c.Jobs.Backend {
+	case "none":
+	case "redis":
+		positive("jobs.workers", c.Jobs.Workers)
+		if c.Jobs.RedisURL == "" {
+			invalid("jobs.redis_url", "must not be empty for the redis queue")
+		}
+		if c.Jobs.TTL <= 0 {
+			invalid("jobs.ttl", "must be positive, got %v", c.Jobs.TTL)
+		}
+	default:
+		invalid("jobs.backend", "must be none or redis, got %q", c.Jobs.Backend)
+	}
+
+	if len(c.Auth.Keys) == 0 && !c.Auth.Disabled {
+		invalid("auth.keys", "must not be empty unless auth.disabled is true")
+	}
+	for key, tenant := range c.Auth.Keys {
+		if key == "" || tenant == "" {
+			invalid("auth.keys", "neither the key nor the tenant can be empty")
+			break
+		}
+		// e.g. the keys of the config file, which are not trimmed
+		if key != strings.TrimSpace(key) || tenant != strings.TrimSpace(tenant) {
+			invalid("auth.keys", "neither the key nor the tenant can start or end with spaces")
+			break
+		}
+	}
+
+	if c.Prompts != "" {
+		if _, err := os.Stat(c.Prompts); err != nil {
+			invalid("prompts", "%v", err)
+		}
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("invalid config:\n%w", errors.Join(errs...))
+	}
+	return nil
+}
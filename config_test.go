@@ -0,0 +1,115 @@
+package memo
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoadConfig(t *testing.T) {
+	t.Setenv("MEMO_CONFIG", "")
+	t.Setenv("OPENAI_API_KEY", "")
+
+	// the key is required
+	_, err := LoadConfig(nil)
+	assert.ErrorIs(t, err, ErrInvalidOpenAPIKey)
+
+	path := filepath.Join(t.TempDir(), "config.toml")
+	err = os.WriteFile(path, []byte(`
+[server]
+port = 9090
+shutdown_timeout = "5s"
+
+[openai]
+api_key = "file-key"
+
+[auth.keys]
+"file-api-key" = "tenant"
+
+[memory]
+search_limit = 10
+`), 0o644)
+	assert.NoError(t, err)
+
+	cfg, err := LoadConfig([]string{"-config", path})
+	assert.NoError(t, err)
+	assert.Equal(t, 9090, cfg.Server.Port)
+	assert.Equal(t, 5*time.Second, cfg.Server.ShutdownTimeout)
+	assert.Equal(t, "file-key", cfg.OpenAI.APIKey)
+	assert.Equal(t, int64(10), cfg.Memory.SearchLimit)
+	// defaults are kept
+	assert.Equal(t, "localhost:6334", cfg.Qdrant.URI)
+	assert.Equal(t, defaultReflectThreshold, cfg.Memory.ReflectThreshold)
+
+	// env overrides the file, and flags override env
+	t.Setenv("MEMO_CONFIG", path)
+	t.Setenv("PORT", "7070")
+	t.Setenv("SEARCH_LIMIT", "20")
+	cfg, err = LoadConfig([]string{"-port", "6060", "-llm-functions=false"})
+	assert.NoError(t, err)
+	assert.Equal(t, 6060, cfg.Server.Port)
+	assert.Equal(t, int64(20), cfg.Memory.SearchLimit)
+	assert.False(t, cfg.LLM.Functions)
+
+	t.Setenv("SEARCH_LIMIT", "many")
+	_, err = LoadConfig(nil)
+	assert.ErrorContains(t, err, "SEARCH_LIMIT")
+
+	_, err = LoadConfig([]string{"-unknown"})
+	assert.Error(t, err)
+
+	// unknown keys are typos
+	err = os.WriteFile(path, []byte("[mongo]\nurl = \"mongodb://localhost\"\n"), 0o644)
+	assert.NoError(t, err)
+	_, err = LoadConfig(nil)
+	assert.ErrorContains(t, err, "mongo.url")
+}
+
+func TestValidateConfig(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.OpenAI.APIKey = "key"
+	// the authentication can't be disabled silently
+	assert.ErrorContains(t, cfg.Validate(), "auth.keys")
+	cfg.Auth.Disabled = true
+	assert.NoError(t, cfg.Validate())
+	cfg.Auth = AuthConfig{Keys: map[string]string{"key": "tenant"}}
+	assert.NoError(t, cfg.Validate())
+
+	cfg.Server.Port = 0
+	cfg.Mongo.URI = ""
+	cfg.Embedding.Provider = "compatible"
+	cfg.Embedding.Cache.Backend = "memcached"
+	cfg.Jobs.Backend = "redis"
+	cfg.Jobs.Workers = 0
+	cfg.Memory.SearchLimit = -1
+	cfg.Prompts = "not-exist.toml"
+
+	err := cfg.Validate()
+	assert.Error(t, err)
+	for _, field := range []string{"server.port", "mongo.uri", "embedding.base_url", "embedding.model", "embedding.cache.backend", "jobs.workers", "memory.search_limit", "prompts"} {
+		assert.ErrorContains(t, err, field)
+	}
+}
+
+func TestLoadPrompts(t *testing.T) {
+	embedded, err := loadPrompts("")
+	assert.NoError(t, err)
+	assert.NotEmpty(t, embedded.ScoreImportance)
+	assert.NotEmpty(t, embedded.PlanMinutes)
+
+	fromFile, err := loadPrompts("prompts.toml")
+	assert.NoError(t, err)
+	assert.Equal(t, embedded, fromFile)
+
+	_, err = loadPrompts("not-exist.toml")
+	assert.Error(t, err)
+}
+
+func TestExampleConfig(t *testing.T) {
+	cfg, err := LoadConfig([]string{"-config", "config.example.toml", "-openai-api-key", "key", "-auth-disabled=true"})
+	assert.NoError(t, err)
+	assert.Equal(t, "release", cfg.Server.GinMode)
+}
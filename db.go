@@ -2,52 +2,40 @@ package memo
 
 import (
 	"context"
-	"os"
 
-	pb "github.com/qdrant/go-client/qdrant"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials/insecure"
 )
 
-// setup mongodb
-func SetupMongo() (*mongo.Collection, error) {
-	dbUri := os.Getenv("MONGO_URI")
-	if dbUri == "" {
-    dbUri = "mongodb://localhost:27017"
-	}
-
-	dbName := os.Getenv("MONGO_DB")
-	if dbName == "" {
-		dbName = "memo_db"
+// setup mongodb, the connection is verified by a ping
+func SetupMongo(ctx context.Context, cfg MongoConfig) (*mongo.Collection, error) {
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI(cfg.URI))
+	if err != nil {
+		return nil, err
 	}
 
-	client, err := mongo.Connect(context.TODO(), options.Client().ApplyURI(dbUri))
-	db := client.Database(dbName)
-
-	dbSess := os.Getenv("MONGO_SESSIONS")
-	if dbSess == "" {
-		dbSess = "sessions"
+	if err := client.Ping(ctx, readpref.Primary()); err != nil {
+		client.Disconnect(context.Background())
+		return nil, err
 	}
 
-	return db.Collection(dbSess), err
+	return client.Database(cfg.DB).Collection(cfg.Collection), nil
 }
 
-// setup qdrant
-func SetupQdrant() (pb.CollectionsClient, pb.PointsClient, error) {
-	uri := os.Getenv("QDRANT_URI")
-	if uri == "" {
-		uri = "localhost:6334"
-	}
-
-	conn, err := grpc.Dial(uri, grpc.WithTransportCredentials(insecure.NewCredentials()))
+// setup qdrant, the connection is verified by a health check
+func SetupQdrant(ctx context.Context, cfg QdrantConfig) (*grpc.ClientConn, error) {
+	conn, err := grpc.DialContext(ctx, cfg.URI, grpc.WithTransportCredentials(insecure.NewCredentials()))
 	if err != nil {
-		return nil, nil, err
+		return nil, err
 	}
 
-	collections := pb.NewCollectionsClient(conn)
-	points := pb.NewPointsClient(conn)
+	if err := NewQdrantVectorStore(conn).Ping(ctx); err != nil {
+		conn.Close()
+		return nil, err
+	}
 
-	return collections, points, nil
+	return conn, nil
 }
@@ -1,31 +1,255 @@
+//go:build integration
+
 package memo
 
 import (
 	"context"
 	"testing"
+	"time"
 
+	"github.com/google/uuid"
 	"github.com/joho/godotenv"
 	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
-func loadEnv(t *testing.T) {
+func loadEnv(t *testing.T) *Config {
 	err := godotenv.Load()
 	assert.NoError(t, err)
+	// the stores are tested without the handlers
+	t.Setenv("MEMO_AUTH_DISABLED", "true")
+
+	cfg, err := LoadConfig(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cfg
 }
 
 func TestSetupMongo(t *testing.T) {
-	loadEnv(t)
+	cfg := loadEnv(t)
+
+	m, err := SetupMongo(context.TODO(), cfg.Mongo)
+	assert.NoError(t, err)
+
+	store := NewMongoSessionStore(m)
+	assert.NoError(t, store.Ping(context.TODO()))
+	assert.NoError(t, store.Close(context.TODO()))
+}
+
+func TestMongoUpdateSession(t *testing.T) {
+	cfg := loadEnv(t)
+	ctx := context.TODO()
+
+	m, err := SetupMongo(ctx, cfg.Mongo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := NewMongoSessionStore(m)
+	defer store.Close(ctx)
 
-	m, err := SetupMongo()
+	owner := "test-" + primitive.NewObjectID().Hex()
+	id, err := store.Create(ctx, &Session{Name: "aspirin2d", Owner: owner, Tags: []string{"a", "b"}, Attributes: map[string]string{"location": "home"}})
 	assert.NoError(t, err)
+	defer store.Delete(ctx, id)
 
-	err = m.Database().Client().Ping(context.TODO(), nil)
+	sessions, err := store.List(ctx, SessionQuery{Owner: owner, Tags: []string{"a", "b"}})
 	assert.NoError(t, err)
+	assert.Equal(t, 1, len(sessions))
+
+	status, tags := "sleeping", []string{"b"}
+	sess, err := store.Update(ctx, id, &UpdateSessionRequest{Tags: &tags, Attributes: map[string]*string{"location": nil, "status": &status}}, time.Now())
+	assert.NoError(t, err)
+	assert.Equal(t, "aspirin2d", sess.Name)
+	assert.Equal(t, tags, sess.Tags)
+	assert.Equal(t, map[string]string{"status": status}, sess.Attributes)
+	assert.NotZero(t, sess.UpdatedAt)
+
+	sessions, err = store.List(ctx, SessionQuery{Owner: owner, Tags: []string{"a"}})
+	assert.NoError(t, err)
+	assert.Zero(t, len(sessions))
+
+	_, err = store.Update(ctx, primitive.NewObjectID(), &UpdateSessionRequest{}, time.Now())
+	assert.ErrorIs(t, err, ErrSessionNotFound)
+}
+
+func TestMongoResetImportance(t *testing.T) {
+	cfg := loadEnv(t)
+	ctx := context.TODO()
+
+	m, err := SetupMongo(ctx, cfg.Mongo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := NewMongoSessionStore(m)
+	defer store.Close(ctx)
+
+	// the session created before the reflection has no accumulated importance
+	id := primitive.NewObjectID()
+	_, err = m.InsertOne(ctx, bson.M{"_id": id, "name": "legacy"})
+	assert.NoError(t, err)
+	defer store.Delete(ctx, id)
+
+	_, err = store.ResetImportance(ctx, id, defaultReflectThreshold, time.Now())
+	assert.ErrorIs(t, err, ErrSessionNotFound)
+
+	at := time.Now()
+	sess, err := store.ResetImportance(ctx, id, 0, at)
+	assert.NoError(t, err)
+	assert.Equal(t, "legacy", sess.Name)
+
+	// the failed reflection is undone
+	assert.NoError(t, store.RestoreImportance(ctx, id, 10, sess.ReflectedAt, at))
+	sess, err = store.Get(ctx, id)
+	assert.NoError(t, err)
+	assert.Equal(t, 10, sess.AccImportance)
+	assert.Zero(t, sess.ReflectedAt)
+}
+
+func TestScoreMemoriesOpenAI(t *testing.T) {
+	hs, err := New(context.TODO(), loadEnv(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := hs.llm.ScoreMemories(context.TODO(), hs.prompts.ScoreImportance, []string{"我今天早上吃了一顿麦当劳。", "昨天晚上我弄丢了无线耳机。"})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(res))
+
+	// only one memory
+	res, err = hs.llm.ScoreMemories(context.TODO(), hs.prompts.ScoreImportance, []string{"我今天下午可能要开会"})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(res))
 }
 
 func TestSetupQdrant(t *testing.T) {
-	loadEnv(t)
+	cfg := loadEnv(t)
+
+	conn, err := SetupQdrant(context.TODO(), cfg.Qdrant)
+	assert.NoError(t, err)
+
+	store := NewQdrantVectorStore(conn)
+	assert.NoError(t, store.Ping(context.TODO()))
+	assert.NoError(t, store.Close(context.TODO()))
+}
+
+func TestQdrantStats(t *testing.T) {
+	cfg := loadEnv(t)
+	ctx := context.TODO()
+
+	conn, err := SetupQdrant(ctx, cfg.Qdrant)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := NewQdrantVectorStore(conn)
+	defer store.Close(ctx)
+
+	_, err = store.Stats(ctx, primitive.NewObjectID().Hex())
+	assert.ErrorIs(t, err, ErrCollectionNotFound)
+
+	name := primitive.NewObjectID().Hex()
+	_, err = store.EnsureCollection(ctx, name, 8)
+	assert.NoError(t, err)
+	defer store.DeleteCollection(ctx, name)
+
+	created := time.Date(2023, 6, 1, 8, 0, 0, 0, time.UTC)
+	err = store.Upsert(ctx, name, []Memory{
+		{ID: uuid.NewString(), Embedding: hashEmbedding("a", 8), Metadata: MemoryMetadata{Type: BasicMemory, Content: "a", Importance: 3, CreatedAt: created}},
+		{ID: uuid.NewString(), Embedding: hashEmbedding("b", 8), Metadata: MemoryMetadata{Type: PlanMemory, Content: "b", Importance: 3, CreatedAt: created.Add(time.Hour)}},
+	})
+	assert.NoError(t, err)
 
-	_, _, err := SetupQdrant()
+	stats, err := store.Stats(ctx, name)
 	assert.NoError(t, err)
+	assert.Equal(t, uint64(2), stats.Count)
+	assert.Equal(t, uint64(1), stats.Types["plan"])
+	assert.Equal(t, map[int]uint64{3: 2}, stats.Importance)
+	assert.True(t, created.Equal(*stats.OldestAt))
+	assert.True(t, created.Add(time.Hour).Equal(*stats.NewestAt))
+	assert.Equal(t, 8, stats.Collection.Dimension)
+}
+
+func TestRedisEmbeddingCache(t *testing.T) {
+	cfg := loadEnv(t)
+	cfg.Embedding.Cache.Backend = "redis"
+	cfg.Embedding.Cache.TTL = time.Minute
+	ctx := context.TODO()
+
+	cache, err := NewEmbeddingCache(cfg.Embedding.Cache)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cache.Close()
+
+	key := "test:" + primitive.NewObjectID().Hex()
+	assert.NoError(t, cache.Set(ctx, []string{key}, [][]float32{{0.5, -1}}))
+
+	vectors, err := cache.Get(ctx, []string{key, key + ":missing"})
+	assert.NoError(t, err)
+	assert.Equal(t, [][]float32{{0.5, -1}, nil}, vectors)
+}
+
+func TestRedisJobQueue(t *testing.T) {
+	cfg := loadEnv(t)
+	cfg.Jobs.Backend = "redis"
+	ctx := context.TODO()
+
+	q, err := NewJobQueue(cfg.Jobs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer q.Close()
+
+	job := &Job{ID: "test-" + primitive.NewObjectID().Hex(), State: JobQueued, Total: 1}
+	req := &AddMemoriesRequest{Memories: []Memory{{Metadata: MemoryMetadata{Content: "hello"}}}}
+	assert.NoError(t, q.Enqueue(ctx, job, req))
+
+	queued, payload, err := q.Dequeue(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, job.ID, queued.ID)
+	assert.Equal(t, "hello", payload.Memories[0].Metadata.Content)
+
+	// the unfinished job expires
+	rq := q.(*RedisJobQueue)
+	ttl, err := rq.client.TTL(ctx, jobKeyPrefix+job.ID+":payload").Result()
+	assert.NoError(t, err)
+	assert.Greater(t, ttl, time.Duration(0))
+
+	// the job is kept in the processing list until it's finished, and requeued if it's stale
+	processing := func() []string {
+		ids, err := rq.client.LRange(ctx, jobProcessingKey, 0, -1).Result()
+		assert.NoError(t, err)
+		return ids
+	}
+	assert.Contains(t, processing(), job.ID)
+	assert.NoError(t, rq.client.HSet(ctx, jobLeasesKey, job.ID, time.Now().Add(-jobStaleAfter).UnixMilli()).Err())
+	assert.NoError(t, rq.reap(ctx))
+	assert.NotContains(t, processing(), job.ID)
+
+	queued, _, err = q.Dequeue(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, job.ID, queued.ID)
+	job.State = JobRunning
+	job.Counted = 1
+	assert.NoError(t, q.Update(ctx, job))
+	assert.NoError(t, rq.reap(ctx))
+	assert.Contains(t, processing(), job.ID)
+
+	// the counted memories are loaded for a rerun, but not returned with the job
+	queued, _, err = rq.load(ctx, job.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, queued.Counted)
+	b, err := rq.client.Get(ctx, jobKeyPrefix+job.ID).Bytes()
+	assert.NoError(t, err)
+	assert.NotContains(t, string(b), "counted")
+
+	job.State = JobCompleted
+	assert.NoError(t, q.Update(ctx, job))
+	assert.NotContains(t, processing(), job.ID)
+	job, err = q.Get(ctx, job.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, JobCompleted, job.State)
+
+	_, err = q.Get(ctx, "unknown")
+	assert.ErrorIs(t, err, ErrJobNotFound)
 }
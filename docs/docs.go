@@ -25,8 +25,73 @@ const docTemplate = `{
     "host": "{{.Host}}",
     "basePath": "{{.BasePath}}",
     "paths": {
-        "/m/:session": {
+        "/healthz": {
             "get": {
+                "description": "the server is running",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "health"
+                ],
+                "summary": "liveness probe",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/memo.OK"
+                        }
+                    }
+                }
+            }
+        },
+        "/jobs/{id}": {
+            "get": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "the progress of the job which adds the memories in background, with the result of each memory when it's finished",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "jobs"
+                ],
+                "summary": "get a job",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "the job id",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/memo.Job"
+                        }
+                    },
+                    "default": {
+                        "description": "",
+                        "schema": {
+                            "$ref": "#/definitions/memo.APIError"
+                        }
+                    }
+                }
+            }
+        },
+        "/m/{session}": {
+            "get": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
                 "description": "get all memories in this session",
                 "consumes": [
                     "application/json"
@@ -58,6 +123,40 @@ const docTemplate = `{
                         "description": "pagination limit",
                         "name": "limit",
                         "in": "query"
+                    },
+                    {
+                        "type": "array",
+                        "items": {
+                            "type": "string"
+                        },
+                        "collectionFormat": "multi",
+                        "description": "any of the memory types",
+                        "name": "type",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "importance greater than or equal to",
+                        "name": "min_importance",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "importance less than or equal to",
+                        "name": "max_importance",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "RFC3339 time, created at or after",
+                        "name": "created_after",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "RFC3339 time, created before",
+                        "name": "created_before",
+                        "in": "query"
                     }
                 ],
                 "responses": {
@@ -76,9 +175,14 @@ const docTemplate = `{
                 }
             }
         },
-        "/m/:session/add": {
-            "put": {
-                "description": "add one or more memories to the session",
+        "/m/{session}/add": {
+            "post": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "add one or more memories to the session, they are embedded and upserted in batches,\n207 if some of them failed, with the error of each in the results,\nor 202 with the job and the ids of the memories if it's async, see /jobs/{id} for its progress",
                 "consumes": [
                     "application/json"
                 ],
@@ -103,7 +207,7 @@ const docTemplate = `{
                         "in": "body",
                         "required": true,
                         "schema": {
-                            "$ref": "#/definitions/memo.AddMemoryRequest"
+                            "$ref": "#/definitions/memo.AddMemoriesRequest"
                         }
                     }
                 ],
@@ -111,7 +215,19 @@ const docTemplate = `{
                     "200": {
                         "description": "OK",
                         "schema": {
-                            "$ref": "#/definitions/memo.AddMemoryResponse"
+                            "$ref": "#/definitions/memo.AddMemoriesResponse"
+                        }
+                    },
+                    "202": {
+                        "description": "Accepted",
+                        "schema": {
+                            "$ref": "#/definitions/memo.Job"
+                        }
+                    },
+                    "207": {
+                        "description": "Multi-Status",
+                        "schema": {
+                            "$ref": "#/definitions/memo.AddMemoriesResponse"
                         }
                     },
                     "default": {
@@ -123,9 +239,14 @@ const docTemplate = `{
                 }
             }
         },
-        "/m/:session/search": {
-            "get": {
-                "description": "search memory by similarity",
+        "/m/{session}/del": {
+            "post": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "delete the memories by ids, or by filter if no id is given",
                 "consumes": [
                     "application/json"
                 ],
@@ -135,7 +256,7 @@ const docTemplate = `{
                 "tags": [
                     "memories"
                 ],
-                "summary": "search memory by similarity",
+                "summary": "delete memories",
                 "parameters": [
                     {
                         "type": "string",
@@ -145,12 +266,12 @@ const docTemplate = `{
                         "required": true
                     },
                     {
-                        "description": "query object",
+                        "description": "ids or filter",
                         "name": "query",
                         "in": "body",
                         "required": true,
                         "schema": {
-                            "$ref": "#/definitions/memo.SearchMemoryRequest"
+                            "$ref": "#/definitions/memo.DeleteMemoriesRequest"
                         }
                     }
                 ],
@@ -158,7 +279,7 @@ const docTemplate = `{
                     "200": {
                         "description": "OK",
                         "schema": {
-                            "$ref": "#/definitions/memo.RetrieveMemoriesResponse"
+                            "$ref": "#/definitions/memo.DeleteMemoriesResponse"
                         }
                     },
                     "default": {
@@ -170,38 +291,47 @@ const docTemplate = `{
                 }
             }
         },
-        "/s": {
-            "get": {
-                "description": "list all sessions",
+        "/m/{session}/get": {
+            "post": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "get the memories by ids",
+                "consumes": [
+                    "application/json"
+                ],
                 "produces": [
                     "application/json"
                 ],
                 "tags": [
-                    "sessions"
+                    "memories"
                 ],
-                "summary": "get all sessions",
+                "summary": "get memories",
                 "parameters": [
                     {
                         "type": "string",
-                        "description": "pagination offset id",
-                        "name": "offset",
-                        "in": "query"
+                        "description": "memory belonging to which session",
+                        "name": "session",
+                        "in": "path",
+                        "required": true
                     },
                     {
-                        "type": "integer",
-                        "description": "pagination limit, default is 5",
-                        "name": "limit",
-                        "in": "query"
+                        "description": "ids of the memories",
+                        "name": "query",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/memo.GetMemoriesRequest"
+                        }
                     }
                 ],
                 "responses": {
                     "200": {
                         "description": "OK",
                         "schema": {
-                            "type": "array",
-                            "items": {
-                                "$ref": "#/definitions/memo.Session"
-                            }
+                            "$ref": "#/definitions/memo.GetMemoriesResponse"
                         }
                     },
                     "default": {
@@ -213,9 +343,58 @@ const docTemplate = `{
                 }
             }
         },
-        "/s/:id": {
+        "/m/{session}/plan": {
             "get": {
-                "description": "get one sessions",
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "what is the agent doing at the given time, the finest plan first",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "plans"
+                ],
+                "summary": "get current plan",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "plan belonging to which session",
+                        "name": "session",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "RFC3339 time, default is now",
+                        "name": "at",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/memo.RetrieveMemoriesResponse"
+                        }
+                    },
+                    "default": {
+                        "description": "",
+                        "schema": {
+                            "$ref": "#/definitions/memo.APIError"
+                        }
+                    }
+                }
+            },
+            "post": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "generate a day plan for the session's agent, and decompose it into hours and minutes, the previous plans of the day are replaced",
                 "consumes": [
                     "application/json"
                 ],
@@ -223,23 +402,31 @@ const docTemplate = `{
                     "application/json"
                 ],
                 "tags": [
-                    "sessions"
+                    "plans"
                 ],
-                "summary": "get one session by id",
+                "summary": "plan the day",
                 "parameters": [
                     {
                         "type": "string",
-                        "description": "the session to get",
-                        "name": "id",
+                        "description": "plan belonging to which session",
+                        "name": "session",
                         "in": "path",
                         "required": true
+                    },
+                    {
+                        "description": "plan options",
+                        "name": "plan",
+                        "in": "body",
+                        "schema": {
+                            "$ref": "#/definitions/memo.PlanRequest"
+                        }
                     }
                 ],
                 "responses": {
                     "200": {
                         "description": "OK",
                         "schema": {
-                            "$ref": "#/definitions/memo.Session"
+                            "$ref": "#/definitions/memo.PlanResponse"
                         }
                     },
                     "default": {
@@ -251,24 +438,26 @@ const docTemplate = `{
                 }
             }
         },
-        "/s/:id/del": {
-            "delete": {
-                "description": "remove one sessions",
-                "consumes": [
-                    "application/json"
+        "/m/{session}/reflect": {
+            "post": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
                 ],
+                "description": "synthesize higher-level reflections from the session's recent memories",
                 "produces": [
                     "application/json"
                 ],
                 "tags": [
-                    "sessions"
+                    "memories"
                 ],
-                "summary": "remove one session",
+                "summary": "reflect memories",
                 "parameters": [
                     {
                         "type": "string",
-                        "description": "the session to delete",
-                        "name": "id",
+                        "description": "memory belonging to which session",
+                        "name": "session",
                         "in": "path",
                         "required": true
                     }
@@ -277,7 +466,7 @@ const docTemplate = `{
                     "200": {
                         "description": "OK",
                         "schema": {
-                            "$ref": "#/definitions/memo.OK"
+                            "$ref": "#/definitions/memo.ReflectResponse"
                         }
                     },
                     "default": {
@@ -289,9 +478,14 @@ const docTemplate = `{
                 }
             }
         },
-        "/s/add": {
-            "put": {
-                "description": "add a sessions",
+        "/m/{session}/search": {
+            "post": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "search memory by similarity",
                 "consumes": [
                     "application/json"
                 ],
@@ -299,17 +493,24 @@ const docTemplate = `{
                     "application/json"
                 ],
                 "tags": [
-                    "sessions"
+                    "memories"
                 ],
-                "summary": "create a session",
+                "summary": "search memory by similarity",
                 "parameters": [
                     {
-                        "description": "the session to be created",
+                        "type": "string",
+                        "description": "memory belonging to which session",
                         "name": "session",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "query object",
+                        "name": "query",
                         "in": "body",
                         "required": true,
                         "schema": {
-                            "$ref": "#/definitions/memo.Session"
+                            "$ref": "#/definitions/memo.SearchMemoryRequest"
                         }
                     }
                 ],
@@ -317,7 +518,7 @@ const docTemplate = `{
                     "200": {
                         "description": "OK",
                         "schema": {
-                            "$ref": "#/definitions/mongo.InsertOneResult"
+                            "$ref": "#/definitions/memo.RetrieveMemoriesResponse"
                         }
                     },
                     "default": {
@@ -328,67 +529,1134 @@ const docTemplate = `{
                     }
                 }
             }
-        }
-    },
-    "definitions": {
-        "memo.APIError": {
-            "type": "object",
-            "properties": {
-                "code": {
-                    "type": "integer",
-                    "example": 400
-                },
-                "message": {
-                    "type": "string",
-                    "example": "status bad request"
-                }
-            }
-        },
-        "memo.AddMemoryRequest": {
-            "type": "object",
-            "properties": {
-                "memories": {
-                    "type": "array",
-                    "items": {
-                        "$ref": "#/definitions/memo.Memory"
-                    }
-                }
-            }
-        },
-        "memo.AddMemoryResponse": {
-            "type": "object",
-            "properties": {
-                "ids": {
-                    "description": "inserted memory id in qdrant",
-                    "type": "array",
-                    "items": {
-                        "type": "string"
-                    }
-                }
-            }
         },
-        "memo.Memory": {
-            "type": "object",
-            "properties": {
-                "embedding": {
-                    "type": "array",
-                    "items": {
-                        "type": "number"
+        "/m/{session}/{id}": {
+            "get": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
                     }
-                },
-                "id": {
-                    "type": "string"
-                },
-                "metadata": {
-                    "$ref": "#/definitions/memo.MemoryMetadata"
-                }
+                ],
+                "description": "get one memory by id",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "memories"
+                ],
+                "summary": "get one memory",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "memory belonging to which session",
+                        "name": "session",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "the memory to get",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "boolean",
+                        "description": "include the embedding",
+                        "name": "vectors",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/memo.Memory"
+                        }
+                    },
+                    "default": {
+                        "description": "",
+                        "schema": {
+                            "$ref": "#/definitions/memo.APIError"
+                        }
+                    }
+                }
+            },
+            "delete": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "delete one memory from the session",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "memories"
+                ],
+                "summary": "delete one memory",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "memory belonging to which session",
+                        "name": "session",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "the memory to delete",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/memo.OK"
+                        }
+                    },
+                    "default": {
+                        "description": "",
+                        "schema": {
+                            "$ref": "#/definitions/memo.APIError"
+                        }
+                    }
+                }
+            },
+            "patch": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "update the given fields of the memory, it's re-embedded if the content changes",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "memories"
+                ],
+                "summary": "update one memory",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "memory belonging to which session",
+                        "name": "session",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "the memory to update",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "the fields to update",
+                        "name": "memory",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/memo.UpdateMemoryRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/memo.Memory"
+                        }
+                    },
+                    "default": {
+                        "description": "",
+                        "schema": {
+                            "$ref": "#/definitions/memo.APIError"
+                        }
+                    }
+                }
+            }
+        },
+        "/metrics": {
+            "get": {
+                "description": "the hits and misses of the embedding cache since the server started",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "health"
+                ],
+                "summary": "metrics of the server",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/memo.MetricsResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/readyz": {
+            "get": {
+                "description": "ping mongodb, qdrant and the llm provider",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "health"
+                ],
+                "summary": "readiness probe",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/memo.ReadyResponse"
+                        }
+                    },
+                    "503": {
+                        "description": "Service Unavailable",
+                        "schema": {
+                            "$ref": "#/definitions/memo.ReadyResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/s": {
+            "get": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "list all sessions",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "sessions"
+                ],
+                "summary": "get all sessions",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "pagination offset id",
+                        "name": "offset",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "pagination limit, default is 5",
+                        "name": "limit",
+                        "in": "query"
+                    },
+                    {
+                        "type": "array",
+                        "items": {
+                            "type": "string"
+                        },
+                        "collectionFormat": "multi",
+                        "description": "only the sessions with all the tags, repeated or separated by commas",
+                        "name": "tag",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/memo.Session"
+                            }
+                        }
+                    },
+                    "default": {
+                        "description": "",
+                        "schema": {
+                            "$ref": "#/definitions/memo.APIError"
+                        }
+                    }
+                }
+            }
+        },
+        "/s/add": {
+            "post": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "add a sessions",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "sessions"
+                ],
+                "summary": "create a session",
+                "parameters": [
+                    {
+                        "description": "the session to be created",
+                        "name": "session",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/memo.AddSessionRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/memo.SessionAddResponse"
+                        }
+                    },
+                    "default": {
+                        "description": "",
+                        "schema": {
+                            "$ref": "#/definitions/memo.APIError"
+                        }
+                    }
+                }
+            }
+        },
+        "/s/import": {
+            "post": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "create a session from an export in jsonl or tar.gz, the memories are re-embedded\nif they have no vectors or they were embedded by another model",
+                "consumes": [
+                    "application/x-ndjson",
+                    "application/gzip"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "sessions"
+                ],
+                "summary": "import a session",
+                "parameters": [
+                    {
+                        "description": "the exported session",
+                        "name": "archive",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/memo.ImportSessionResponse"
+                        }
+                    },
+                    "default": {
+                        "description": "",
+                        "schema": {
+                            "$ref": "#/definitions/memo.APIError"
+                        }
+                    }
+                }
+            }
+        },
+        "/s/{id}": {
+            "get": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "get one sessions",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "sessions"
+                ],
+                "summary": "get one session by id",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "the session to get",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/memo.Session"
+                        }
+                    },
+                    "default": {
+                        "description": "",
+                        "schema": {
+                            "$ref": "#/definitions/memo.APIError"
+                        }
+                    }
+                }
+            },
+            "patch": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "change the given fields of the session, the attributes are merged and the null ones are removed",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "sessions"
+                ],
+                "summary": "update one session",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "the session to update",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "the fields to update",
+                        "name": "session",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/memo.UpdateSessionRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/memo.Session"
+                        }
+                    },
+                    "default": {
+                        "description": "",
+                        "schema": {
+                            "$ref": "#/definitions/memo.APIError"
+                        }
+                    }
+                }
+            }
+        },
+        "/s/{id}/del": {
+            "delete": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "remove one sessions, 409 if it's being created",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "sessions"
+                ],
+                "summary": "remove one session",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "the session to delete",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/memo.OK"
+                        }
+                    },
+                    "default": {
+                        "description": "",
+                        "schema": {
+                            "$ref": "#/definitions/memo.APIError"
+                        }
+                    }
+                }
+            }
+        },
+        "/s/{id}/export": {
+            "get": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "stream the session and all of its memories as jsonl, or as a tar.gz archive of session.json and memories/{id}.json,\nthe stream is truncated if it fails in the middle",
+                "produces": [
+                    "application/x-ndjson",
+                    "application/gzip"
+                ],
+                "tags": [
+                    "sessions"
+                ],
+                "summary": "export one session",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "the session to export",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "enum": [
+                            "jsonl",
+                            "tar.gz"
+                        ],
+                        "type": "string",
+                        "default": "jsonl",
+                        "description": "jsonl or tar.gz",
+                        "name": "format",
+                        "in": "query"
+                    },
+                    {
+                        "type": "boolean",
+                        "description": "include the vectors",
+                        "name": "vectors",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "file"
+                        }
+                    },
+                    "default": {
+                        "description": "",
+                        "schema": {
+                            "$ref": "#/definitions/memo.APIError"
+                        }
+                    }
+                }
+            }
+        },
+        "/s/{id}/fork": {
+            "post": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "create a new session with the metadata and memories of the session, the vectors are copied without re-embedding",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "sessions"
+                ],
+                "summary": "fork one session",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "the session to fork",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "the name of the new session, and the time to branch at",
+                        "name": "fork",
+                        "in": "body",
+                        "schema": {
+                            "$ref": "#/definitions/memo.ForkSessionRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/memo.ForkSessionResponse"
+                        }
+                    },
+                    "default": {
+                        "description": "",
+                        "schema": {
+                            "$ref": "#/definitions/memo.APIError"
+                        }
+                    }
+                }
+            }
+        },
+        "/s/{id}/stats": {
+            "get": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "count the memories by type and importance, and report the status of the collection",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "sessions"
+                ],
+                "summary": "get the statistics of one session",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "the session to get",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/memo.SessionStats"
+                        }
+                    },
+                    "default": {
+                        "description": "",
+                        "schema": {
+                            "$ref": "#/definitions/memo.APIError"
+                        }
+                    }
+                }
+            }
+        }
+    },
+    "definitions": {
+        "memo.APIError": {
+            "type": "object",
+            "properties": {
+                "code": {
+                    "type": "integer",
+                    "example": 400
+                },
+                "message": {
+                    "type": "string",
+                    "example": "status bad request"
+                }
+            }
+        },
+        "memo.AddMemoriesRequest": {
+            "type": "object",
+            "properties": {
+                "async": {
+                    "description": "add the memories in background, the job is returned",
+                    "type": "boolean"
+                },
+                "memories": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/memo.Memory"
+                    }
+                },
+                "skip_scoring": {
+                    "description": "don't score the missing importance with llm",
+                    "type": "boolean"
+                }
+            }
+        },
+        "memo.AddMemoriesResponse": {
+            "type": "object",
+            "properties": {
+                "ids": {
+                    "description": "inserted memory id in qdrant",
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "results": {
+                    "description": "one for each memory in the same order",
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/memo.AddMemoryResult"
+                    }
+                }
+            }
+        },
+        "memo.AddMemoryResult": {
+            "type": "object",
+            "properties": {
+                "error": {
+                    "type": "string"
+                },
+                "id": {
+                    "type": "string"
+                }
+            }
+        },
+        "memo.AddSessionRequest": {
+            "type": "object",
+            "properties": {
+                "attributes": {
+                    "description": "free-form traits, e.g. persona, location or current status",
+                    "type": "object",
+                    "additionalProperties": {
+                        "type": "string"
+                    }
+                },
+                "created_at": {
+                    "description": "created time, now if it's not given",
+                    "type": "integer"
+                },
+                "desc": {
+                    "description": "agent's description",
+                    "type": "string"
+                },
+                "name": {
+                    "description": "agent's name",
+                    "type": "string"
+                },
+                "tags": {
+                    "description": "for filtering the sessions",
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                }
+            }
+        },
+        "memo.CacheStats": {
+            "type": "object",
+            "properties": {
+                "backend": {
+                    "type": "string",
+                    "example": "lru"
+                },
+                "entries": {
+                    "description": "of the lru cache",
+                    "type": "integer"
+                },
+                "errors": {
+                    "description": "failed reads and writes of the cache, the inputs are embedded anyway",
+                    "type": "integer"
+                },
+                "hit_rate": {
+                    "type": "number"
+                },
+                "hits": {
+                    "type": "integer"
+                },
+                "misses": {
+                    "type": "integer"
+                }
+            }
+        },
+        "memo.CollectionInfo": {
+            "type": "object",
+            "properties": {
+                "dimension": {
+                    "type": "integer"
+                },
+                "points": {
+                    "type": "integer"
+                },
+                "segments": {
+                    "type": "integer"
+                },
+                "status": {
+                    "description": "green, yellow or red",
+                    "type": "string"
+                },
+                "vectors": {
+                    "type": "integer"
+                }
+            }
+        },
+        "memo.DeleteMemoriesRequest": {
+            "type": "object",
+            "properties": {
+                "filter": {
+                    "$ref": "#/definitions/memo.MemoryFilter"
+                },
+                "ids": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                }
+            }
+        },
+        "memo.DeleteMemoriesResponse": {
+            "type": "object",
+            "properties": {
+                "deleted": {
+                    "description": "how many memories are deleted",
+                    "type": "integer"
+                },
+                "not_found": {
+                    "description": "the given ids which don't exist",
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                }
+            }
+        },
+        "memo.ForkSessionRequest": {
+            "type": "object",
+            "properties": {
+                "name": {
+                    "type": "string"
+                },
+                "until": {
+                    "description": "only the memories created at or before",
+                    "type": "string"
+                }
+            }
+        },
+        "memo.ForkSessionResponse": {
+            "type": "object",
+            "properties": {
+                "_id": {
+                    "type": "string"
+                },
+                "memories": {
+                    "description": "number of the copied memories",
+                    "type": "integer"
+                }
+            }
+        },
+        "memo.GetMemoriesRequest": {
+            "type": "object",
+            "properties": {
+                "ids": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "with_vectors": {
+                    "description": "include the embeddings",
+                    "type": "boolean"
+                }
+            }
+        },
+        "memo.GetMemoriesResponse": {
+            "type": "object",
+            "properties": {
+                "memories": {
+                    "description": "in the same order as the ids",
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/memo.Memory"
+                    }
+                },
+                "not_found": {
+                    "description": "the given ids which don't exist",
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                }
+            }
+        },
+        "memo.ImportSessionResponse": {
+            "type": "object",
+            "properties": {
+                "_id": {
+                    "type": "string"
+                },
+                "embedded": {
+                    "description": "number of the memories which are re-embedded",
+                    "type": "integer"
+                },
+                "memories": {
+                    "description": "number of the imported memories",
+                    "type": "integer"
+                }
+            }
+        },
+        "memo.Job": {
+            "type": "object",
+            "properties": {
+                "created_at": {
+                    "type": "string"
+                },
+                "error": {
+                    "description": "why the job failed",
+                    "type": "string"
+                },
+                "failed": {
+                    "type": "integer"
+                },
+                "id": {
+                    "type": "string"
+                },
+                "ids": {
+                    "description": "ids of the memories in the same order, assigned when the job is enqueued",
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "owner": {
+                    "description": "tenant which owns the session",
+                    "type": "string"
+                },
+                "processed": {
+                    "description": "number of the memories which are added or failed",
+                    "type": "integer"
+                },
+                "results": {
+                    "description": "one for each memory in the same order, filled as they are processed",
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/memo.AddMemoryResult"
+                    }
+                },
+                "session": {
+                    "type": "string"
+                },
+                "state": {
+                    "enum": [
+                        "queued",
+                        "running",
+                        "completed",
+                        "failed"
+                    ],
+                    "allOf": [
+                        {
+                            "$ref": "#/definitions/memo.JobState"
+                        }
+                    ]
+                },
+                "total": {
+                    "description": "number of the memories",
+                    "type": "integer"
+                },
+                "updated_at": {
+                    "type": "string"
+                }
+            }
+        },
+        "memo.JobState": {
+            "type": "string",
+            "enum": [
+                "queued",
+                "running",
+                "completed",
+                "failed"
+            ],
+            "x-enum-comments": {
+                "JobCompleted": "some of the memories may fail, see the results",
+                "JobFailed": "none of the memories is added"
+            },
+            "x-enum-varnames": [
+                "JobQueued",
+                "JobRunning",
+                "JobCompleted",
+                "JobFailed"
+            ]
+        },
+        "memo.Memory": {
+            "type": "object",
+            "properties": {
+                "embedding": {
+                    "type": "array",
+                    "items": {
+                        "type": "number"
+                    }
+                },
+                "id": {
+                    "type": "string"
+                },
+                "metadata": {
+                    "$ref": "#/definitions/memo.MemoryMetadata"
+                },
+                "score": {
+                    "description": "similarity or retrieval score, only set by search",
+                    "type": "number"
+                },
+                "scores": {
+                    "description": "component scores, only set by weighted search",
+                    "allOf": [
+                        {
+                            "$ref": "#/definitions/memo.RetrievalScores"
+                        }
+                    ]
+                }
+            }
+        },
+        "memo.MemoryFilter": {
+            "type": "object",
+            "properties": {
+                "active_at": {
+                    "description": "plans which start at or before, and end after",
+                    "type": "string"
+                },
+                "created_after": {
+                    "description": "created at or after",
+                    "type": "string"
+                },
+                "created_before": {
+                    "description": "created before",
+                    "type": "string"
+                },
+                "max_importance": {
+                    "description": "importance less than or equal to",
+                    "type": "integer"
+                },
+                "min_importance": {
+                    "description": "importance greater than or equal to",
+                    "type": "integer"
+                },
+                "types": {
+                    "description": "any of the types",
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/memo.MemoryType"
+                    }
+                }
             }
         },
         "memo.MemoryMetadata": {
             "type": "object",
             "properties": {
+                "accessed_at": {
+                    "description": "last retrieved time",
+                    "type": "string"
+                },
                 "content": {
                     "type": "string"
+                },
+                "created_at": {
+                    "type": "string"
+                },
+                "end_at": {
+                    "description": "when a plan ends",
+                    "type": "string"
+                },
+                "evidence": {
+                    "description": "ids of the memories which a reflection is based on",
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "importance": {
+                    "description": "importance score, from 1 to 10",
+                    "type": "integer"
+                },
+                "start_at": {
+                    "description": "when a plan starts",
+                    "type": "string"
+                },
+                "type": {
+                    "$ref": "#/definitions/memo.MemoryType"
+                }
+            }
+        },
+        "memo.MemoryStats": {
+            "type": "object",
+            "properties": {
+                "collection": {
+                    "$ref": "#/definitions/memo.CollectionInfo"
+                },
+                "count": {
+                    "type": "integer"
+                },
+                "importance": {
+                    "description": "count by importance score",
+                    "type": "object",
+                    "additionalProperties": {
+                        "type": "integer"
+                    }
+                },
+                "newest_at": {
+                    "description": "created time of the newest memory",
+                    "type": "string"
+                },
+                "oldest_at": {
+                    "description": "created time of the oldest memory",
+                    "type": "string"
+                },
+                "types": {
+                    "description": "count by memory type",
+                    "type": "object",
+                    "additionalProperties": {
+                        "type": "integer"
+                    }
+                }
+            }
+        },
+        "memo.MemoryType": {
+            "type": "integer",
+            "enum": [
+                0,
+                1,
+                2,
+                3,
+                4
+            ],
+            "x-enum-comments": {
+                "BasicMemory": "default type of memory",
+                "InteractMemory": "agent interacted with someone or something",
+                "PlanMemory": "the plan which agent is going to follow",
+                "ReflectionMemory": "the insight which agent reflected from other memories"
+            },
+            "x-enum-varnames": [
+                "UndefinedMemory",
+                "BasicMemory",
+                "InteractMemory",
+                "PlanMemory",
+                "ReflectionMemory"
+            ]
+        },
+        "memo.MetricsResponse": {
+            "type": "object",
+            "properties": {
+                "embedding_cache": {
+                    "description": "missing if the cache is disabled",
+                    "allOf": [
+                        {
+                            "$ref": "#/definitions/memo.CacheStats"
+                        }
+                    ]
                 }
             }
         },
@@ -400,6 +1668,116 @@ const docTemplate = `{
                 }
             }
         },
+        "memo.PlanRequest": {
+            "type": "object",
+            "properties": {
+                "date": {
+                    "description": "any time of the day to plan, default is today",
+                    "type": "string"
+                },
+                "depth": {
+                    "description": "1: day, 2: hours, 3: 5-15 minutes",
+                    "type": "integer",
+                    "default": 3,
+                    "example": 3
+                }
+            }
+        },
+        "memo.PlanResponse": {
+            "type": "object",
+            "properties": {
+                "plans": {
+                    "description": "plans of all levels, ordered by start time",
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/memo.Memory"
+                    }
+                }
+            }
+        },
+        "memo.ReadyResponse": {
+            "type": "object",
+            "properties": {
+                "checks": {
+                    "description": "\"ok\" or \"down\" of each dependency, the errors are only logged",
+                    "type": "object",
+                    "additionalProperties": {
+                        "type": "string"
+                    },
+                    "example": {
+                        "llm": "ok",
+                        "mongodb": "ok",
+                        "qdrant": "ok"
+                    }
+                },
+                "ready": {
+                    "type": "boolean"
+                }
+            }
+        },
+        "memo.ReflectResponse": {
+            "type": "object",
+            "properties": {
+                "memories": {
+                    "description": "the reflected insights",
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/memo.Memory"
+                    }
+                },
+                "questions": {
+                    "description": "the salient questions about recent memories",
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                }
+            }
+        },
+        "memo.RetrievalScores": {
+            "type": "object",
+            "properties": {
+                "importance": {
+                    "type": "number"
+                },
+                "recency": {
+                    "type": "number"
+                },
+                "relevance": {
+                    "type": "number"
+                }
+            }
+        },
+        "memo.RetrievalWeights": {
+            "type": "object",
+            "properties": {
+                "candidates": {
+                    "description": "how many similar memories to rank, at most 1000",
+                    "type": "integer",
+                    "example": 100
+                },
+                "decay": {
+                    "description": "recency decay factor per hour since last accessed",
+                    "type": "number",
+                    "example": 0.995
+                },
+                "importance": {
+                    "description": "weight of the importance score",
+                    "type": "number",
+                    "example": 1
+                },
+                "recency": {
+                    "description": "weight of the recency score",
+                    "type": "number",
+                    "example": 1
+                },
+                "relevance": {
+                    "description": "weight of the relevance score",
+                    "type": "number",
+                    "example": 1
+                }
+            }
+        },
         "memo.RetrieveMemoriesResponse": {
             "type": "object",
             "properties": {
@@ -418,12 +1796,28 @@ const docTemplate = `{
         "memo.SearchMemoryRequest": {
             "type": "object",
             "properties": {
+                "filter": {
+                    "description": "only search the memories which match the filter",
+                    "allOf": [
+                        {
+                            "$ref": "#/definitions/memo.MemoryFilter"
+                        }
+                    ]
+                },
                 "limit": {
                     "type": "integer",
                     "default": 5
                 },
                 "query": {
                     "type": "string"
+                },
+                "weights": {
+                    "description": "rank by recency, importance and relevance if set",
+                    "allOf": [
+                        {
+                            "$ref": "#/definitions/memo.RetrievalWeights"
+                        }
+                    ]
                 }
             }
         },
@@ -431,35 +1825,158 @@ const docTemplate = `{
             "type": "object",
             "properties": {
                 "_id": {
+                    "description": "auto-generated id",
                     "type": "string"
                 },
+                "acc_importance": {
+                    "description": "accumulated importance since last reflection",
+                    "type": "integer"
+                },
+                "attributes": {
+                    "description": "free-form traits, e.g. persona, location or current status",
+                    "type": "object",
+                    "additionalProperties": {
+                        "type": "string"
+                    }
+                },
                 "created_at": {
                     "description": "auto-generated created time",
                     "type": "integer"
                 },
+                "desc": {
+                    "description": "agent's description",
+                    "type": "string"
+                },
                 "name": {
+                    "description": "agent's name",
                     "type": "string"
                 },
+                "owner": {
+                    "description": "tenant which owns the session, set by the api key",
+                    "type": "string"
+                },
+                "reflected_at": {
+                    "description": "last reflected time",
+                    "type": "integer"
+                },
+                "state": {
+                    "description": "lifecycle state, set by the server",
+                    "allOf": [
+                        {
+                            "$ref": "#/definitions/memo.SessionState"
+                        }
+                    ]
+                },
                 "tags": {
+                    "description": "for filtering the sessions",
                     "type": "array",
                     "items": {
                         "type": "string"
                     }
+                },
+                "updated_at": {
+                    "description": "last updated time",
+                    "type": "integer"
+                }
+            }
+        },
+        "memo.SessionAddResponse": {
+            "type": "object",
+            "properties": {
+                "_id": {
+                    "type": "string"
+                }
+            }
+        },
+        "memo.SessionState": {
+            "type": "string",
+            "enum": [
+                "creating",
+                "active",
+                "deleting"
+            ],
+            "x-enum-comments": {
+                "SessionActive": "both the document and the collection exist",
+                "SessionCreating": "the document is inserted, the collection may not be created",
+                "SessionDeleting": "the collection may be deleted, the document is going to be deleted"
+            },
+            "x-enum-varnames": [
+                "SessionCreating",
+                "SessionActive",
+                "SessionDeleting"
+            ]
+        },
+        "memo.SessionStats": {
+            "type": "object",
+            "properties": {
+                "_id": {
+                    "type": "string"
+                },
+                "acc_importance": {
+                    "description": "accumulated importance since last reflection",
+                    "type": "integer"
+                },
+                "memories": {
+                    "$ref": "#/definitions/memo.MemoryStats"
+                },
+                "reflected_at": {
+                    "description": "last reflected time",
+                    "type": "integer"
+                }
+            }
+        },
+        "memo.UpdateMemoryRequest": {
+            "type": "object",
+            "properties": {
+                "content": {
+                    "description": "the memory is re-embedded if its content changes",
+                    "type": "string"
+                },
+                "end_at": {
+                    "type": "string"
+                },
+                "importance": {
+                    "type": "integer"
+                },
+                "start_at": {
+                    "type": "string"
+                },
+                "type": {
+                    "$ref": "#/definitions/memo.MemoryType"
                 }
             }
         },
-        "mongo.InsertOneResult": {
+        "memo.UpdateSessionRequest": {
             "type": "object",
             "properties": {
-                "insertedID": {
-                    "description": "The _id of the inserted document. A value generated by the driver will be of type primitive.ObjectID."
+                "attributes": {
+                    "type": "object",
+                    "additionalProperties": {
+                        "type": "string"
+                    }
+                },
+                "desc": {
+                    "type": "string"
+                },
+                "name": {
+                    "type": "string"
+                },
+                "tags": {
+                    "description": "replaces all the tags",
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
                 }
             }
         }
     },
     "securityDefinitions": {
-        "BasicAuth": {
-            "type": "basic"
+        "ApiKeyAuth": {
+            "description": "api key of the tenant, \"Authorization: Bearer \u003ckey\u003e\" is accepted too",
+            "type": "apiKey",
+            "name": "X-API-Key",
+            "in": "header"
         }
     },
     "externalDocs": {
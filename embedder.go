@@ -0,0 +1,217 @@
+package memo
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	openai "github.com/sashabaranov/go-openai"
+)
+
+const (
+	defaultEmbeddingDimension = 1536 // dimension of openai's text-embedding-ada-002
+	openaiBaseURL             = "https://api.openai.com/v1"
+)
+
+// Embedder converts texts into vectors, the collections are created with its dimension
+type Embedder interface {
+	// Embed returns one vector for each input, in the same order
+	Embed(ctx context.Context, inputs []string) ([][]float32, error)
+	// Dimension of the vectors
+	Dimension() int
+	// Name of the model, the vectors of the same name and dimension are interchangeable
+	Name() string
+}
+
+// OpenAIEmbedder embeds with the models known by the openai client, text-embedding-ada-002 by default
+type OpenAIEmbedder struct {
+	client    OpenAIClient
+	model     openai.EmbeddingModel
+	dimension int
+}
+
+func NewOpenAIEmbedder(client OpenAIClient, model openai.EmbeddingModel, dimension int) *OpenAIEmbedder {
+	if model == openai.Unknown {
+		model = openai.AdaEmbeddingV2
+	}
+	if dimension <= 0 {
+		dimension = defaultEmbeddingDimension
+	}
+	return &OpenAIEmbedder{client: client, model: model, dimension: dimension}
+}
+
+func (e *OpenAIEmbedder) Embed(ctx context.Context, inputs []string) ([][]float32, error) {
+	resp, err := e.client.CreateEmbeddings(ctx, openai.EmbeddingRequest{
+		Input: inputs,
+		Model: e.model,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return sortEmbeddings(resp.Data, len(inputs), e.dimension)
+}
+
+func (e *OpenAIEmbedder) Dimension() int {
+	return e.dimension
+}
+
+func (e *OpenAIEmbedder) Name() string {
+	return e.model.String()
+}
+
+// CompatibleEmbedder embeds with any openai compatible endpoint, e.g. a local ollama or llama.cpp server,
+// the model is given by name, which can't be done with the openai client
+type CompatibleEmbedder struct {
+	BaseURL string // e.g. http://localhost:11434/v1
+	Model   string
+	APIKey  string // optional
+
+	dimension int
+	client    *http.Client
+}
+
+func NewCompatibleEmbedder(baseURL, model, key string, dimension int) *CompatibleEmbedder {
+	return &CompatibleEmbedder{
+		BaseURL:   strings.TrimRight(baseURL, "/"),
+		Model:     model,
+		APIKey:    key,
+		dimension: dimension,
+		client:    http.DefaultClient,
+	}
+}
+
+type compatibleEmbeddingRequest struct {
+	Input []string `json:"input"`
+	Model string   `json:"model"`
+}
+
+type compatibleEmbeddingResponse struct {
+	Data  []openai.Embedding `json:"data"`
+	Error *struct {
+		Message string `json:"message"`
+	} `json:"error,omitempty"`
+}
+
+func (e *CompatibleEmbedder) Embed(ctx context.Context, inputs []string) ([][]float32, error) {
+	body, err := json.Marshal(compatibleEmbeddingRequest{Input: inputs, Model: e.Model})
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.BaseURL+"/embeddings", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if e.APIKey != "" {
+		req.Header.Set("Authorization", "Bearer "+e.APIKey)
+	}
+
+	res, err := e.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode == http.StatusTooManyRequests {
+		return nil, fmt.Errorf("%w: status %d", ErrRateLimited, res.StatusCode)
+	}
+
+	var resp compatibleEmbeddingResponse
+	if err := json.NewDecoder(res.Body).Decode(&resp); err != nil {
+		return nil, fmt.Errorf("can't decode embeddings (status %d): %w", res.StatusCode, err)
+	}
+	if resp.Error != nil {
+		return nil, fmt.Errorf("embedding error (status %d): %s", res.StatusCode, resp.Error.Message)
+	}
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("embedding error: status %d", res.StatusCode)
+	}
+
+	return sortEmbeddings(resp.Data, len(inputs), e.dimension)
+}
+
+func (e *CompatibleEmbedder) Dimension() int {
+	return e.dimension
+}
+
+func (e *CompatibleEmbedder) Name() string {
+	return e.Model
+}
+
+// HashEmbedder is the lexical embedder for testing, it's not a model: the words and their character n-grams
+// are hashed into the vector, so the texts sharing words are similar, but the synonyms and paraphrases are not,
+// so it can't be configured, use the compatible provider with a local server, e.g. ollama, to embed locally
+type HashEmbedder struct {
+	dimension int
+}
+
+func NewHashEmbedder(dimension int) *HashEmbedder {
+	if dimension <= 0 {
+		dimension = defaultEmbeddingDimension
+	}
+	return &HashEmbedder{dimension: dimension}
+}
+
+func (e *HashEmbedder) Embed(ctx context.Context, inputs []string) ([][]float32, error) {
+	vectors := make([][]float32, 0, len(inputs))
+	for _, input := range inputs {
+		vectors = append(vectors, hashEmbedding(input, e.dimension))
+	}
+	return vectors, nil
+}
+
+func (e *HashEmbedder) Dimension() int {
+	return e.dimension
+}
+
+func (e *HashEmbedder) Name() string {
+	return "hash"
+}
+
+// NewEmbedder creates the embedder of the configured provider
+func NewEmbedder(cfg EmbeddingConfig, client OpenAIClient) (Embedder, error) {
+	switch cfg.Provider {
+	case "", "openai":
+		if cfg.APIKey != "" {
+			client = openai.NewClient(cfg.APIKey)
+		}
+		if cfg.Model == "" {
+			return NewOpenAIEmbedder(client, openai.AdaEmbeddingV2, cfg.Dimension), nil
+		}
+
+		var model openai.EmbeddingModel
+		model.UnmarshalText([]byte(cfg.Model))
+		if model == openai.Unknown {
+			// the client only knows the legacy models, the newer ones are requested by name, e.g. text-embedding-3-small
+			return NewCompatibleEmbedder(openaiBaseURL, cfg.Model, cfg.APIKey, cfg.Dimension), nil
+		}
+		return NewOpenAIEmbedder(client, model, cfg.Dimension), nil
+	case "compatible":
+		return NewCompatibleEmbedder(cfg.BaseURL, cfg.Model, cfg.APIKey, cfg.Dimension), nil
+	default:
+		return nil, fmt.Errorf("unknown embedding provider: %q", cfg.Provider)
+	}
+}
+
+// sortEmbeddings orders the embeddings by their indices, and checks the count and dimension
+func sortEmbeddings(data []openai.Embedding, count int, dimension int) ([][]float32, error) {
+	if len(data) != count {
+		return nil, fmt.Errorf("%w: got %d embeddings for %d inputs", ErrEmbeddingMismatch, len(data), count)
+	}
+
+	sort.SliceStable(data, func(i, j int) bool { return data[i].Index < data[j].Index })
+
+	vectors := make([][]float32, 0, count)
+	for _, d := range data {
+		if len(d.Embedding) != dimension {
+			return nil, fmt.Errorf("%w: got dimension %d, expected %d", ErrEmbeddingMismatch, len(d.Embedding), dimension)
+		}
+		vectors = append(vectors, d.Embedding)
+	}
+	return vectors, nil
+}
@@ -0,0 +1,115 @@
+package memo
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	openai "github.com/sashabaranov/go-openai"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCompatibleEmbedder(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/v1/embeddings", r.URL.Path)
+		assert.Equal(t, "Bearer key", r.Header.Get("Authorization"))
+
+		var req compatibleEmbeddingRequest
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+		assert.Equal(t, "nomic-embed-text", req.Model)
+
+		if req.Input[0] == "error" {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"error":{"message":"bad input"}}`))
+			return
+		}
+
+		// reversed order
+		var resp compatibleEmbeddingResponse
+		for i := len(req.Input) - 1; i >= 0; i-- {
+			resp.Data = append(resp.Data, openai.Embedding{Index: i, Embedding: []float32{float32(i), 1}})
+		}
+		json.NewEncoder(w).Encode(resp)
+	}))
+	defer server.Close()
+
+	e := NewCompatibleEmbedder(server.URL+"/v1/", "nomic-embed-text", "key", 2)
+	assert.Equal(t, 2, e.Dimension())
+
+	vectors, err := e.Embed(context.TODO(), []string{"a", "b", "c"})
+	assert.NoError(t, err)
+	assert.Equal(t, [][]float32{{0, 1}, {1, 1}, {2, 1}}, vectors)
+
+	_, err = e.Embed(context.TODO(), []string{"error"})
+	assert.ErrorContains(t, err, "bad input")
+
+	// wrong dimension
+	e = NewCompatibleEmbedder(server.URL+"/v1", "nomic-embed-text", "key", 3)
+	_, err = e.Embed(context.TODO(), []string{"a"})
+	assert.ErrorIs(t, err, ErrEmbeddingMismatch)
+}
+
+func TestOpenAIEmbedder(t *testing.T) {
+	client := NewStubClient()
+	client.Dimension = 256
+
+	e := NewOpenAIEmbedder(client, openai.AdaSimilarity, 256)
+	vectors, err := e.Embed(context.TODO(), []string{"hello"})
+	assert.NoError(t, err)
+	assert.Equal(t, hashEmbedding("hello", 256), vectors[0])
+
+	// wrong dimension
+	e = NewOpenAIEmbedder(client, openai.AdaSimilarity, 0)
+	assert.Equal(t, defaultEmbeddingDimension, e.Dimension())
+	_, err = e.Embed(context.TODO(), []string{"hello"})
+	assert.ErrorIs(t, err, ErrEmbeddingMismatch)
+}
+
+func TestHashEmbedder(t *testing.T) {
+	e := NewHashEmbedder(64)
+	assert.Equal(t, 64, e.Dimension())
+
+	vectors, err := e.Embed(context.TODO(), []string{"hello", "world"})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(vectors))
+	assert.Equal(t, 64, len(vectors[0]))
+	assert.Equal(t, hashEmbedding("hello", 64), vectors[0])
+
+	assert.Equal(t, defaultEmbeddingDimension, NewHashEmbedder(0).Dimension())
+}
+
+func TestNewEmbedder(t *testing.T) {
+	cfg := DefaultConfig().Embedding
+	e, err := NewEmbedder(cfg, NewStubClient())
+	assert.NoError(t, err)
+	assert.IsType(t, &OpenAIEmbedder{}, e)
+	assert.Equal(t, defaultEmbeddingDimension, e.Dimension())
+	assert.Equal(t, "text-embedding-ada-002", e.Name())
+
+	e, err = NewEmbedder(EmbeddingConfig{Provider: "openai", Model: "text-search-ada-doc-001", Dimension: 1024}, NewStubClient())
+	assert.NoError(t, err)
+	assert.IsType(t, &OpenAIEmbedder{}, e)
+	assert.Equal(t, "text-search-ada-doc-001", e.Name())
+	assert.Equal(t, 1024, e.Dimension())
+
+	// the models unknown to the client are requested by name
+	e, err = NewEmbedder(EmbeddingConfig{Provider: "openai", Model: "text-embedding-3-large", APIKey: "key", Dimension: 3072}, nil)
+	assert.NoError(t, err)
+	assert.IsType(t, &CompatibleEmbedder{}, e)
+	assert.Equal(t, "text-embedding-3-large", e.Name())
+	assert.Equal(t, 3072, e.Dimension())
+
+	// the hash embedder is for testing only
+	_, err = NewEmbedder(EmbeddingConfig{Provider: "hash", Dimension: 256}, nil)
+	assert.Error(t, err)
+
+	e, err = NewEmbedder(EmbeddingConfig{Provider: "compatible", BaseURL: "http://localhost:11434/v1", Model: "nomic-embed-text", Dimension: 768}, nil)
+	assert.NoError(t, err)
+	assert.IsType(t, &CompatibleEmbedder{}, e)
+	assert.Equal(t, 768, e.Dimension())
+
+	_, err = NewEmbedder(EmbeddingConfig{Provider: "onnx"}, nil)
+	assert.Error(t, err)
+}
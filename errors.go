@@ -7,14 +7,34 @@ import (
 )
 
 var (
-	ErrSessionNotFound = errors.New("session not found")
-  ErrInvalidID = errors.New("invalid id format")
-  ErrJSONDecode = errors.New("can't decode json body")
-  ErrOpenAIEmbedding = errors.New("can't create embedding from openai")
-  ErrInvalidOpenAPIKey = errors.New("invalid or empty openai api key")
-  ErrQdrantUpsert = errors.New("can't upsert points with qdrant")
-  ErrQdrantSearch = errors.New("can't search with qdrant")
-  ErrQdrantScroll = errors.New("can't scroll points with qdrant")
+	ErrSessionNotFound    = errors.New("session not found")
+	ErrSessionCreating    = errors.New("session is being created")
+	ErrInvalidID          = errors.New("invalid id format")
+	ErrJSONDecode         = errors.New("can't decode json body")
+	ErrOpenAIEmbedding    = errors.New("can't create embedding")
+	ErrInvalidOpenAPIKey  = errors.New("invalid or empty openai api key")
+	ErrQdrantUpsert       = errors.New("can't upsert points with qdrant")
+	ErrQdrantSearch       = errors.New("can't search with qdrant")
+	ErrQdrantScroll       = errors.New("can't scroll points with qdrant")
+	ErrScoreMismatch      = errors.New("the number of scores doesn't match the memories")
+	ErrScoreImportance    = errors.New("can't score the importance")
+	ErrEmptyCompletion    = errors.New("empty completion from openai")
+	ErrPlanNotFound       = errors.New("no plan at the given time")
+	ErrCollectionNotFound = errors.New("collection not found")
+	ErrEmbeddingMismatch  = errors.New("embeddings don't match the inputs")
+	ErrMemoryNotFound     = errors.New("memory not found")
+	ErrEmptyFilter        = errors.New("filter is empty")
+	ErrUnauthorized       = errors.New("invalid or missing api key")
+	ErrEmptySessionName   = errors.New("session name can't be empty")
+	ErrInvalidTag         = errors.New("tags can't be empty")
+	ErrInvalidAttribute   = errors.New("attribute keys can't be empty, contain dots or start with $")
+	ErrInvalidExport      = errors.New("invalid export")
+	ErrImportTooLarge     = errors.New("the import is too large")
+	ErrRateLimited        = errors.New("rate limited by the provider")
+	ErrJobNotFound        = errors.New("job not found")
+	ErrJobsDisabled       = errors.New("the job queue is disabled")
+	ErrNegativeLimit      = errors.New("limit and candidates can't be negative")
+	ErrInvalidTimeRange   = errors.New("start_at and end_at must be set together, and start_at must be before end_at")
 )
 
 // NewError create a APIError and send it to client
@@ -0,0 +1,473 @@
+package memo
+
+import (
+	"archive/tar"
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	exportVersion  = 1   // version of the export format
+	exportPageSize = 100 // number of memories read or written at once
+
+	exportSessionFile = "session.json" // the header in tar.gz
+	exportMemoryDir   = "memories/"    // one file for each memory in tar.gz
+
+	defaultImportLimit = 1 << 30 // max bytes of an import, both compressed and decompressed
+	exportEntryLimit   = 1 << 20 // max bytes of a file in tar.gz, a memory with 3072 dimensions is about 70KB
+)
+
+// ExportHeader describes the exported session, it's the first line of jsonl or session.json of tar.gz,
+// followed by the memories, one line or one file for each
+type ExportHeader struct {
+	Version    int       `json:"version"`
+	Session    Session   `json:"session"`
+	Embedder   string    `json:"embedder"` // name of the embedding model
+	Dimension  int       `json:"dimension"`
+	Vectors    bool      `json:"vectors"` // whether the memories have vectors
+	ExportedAt time.Time `json:"exported_at"`
+}
+
+type ImportSessionResponse struct {
+	ID       primitive.ObjectID `json:"_id"`
+	Memories int                `json:"memories"` // number of the imported memories
+	Embedded int                `json:"embedded"` // number of the memories which are re-embedded
+}
+
+// @Summary		export one session
+// @Description	stream the session and all of its memories as jsonl, or as a tar.gz archive of session.json and memories/{id}.json,
+// @Description	the stream is truncated if it fails in the middle
+// @Tags			sessions
+// @Produce		application/x-ndjson,application/gzip
+// @Param			id		path		string	true	"the session to export"
+// @Param			format	query		string	false	"jsonl or tar.gz"	Enums(jsonl, tar.gz)	default(jsonl)
+// @Param			vectors	query		bool	false	"include the vectors"
+// @Success		200		{file}		file
+// @Failure		default	{object}	APIError
+// @Security		ApiKeyAuth
+// @Router			/s/{id}/export [get]
+func (h *Handlers) ExportSession(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	sess, err := h.findSession(ctx, tenant(c), c.Param("id"))
+	if err != nil {
+		sessionError(c, err)
+		return
+	}
+
+	withVectors, err := strconv.ParseBool(c.DefaultQuery("vectors", "false"))
+	if err != nil {
+		NewError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	format := c.DefaultQuery("format", "jsonl")
+	var contentType string
+	switch format {
+	case "jsonl":
+		contentType = "application/x-ndjson"
+	case "tar.gz":
+		contentType = "application/gzip"
+	default:
+		NewError(c, http.StatusBadRequest, fmt.Errorf("%w: unknown format %q", ErrInvalidExport, format))
+		return
+	}
+
+	// the errors can't be sent after streaming, so check the collection first
+	if _, err := h.vectors.Count(ctx, sess.ID.Hex(), nil); err != nil {
+		memoryError(c, err)
+		return
+	}
+
+	c.Header("Content-Type", contentType)
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.%s"`, sess.ID.Hex(), format))
+	c.Status(http.StatusOK)
+
+	var w exportWriter = newJSONLWriter(c.Writer)
+	if format == "tar.gz" {
+		w = newTarWriter(c.Writer)
+	}
+	if err := h.exportSession(ctx, sess, w, withVectors); err != nil {
+		log.Printf("can't export session %s: %v", sess.ID.Hex(), err)
+	}
+}
+
+// exportSession writes the header, then the memories page by page
+func (h *Handlers) exportSession(ctx context.Context, sess *Session, w exportWriter, withVectors bool) error {
+	sid := sess.ID.Hex()
+	err := w.WriteHeader(ExportHeader{
+		Version:    exportVersion,
+		Session:    *sess,
+		Embedder:   h.embedder.Name(),
+		Dimension:  h.embedder.Dimension(),
+		Vectors:    withVectors,
+		ExportedAt: time.Now(),
+	})
+	if err != nil {
+		return err
+	}
+
+	err = h.scrollPages(ctx, sid, nil, withVectors, func(memories []Memory) error {
+		for _, m := range memories {
+			if err := w.WriteMemory(m); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return w.Close()
+}
+
+// @Summary		import a session
+// @Description	create a session from an export in jsonl or tar.gz, the memories are re-embedded
+// @Description	if they have no vectors or they were embedded by another model
+// @Tags			sessions
+// @Accept			application/x-ndjson,application/gzip
+// @Produce		json
+// @Param			archive	body		string	true	"the exported session"
+// @Success		200		{object}	ImportSessionResponse
+// @Failure		default	{object}	APIError
+// @Security		ApiKeyAuth
+// @Router			/s/import [post]
+func (h *Handlers) ImportSession(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	// the body and the decompressed archive are limited, so a small gzip bomb can't exhaust the server
+	r, err := newExportReader(http.MaxBytesReader(c.Writer, c.Request.Body, h.ImportLimit), h.ImportLimit)
+	if err != nil {
+		importError(c, err)
+		return
+	}
+
+	header, err := r.ReadHeader()
+	if err != nil {
+		importError(c, err)
+		return
+	}
+	if header.Version != exportVersion {
+		NewError(c, http.StatusBadRequest, fmt.Errorf("%w: unsupported version %d", ErrInvalidExport, header.Version))
+		return
+	}
+
+	// a new session of the tenant, with the state set by prepareSession
+	sess := header.Session
+	sess.ID = primitive.NilObjectID
+	sess.Owner = tenant(c)
+	if err := sess.validate(); err != nil {
+		NewError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	// the session is activated after all the memories are imported
+	sid, err := h.prepareSession(ctx, &sess)
+	if err != nil {
+		NewError(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	resp, err := h.importMemories(ctx, sid, header, r)
+	if err != nil {
+		// don't leave a partial session
+		h.rollback(sid, true)
+		importError(c, err)
+		return
+	}
+
+	if err := h.activateSession(ctx, sid, &sess); err != nil {
+		NewError(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	resp.ID = sid
+	c.JSON(http.StatusOK, resp)
+}
+
+// importError sends the error of reading or importing the export with proper status code
+func importError(c *gin.Context, err error) {
+	var tooLarge *http.MaxBytesError
+	switch {
+	case errors.As(err, &tooLarge) || errors.Is(err, ErrImportTooLarge):
+		NewError(c, http.StatusRequestEntityTooLarge, ErrImportTooLarge)
+	case errors.Is(err, ErrInvalidExport):
+		NewError(c, http.StatusBadRequest, err)
+	default:
+		NewError(c, http.StatusInternalServerError, err)
+	}
+}
+
+// importMemories upserts the memories in batches, the vectors are reused if they are compatible with the embedder,
+// the session is renewed after each batch, since a large import may take longer than the grace period of the reconciler
+func (h *Handlers) importMemories(ctx context.Context, sid primitive.ObjectID, header *ExportHeader, r exportReader) (*ImportSessionResponse, error) {
+	resp := &ImportSessionResponse{}
+	dimension := h.embedder.Dimension()
+	reuse := header.Vectors && header.Embedder == h.embedder.Name() && header.Dimension == dimension
+
+	batch := make([]Memory, 0, exportPageSize)
+	flush := func() error {
+		var inputs []string
+		var missing []int
+		for i, m := range batch {
+			if !reuse || len(m.Embedding) != dimension {
+				inputs = append(inputs, m.Metadata.Content)
+				missing = append(missing, i)
+			}
+		}
+
+		if len(inputs) > 0 {
+			vectors, errs := h.embedBatches(ctx, inputs)
+			for _, err := range errs {
+				if err != nil {
+					return err
+				}
+			}
+			for j, i := range missing {
+				batch[i].Embedding = vectors[j]
+			}
+			resp.Embedded += len(inputs)
+		}
+
+		if err := h.vectors.Upsert(ctx, sid.Hex(), batch); err != nil {
+			return err
+		}
+		resp.Memories += len(batch)
+		batch = batch[:0]
+		return h.renewSession(ctx, sid)
+	}
+
+	for {
+		m, err := r.ReadMemory()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		// keep the ids, so the evidence of reflections still works
+		if m.ID == "" {
+			m.ID = newMemoryID()
+		} else if _, err := uuid.Parse(m.ID); err != nil {
+			return nil, fmt.Errorf("%w: invalid memory id %q", ErrInvalidExport, m.ID)
+		}
+		m.Score, m.Scores = 0, nil
+
+		batch = append(batch, *m)
+		if len(batch) == exportPageSize {
+			if err := flush(); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if len(batch) > 0 {
+		if err := flush(); err != nil {
+			return nil, err
+		}
+	}
+	return resp, nil
+}
+
+// exportWriter writes the header, then the memories
+type exportWriter interface {
+	WriteHeader(h ExportHeader) error
+	WriteMemory(m Memory) error
+	// Close finishes the export, it doesn't close the underlying writer
+	Close() error
+}
+
+// jsonlWriter writes one json object for each line
+type jsonlWriter struct {
+	enc *json.Encoder
+}
+
+func newJSONLWriter(w io.Writer) *jsonlWriter {
+	return &jsonlWriter{enc: json.NewEncoder(w)}
+}
+
+func (w *jsonlWriter) WriteHeader(h ExportHeader) error { return w.enc.Encode(h) }
+func (w *jsonlWriter) WriteMemory(m Memory) error       { return w.enc.Encode(m) }
+func (w *jsonlWriter) Close() error                     { return nil }
+
+// tarWriter writes the header into session.json, and each memory into its own file,
+// since the size of a tar entry must be known before writing
+type tarWriter struct {
+	gz *gzip.Writer
+	tw *tar.Writer
+}
+
+func newTarWriter(w io.Writer) *tarWriter {
+	gz := gzip.NewWriter(w)
+	return &tarWriter{gz: gz, tw: tar.NewWriter(gz)}
+}
+
+func (w *tarWriter) WriteHeader(h ExportHeader) error {
+	return w.writeFile(exportSessionFile, h)
+}
+
+func (w *tarWriter) WriteMemory(m Memory) error {
+	return w.writeFile(exportMemoryDir+m.ID+".json", m)
+}
+
+func (w *tarWriter) writeFile(name string, v any) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	err = w.tw.WriteHeader(&tar.Header{
+		Name:    name,
+		Mode:    0o644,
+		Size:    int64(len(b)),
+		ModTime: time.Now(),
+	})
+	if err != nil {
+		return err
+	}
+	_, err = w.tw.Write(b)
+	return err
+}
+
+func (w *tarWriter) Close() error {
+	if err := w.tw.Close(); err != nil {
+		return err
+	}
+	return w.gz.Close()
+}
+
+// exportReader reads the header, then the memories until io.EOF
+type exportReader interface {
+	ReadHeader() (*ExportHeader, error)
+	ReadMemory() (*Memory, error)
+}
+
+// newExportReader detects the format by the gzip magic number, the decompressed archive is limited to limit bytes
+func newExportReader(r io.Reader, limit int64) (exportReader, error) {
+	br := bufio.NewReader(r)
+	if magic, err := br.Peek(2); err == nil && magic[0] == 0x1f && magic[1] == 0x8b {
+		gz, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrInvalidExport, err)
+		}
+		return &tarReader{tr: tar.NewReader(&limitedReader{r: gz, n: limit})}, nil
+	}
+	return &jsonlReader{dec: json.NewDecoder(br)}, nil
+}
+
+// limitedReader fails with ErrImportTooLarge after n bytes, unlike io.LimitReader which ends silently
+type limitedReader struct {
+	r io.Reader
+	n int64
+}
+
+func (l *limitedReader) Read(p []byte) (int, error) {
+	if l.n <= 0 {
+		// the reader may end right at the limit
+		var b [1]byte
+		if n, err := l.r.Read(b[:]); n == 0 && err != nil {
+			return 0, err
+		}
+		return 0, ErrImportTooLarge
+	}
+
+	if int64(len(p)) > l.n {
+		p = p[:l.n]
+	}
+	n, err := l.r.Read(p)
+	l.n -= int64(n)
+	return n, err
+}
+
+type jsonlReader struct {
+	dec *json.Decoder
+}
+
+func (r *jsonlReader) ReadHeader() (*ExportHeader, error) {
+	var h ExportHeader
+	if err := r.dec.Decode(&h); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidExport, err)
+	}
+	return &h, nil
+}
+
+func (r *jsonlReader) ReadMemory() (*Memory, error) {
+	var m Memory
+	if err := r.dec.Decode(&m); err != nil {
+		if err == io.EOF {
+			return nil, err
+		}
+		return nil, fmt.Errorf("%w: %w", ErrInvalidExport, err)
+	}
+	return &m, nil
+}
+
+type tarReader struct {
+	tr *tar.Reader
+}
+
+func (r *tarReader) ReadHeader() (*ExportHeader, error) {
+	var h ExportHeader
+	name, err := r.next(&h)
+	if err == nil && name != exportSessionFile {
+		err = fmt.Errorf("%w: expected %s first, got %s", ErrInvalidExport, exportSessionFile, name)
+	}
+	if err == io.EOF {
+		err = fmt.Errorf("%w: empty archive", ErrInvalidExport)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &h, nil
+}
+
+func (r *tarReader) ReadMemory() (*Memory, error) {
+	var m Memory
+	name, err := r.next(&m)
+	if err == nil && !strings.HasPrefix(name, exportMemoryDir) {
+		err = fmt.Errorf("%w: unexpected file %s", ErrInvalidExport, name)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
+
+// next decodes the next regular file, and returns its name
+func (r *tarReader) next(v any) (string, error) {
+	for {
+		h, err := r.tr.Next()
+		if err == io.EOF {
+			return "", err
+		}
+		if err != nil {
+			return "", fmt.Errorf("%w: %w", ErrInvalidExport, err)
+		}
+		if h.Typeflag != tar.TypeReg {
+			continue
+		}
+		if h.Size > exportEntryLimit {
+			return "", fmt.Errorf("%w: %s has %d bytes", ErrImportTooLarge, h.Name, h.Size)
+		}
+
+		if err := json.NewDecoder(r.tr).Decode(v); err != nil {
+			return "", fmt.Errorf("%w: %s: %w", ErrInvalidExport, h.Name, err)
+		}
+		return h.Name, nil
+	}
+}
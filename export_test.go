@@ -0,0 +1,199 @@
+package memo
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"io"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestExportAndImport(t *testing.T) {
+	hs := newTestHandlers(t)
+	router := newTestRouter(hs)
+
+	w := serve(router, "POST", "/s/add", strings.NewReader(`{"name":"aspirin2d", "tags":["hello"], "attributes":{"location":"home"}}`))
+	assert.Equal(t, 200, w.Code)
+	var added SessionAddResponse
+	assert.NoError(t, json.NewDecoder(w.Body).Decode(&added))
+	sid := added.ID.Hex()
+
+	// more than one page of memories
+	var memories []string
+	for i := 0; i < exportPageSize+5; i++ {
+		memories = append(memories, `{"metadata":{"content":"memory `+strings.Repeat("x", i)+`", "importance": 3}}`)
+	}
+	w = serve(router, "POST", "/m/"+sid+"/add", strings.NewReader(`{"memories":[`+strings.Join(memories, ",")+`]}`))
+	assert.Equal(t, 200, w.Code)
+
+	contents := func(sid string) []string {
+		var all []string
+		offset := ""
+		for {
+			page, next, err := hs.vectors.Scroll(context.TODO(), sid, nil, offset, 50)
+			assert.NoError(t, err)
+			for _, m := range page {
+				all = append(all, m.ID+m.Metadata.Content)
+			}
+			if next == "" {
+				break
+			}
+			offset = next
+		}
+		sort.Strings(all)
+		return all
+	}
+
+	importSession := func(body io.Reader) ImportSessionResponse {
+		w := serve(router, "POST", "/s/import", body)
+		assert.Equal(t, 200, w.Code, w.Body.String())
+		var res ImportSessionResponse
+		assert.NoError(t, json.NewDecoder(w.Body).Decode(&res))
+		return res
+	}
+
+	// jsonl with vectors, they are reused
+	w = serve(router, "GET", "/s/"+sid+"/export?vectors=true", nil)
+	assert.Equal(t, 200, w.Code)
+	assert.Equal(t, "application/x-ndjson", w.Header().Get("Content-Type"))
+	assert.Equal(t, exportPageSize+6, strings.Count(w.Body.String(), "\n"))
+
+	res := importSession(w.Body)
+	assert.Equal(t, exportPageSize+5, res.Memories)
+	assert.Zero(t, res.Embedded)
+	assert.NotEqual(t, sid, res.ID.Hex())
+	assert.Equal(t, contents(sid), contents(res.ID.Hex()))
+
+	w = serve(router, "GET", "/s/"+res.ID.Hex(), nil)
+	var sess Session
+	assert.NoError(t, json.NewDecoder(w.Body).Decode(&sess))
+	assert.Equal(t, "aspirin2d", sess.Name)
+	assert.Equal(t, []string{"hello"}, sess.Tags)
+	assert.Equal(t, "home", sess.Attributes["location"])
+	assert.Equal(t, SessionActive, sess.State)
+
+	// tar.gz without vectors, they are re-embedded
+	w = serve(router, "GET", "/s/"+sid+"/export?format=tar.gz", nil)
+	assert.Equal(t, 200, w.Code)
+	assert.Equal(t, "application/gzip", w.Header().Get("Content-Type"))
+	archive := w.Body.Bytes()
+
+	gz, err := gzip.NewReader(bytes.NewReader(archive))
+	assert.NoError(t, err)
+	tr := tar.NewReader(gz)
+	h, err := tr.Next()
+	assert.NoError(t, err)
+	assert.Equal(t, exportSessionFile, h.Name)
+
+	res = importSession(bytes.NewReader(archive))
+	assert.Equal(t, exportPageSize+5, res.Memories)
+	assert.Equal(t, exportPageSize+5, res.Embedded)
+	assert.Equal(t, contents(sid), contents(res.ID.Hex()))
+
+	// the vectors of another model are re-embedded
+	w = serve(router, "GET", "/s/"+sid+"/export?vectors=1", nil)
+	exported := strings.Replace(w.Body.String(), `"embedder":"hash"`, `"embedder":"text-embedding-ada-002"`, 1)
+	res = importSession(strings.NewReader(exported))
+	assert.Equal(t, exportPageSize+5, res.Embedded)
+
+	// invalid exports
+	before, err := hs.sessions.List(context.TODO(), SessionQuery{All: true})
+	assert.NoError(t, err)
+
+	assert.Equal(t, 400, serve(router, "GET", "/s/"+sid+"/export?format=zip", nil).Code)
+	assert.Equal(t, 400, serve(router, "GET", "/s/"+sid+"/export?vectors=maybe", nil).Code)
+	assert.Equal(t, 400, serve(router, "POST", "/s/import", strings.NewReader(`hello`)).Code)
+	assert.Equal(t, 400, serve(router, "POST", "/s/import", strings.NewReader(`{"version":2}`)).Code)
+	assert.Equal(t, 400, serve(router, "POST", "/s/import", strings.NewReader(`{"version":1, "session":{"name":"bob"}}
+{"id":"123", "metadata":{"content":"hello"}}`)).Code)
+	assert.Equal(t, 400, serve(router, "POST", "/s/import", bytes.NewReader(archive[:len(archive)/2])).Code)
+
+	// the partial imports are rolled back
+	after, err := hs.sessions.List(context.TODO(), SessionQuery{All: true})
+	assert.NoError(t, err)
+	assert.Equal(t, len(before), len(after))
+}
+
+// stateVectorStore records the states of the sessions when their memories are upserted
+type stateVectorStore struct {
+	VectorStore
+	sessions SessionStore
+	states   []SessionState
+}
+
+func (s *stateVectorStore) Upsert(ctx context.Context, collection string, memories []Memory) error {
+	id, err := primitive.ObjectIDFromHex(collection)
+	if err != nil {
+		return err
+	}
+	sess, err := s.sessions.Get(ctx, id)
+	if err != nil {
+		return err
+	}
+	s.states = append(s.states, sess.State)
+	return s.VectorStore.Upsert(ctx, collection, memories)
+}
+
+func TestImportLifecycleAndLimits(t *testing.T) {
+	hs := newTestHandlers(t)
+	store := &stateVectorStore{VectorStore: hs.vectors, sessions: hs.sessions}
+	hs.vectors = store
+	router := newTestRouter(hs)
+
+	var memories []string
+	for i := 0; i < exportPageSize+5; i++ {
+		memories = append(memories, `{"metadata":{"content":"memory `+strings.Repeat("x", i)+`"}}`)
+	}
+	export := `{"version":1, "session":{"name":"aspirin"}}` + "\n" + strings.Join(memories, "\n") + "\n"
+
+	// the session is creating until all the memories are upserted
+	w := serve(router, "POST", "/s/import", strings.NewReader(export))
+	assert.Equal(t, 200, w.Code, w.Body.String())
+	var res ImportSessionResponse
+	assert.NoError(t, json.NewDecoder(w.Body).Decode(&res))
+	assert.Equal(t, []SessionState{SessionCreating, SessionCreating}, store.states)
+
+	sess, err := hs.sessions.Get(context.TODO(), res.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, SessionActive, sess.State)
+
+	before, err := hs.sessions.List(context.TODO(), SessionQuery{All: true})
+	assert.NoError(t, err)
+
+	// the body is too large
+	hs.ImportLimit = int64(len(export) - 1)
+	w = serve(router, "POST", "/s/import", strings.NewReader(export))
+	assert.Equal(t, 413, w.Code, w.Body.String())
+
+	// the decompressed archive is too large
+	archive := func(size int) []byte {
+		var buf bytes.Buffer
+		gz := gzip.NewWriter(&buf)
+		tw := tar.NewWriter(gz)
+		assert.NoError(t, tw.WriteHeader(&tar.Header{Name: exportSessionFile, Mode: 0644, Size: int64(size)}))
+		_, err := tw.Write(bytes.Repeat([]byte(" "), size))
+		assert.NoError(t, err)
+		assert.NoError(t, tw.Close())
+		assert.NoError(t, gz.Close())
+		return buf.Bytes()
+	}
+	hs.ImportLimit = 64 << 10
+	compressed := archive(256 << 10)
+	assert.Less(t, len(compressed), int(hs.ImportLimit))
+	assert.Equal(t, 413, serve(router, "POST", "/s/import", bytes.NewReader(compressed)).Code)
+
+	// the entry is too large
+	hs.ImportLimit = defaultImportLimit
+	assert.Equal(t, 413, serve(router, "POST", "/s/import", bytes.NewReader(archive(exportEntryLimit+1))).Code)
+
+	after, err := hs.sessions.List(context.TODO(), SessionQuery{All: true})
+	assert.NoError(t, err)
+	assert.Equal(t, len(before), len(after))
+}
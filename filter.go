@@ -0,0 +1,184 @@
+package memo
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	pb "github.com/qdrant/go-client/qdrant"
+)
+
+// MemoryFilter restricts the memories by their metadata, empty fields are ignored
+type MemoryFilter struct {
+	Types         []MemoryType `bson:"types,omitempty" json:"types,omitempty"`                   // any of the types
+	MinImportance *int         `bson:"min_importance,omitempty" json:"min_importance,omitempty"` // importance greater than or equal to
+	MaxImportance *int         `bson:"max_importance,omitempty" json:"max_importance,omitempty"` // importance less than or equal to
+	CreatedAfter  *time.Time   `bson:"created_after,omitempty" json:"created_after,omitempty"`   // created at or after
+	CreatedBefore *time.Time   `bson:"created_before,omitempty" json:"created_before,omitempty"` // created before
+	ActiveAt      *time.Time   `bson:"active_at,omitempty" json:"active_at,omitempty"`           // plans which start at or before, and end after
+}
+
+// qdrant converts the filter into qdrant's conditions, nil if nothing to filter
+func (f *MemoryFilter) qdrant() *pb.Filter {
+	if f == nil {
+		return nil
+	}
+
+	var must []*pb.Condition
+	if len(f.Types) > 0 {
+		var types []string
+		for _, t := range f.Types {
+			types = append(types, t.String())
+		}
+		must = append(must, &pb.Condition{
+			ConditionOneOf: &pb.Condition_Field{Field: &pb.FieldCondition{
+				Key:   payloadType,
+				Match: &pb.Match{MatchValue: &pb.Match_Keywords{Keywords: &pb.RepeatedStrings{Strings: types}}},
+			}},
+		})
+	}
+
+	if f.MinImportance != nil || f.MaxImportance != nil {
+		r := &pb.Range{}
+		if f.MinImportance != nil {
+			gte := float64(*f.MinImportance)
+			r.Gte = &gte
+		}
+		if f.MaxImportance != nil {
+			lte := float64(*f.MaxImportance)
+			r.Lte = &lte
+		}
+		must = append(must, rangeCondition(payloadImportance, r))
+	}
+
+	if f.CreatedAfter != nil || f.CreatedBefore != nil {
+		r := &pb.Range{}
+		if f.CreatedAfter != nil {
+			gte := float64(f.CreatedAfter.UnixMilli())
+			r.Gte = &gte
+		}
+		if f.CreatedBefore != nil {
+			lt := float64(f.CreatedBefore.UnixMilli())
+			r.Lt = &lt
+		}
+		must = append(must, rangeCondition(payloadCreatedAt, r))
+	}
+
+	if f.ActiveAt != nil {
+		at := float64(f.ActiveAt.UnixMilli())
+		must = append(must,
+			rangeCondition(payloadStartAt, &pb.Range{Lte: &at}),
+			rangeCondition(payloadEndAt, &pb.Range{Gt: &at}),
+		)
+	}
+
+	if len(must) == 0 {
+		return nil
+	}
+	return &pb.Filter{Must: must}
+}
+
+// match reports whether the memory matches the filter, it's the same as qdrant's conditions
+func (f *MemoryFilter) match(m Memory) bool {
+	if f == nil {
+		return true
+	}
+
+	if len(f.Types) > 0 {
+		found := false
+		for _, t := range f.Types {
+			found = found || t == m.Metadata.Type
+		}
+		if !found {
+			return false
+		}
+	}
+
+	if f.MinImportance != nil && m.Metadata.Importance < *f.MinImportance {
+		return false
+	}
+	if f.MaxImportance != nil && m.Metadata.Importance > *f.MaxImportance {
+		return false
+	}
+
+	if f.CreatedAfter != nil && m.Metadata.CreatedAt.UnixMilli() < f.CreatedAfter.UnixMilli() {
+		return false
+	}
+	if f.CreatedBefore != nil && m.Metadata.CreatedAt.UnixMilli() >= f.CreatedBefore.UnixMilli() {
+		return false
+	}
+
+	if f.ActiveAt != nil {
+		at := f.ActiveAt.UnixMilli()
+		if m.Metadata.StartAt == nil || m.Metadata.EndAt == nil ||
+			m.Metadata.StartAt.UnixMilli() > at || m.Metadata.EndAt.UnixMilli() <= at {
+			return false
+		}
+	}
+
+	return true
+}
+
+// filterFromQuery parses the filter from the query parameters, nil if no one is given,
+// the types can be repeated or separated by commas, e.g. "?type=basic,interact&type=plan"
+func filterFromQuery(c *gin.Context) (*MemoryFilter, error) {
+	f := &MemoryFilter{}
+	empty := true
+
+	for _, s := range queryList(c, "type") {
+		t, err := ParseMemoryType(s)
+		if err != nil {
+			return nil, err
+		}
+		f.Types = append(f.Types, t)
+		empty = false
+	}
+
+	for key, dst := range map[string]**int{"min_importance": &f.MinImportance, "max_importance": &f.MaxImportance} {
+		if q := c.Query(key); q != "" {
+			n, err := strconv.Atoi(q)
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s: %w", key, err)
+			}
+			*dst = &n
+			empty = false
+		}
+	}
+
+	for key, dst := range map[string]**time.Time{"created_after": &f.CreatedAfter, "created_before": &f.CreatedBefore} {
+		if q := c.Query(key); q != "" {
+			t, err := time.Parse(time.RFC3339, q)
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s: %w", key, err)
+			}
+			*dst = &t
+			empty = false
+		}
+	}
+
+	if empty {
+		return nil, nil
+	}
+	return f, nil
+}
+
+// queryList gets the values of a query parameter, which can be repeated or separated by commas
+func queryList(c *gin.Context, key string) []string {
+	var values []string
+	for _, param := range c.QueryArray(key) {
+		for _, s := range strings.Split(param, ",") {
+			if s = strings.TrimSpace(s); s != "" {
+				values = append(values, s)
+			}
+		}
+	}
+	return values
+}
+
+func rangeCondition(key string, r *pb.Range) *pb.Condition {
+	return &pb.Condition{
+		ConditionOneOf: &pb.Condition_Field{Field: &pb.FieldCondition{Key: key, Range: r}},
+	}
+}
@@ -0,0 +1,79 @@
+package memo
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMemoryFilter(t *testing.T) {
+	var f *MemoryFilter
+	assert.Nil(t, f.qdrant())
+	assert.Nil(t, (&MemoryFilter{}).qdrant())
+
+	min, max := 5, 8
+	after := time.Date(2023, 6, 1, 0, 0, 0, 0, time.UTC)
+	before := after.Add(24 * time.Hour)
+	f = &MemoryFilter{
+		Types:         []MemoryType{InteractMemory},
+		MinImportance: &min,
+		MaxImportance: &max,
+		CreatedAfter:  &after,
+		CreatedBefore: &before,
+	}
+
+	// type, importance and created_at
+	must := f.qdrant().GetMust()
+	assert.Equal(t, 3, len(must))
+	assert.Equal(t, []string{"interact"}, must[0].GetField().GetMatch().GetKeywords().GetStrings())
+	assert.Equal(t, float64(5), must[1].GetField().GetRange().GetGte())
+	assert.Equal(t, float64(8), must[1].GetField().GetRange().GetLte())
+	assert.Equal(t, float64(after.UnixMilli()), must[2].GetField().GetRange().GetGte())
+	assert.Equal(t, float64(before.UnixMilli()), must[2].GetField().GetRange().GetLt())
+
+	m := Memory{Metadata: MemoryMetadata{Type: InteractMemory, Importance: 5, CreatedAt: after}}
+	assert.True(t, f.match(m))
+
+	m.Metadata.Importance = 9
+	assert.False(t, f.match(m))
+
+	m.Metadata.Importance = 8
+	m.Metadata.CreatedAt = before
+	assert.False(t, f.match(m))
+
+	m.Metadata.CreatedAt = after
+	m.Metadata.Type = BasicMemory
+	assert.False(t, f.match(m))
+}
+
+func TestFilterFromQuery(t *testing.T) {
+	parse := func(query string) (*MemoryFilter, error) {
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		c.Request = httptest.NewRequest("GET", "/m/session?"+query, nil)
+		return filterFromQuery(c)
+	}
+
+	f, err := parse("offset=abc")
+	assert.NoError(t, err)
+	assert.Nil(t, f)
+
+	f, err = parse("type=basic,interact&type=plan&min_importance=5&created_after=2023-06-01T00:00:00Z")
+	assert.NoError(t, err)
+	assert.Equal(t, []MemoryType{BasicMemory, InteractMemory, PlanMemory}, f.Types)
+	assert.Equal(t, 5, *f.MinImportance)
+	assert.Nil(t, f.MaxImportance)
+	assert.Equal(t, time.Date(2023, 6, 1, 0, 0, 0, 0, time.UTC), *f.CreatedAfter)
+	assert.Nil(t, f.CreatedBefore)
+
+	_, err = parse("type=unknown")
+	assert.Error(t, err)
+
+	_, err = parse("max_importance=high")
+	assert.Error(t, err)
+
+	_, err = parse("created_before=yesterday")
+	assert.Error(t, err)
+}
@@ -0,0 +1,126 @@
+package memo
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// ForkSessionRequest branches a session, the name is kept if it's not given
+type ForkSessionRequest struct {
+	Name  *string    `bson:"name,omitempty" json:"name,omitempty"`
+	Until *time.Time `bson:"until,omitempty" json:"until,omitempty"` // only the memories created at or before
+}
+
+type ForkSessionResponse struct {
+	ID       primitive.ObjectID `json:"_id"`
+	Memories int                `json:"memories"` // number of the copied memories
+}
+
+// @Summary		fork one session
+// @Description	create a new session with the metadata and memories of the session, the vectors are copied without re-embedding
+// @Tags			sessions
+// @Accept			json
+// @Produce		json
+// @Param			id		path		string				true	"the session to fork"
+// @Param			fork	body		ForkSessionRequest	false	"the name of the new session, and the time to branch at"
+// @Success		200		{object}	ForkSessionResponse
+// @Failure		default	{object}	APIError
+// @Security		ApiKeyAuth
+// @Router			/s/{id}/fork [post]
+func (h *Handlers) ForkSession(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	sess, err := h.findSession(ctx, tenant(c), c.Param("id"))
+	if err != nil {
+		sessionError(c, err)
+		return
+	}
+
+	// the body is optional
+	var req ForkSessionRequest
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			NewError(c, http.StatusBadRequest, err)
+			return
+		}
+	}
+	if req.Name != nil && *req.Name == "" {
+		NewError(c, http.StatusBadRequest, ErrEmptySessionName)
+		return
+	}
+
+	fork := *sess
+	fork.ID = primitive.NilObjectID
+	fork.CreatedAt = primitive.NewDateTimeFromTime(time.Now())
+	if req.Name != nil {
+		fork.Name = *req.Name
+	}
+	// the fork at a time may not have the memories of the reflection and its accumulated importance,
+	// so it has never reflected, and its importance is accumulated from the copied memories
+	if req.Until != nil {
+		fork.AccImportance = 0
+		fork.ReflectedAt = 0
+	}
+
+	// the fork is activated after all the memories are copied
+	fid, err := h.prepareSession(ctx, &fork)
+	if err != nil {
+		NewError(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	var filter *MemoryFilter
+	if req.Until != nil {
+		// the created time is stored in milliseconds
+		before := time.UnixMilli(req.Until.UnixMilli() + 1)
+		filter = &MemoryFilter{CreatedBefore: &before}
+	}
+
+	copied, importance, err := h.copyMemories(ctx, sess.ID.Hex(), fid, filter)
+	if err != nil {
+		// don't leave a partial fork
+		h.rollback(fid, true)
+		memoryError(c, err)
+		return
+	}
+
+	if req.Until != nil {
+		if err := h.sessions.AddImportance(ctx, fid, importance); err != nil {
+			h.rollback(fid, true)
+			NewError(c, http.StatusInternalServerError, err)
+			return
+		}
+		fork.AccImportance = importance
+	}
+
+	if err := h.activateSession(ctx, fid, &fork); err != nil {
+		NewError(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, ForkSessionResponse{ID: fid, Memories: copied})
+}
+
+// copyMemories copies the memories with their ids and vectors, so the evidence of reflections still works,
+// the session being filled is renewed after each page, returns the number and importance of the copied memories,
+// the reflections are not counted as they don't trigger other reflections
+func (h *Handlers) copyMemories(ctx context.Context, from string, to primitive.ObjectID, filter *MemoryFilter) (int, int, error) {
+	copied, importance := 0, 0
+	err := h.scrollPages(ctx, from, filter, true, func(memories []Memory) error {
+		if err := h.vectors.Upsert(ctx, to.Hex(), memories); err != nil {
+			return err
+		}
+		copied += len(memories)
+		for _, m := range memories {
+			if m.Metadata.Type != ReflectionMemory {
+				importance += m.Metadata.Importance
+			}
+		}
+		return h.renewSession(ctx, to)
+	})
+	return copied, importance, err
+}
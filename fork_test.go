@@ -0,0 +1,104 @@
+package memo
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestForkSession(t *testing.T) {
+	hs := newTestHandlers(t)
+	store := &stateVectorStore{VectorStore: hs.vectors, sessions: hs.sessions}
+	hs.vectors = store
+	router := newTestRouter(hs)
+
+	w := serve(router, "POST", "/s/add", strings.NewReader(`{"name":"aspirin2d", "attributes":{"status":"awake"}}`))
+	assert.Equal(t, 200, w.Code)
+	var added SessionAddResponse
+	assert.NoError(t, json.NewDecoder(w.Body).Decode(&added))
+	sid := added.ID.Hex()
+
+	w = serve(router, "POST", "/m/"+sid+"/add", strings.NewReader(`{"memories":[
+    {"metadata":{"content":"woke up", "importance":2, "created_at":"2023-06-01T08:00:00Z"}},
+    {"metadata":{"type":"reflection", "content":"aspirin is an early bird", "importance":5, "created_at":"2023-06-01T08:30:00Z"}},
+    {"metadata":{"content":"had breakfast", "importance":3, "created_at":"2023-06-01T09:00:00Z"}},
+    {"metadata":{"content":"went to school", "importance":4, "created_at":"2023-06-01T10:00:00Z"}}
+  ]}`))
+	assert.Equal(t, 200, w.Code)
+
+	// the source has reflected, and accumulated the importance since
+	reflectedAt := primitive.NewDateTimeFromTime(time.Date(2023, 6, 1, 9, 30, 0, 0, time.UTC))
+	_, err := hs.sessions.ResetImportance(context.TODO(), added.ID, 0, reflectedAt.Time())
+	assert.NoError(t, err)
+	assert.NoError(t, hs.sessions.AddImportance(context.TODO(), added.ID, 4))
+
+	fork := func(body string) ForkSessionResponse {
+		w := serve(router, "POST", "/s/"+sid+"/fork", strings.NewReader(body))
+		assert.Equal(t, 200, w.Code, w.Body.String())
+		var res ForkSessionResponse
+		assert.NoError(t, json.NewDecoder(w.Body).Decode(&res))
+		return res
+	}
+
+	// fork everything, without body, the fork is creating until the memories are copied
+	store.states = nil
+	res := fork("")
+	assert.Equal(t, 4, res.Memories)
+	assert.Equal(t, []SessionState{SessionCreating}, store.states)
+
+	w = serve(router, "GET", "/s/"+res.ID.Hex(), nil)
+	var sess Session
+	assert.NoError(t, json.NewDecoder(w.Body).Decode(&sess))
+	assert.Equal(t, "aspirin2d", sess.Name)
+	assert.Equal(t, "awake", sess.Attributes["status"])
+	assert.Equal(t, SessionActive, sess.State)
+	assert.Equal(t, 4, sess.AccImportance)
+	assert.Equal(t, reflectedAt, sess.ReflectedAt)
+
+	// the vectors and ids are copied
+	source, _, err := hs.vectors.Scroll(context.TODO(), sid, nil, "", 10)
+	assert.NoError(t, err)
+	var ids []string
+	for _, m := range source {
+		ids = append(ids, m.ID)
+	}
+	original, err := hs.vectors.Get(context.TODO(), sid, ids, true)
+	assert.NoError(t, err)
+	copied, err := hs.vectors.Get(context.TODO(), res.ID.Hex(), ids, true)
+	assert.NoError(t, err)
+	assert.Equal(t, original, copied)
+
+	// fork at a time, the boundary is included
+	res = fork(`{"name":"aspirin2d-b", "until":"2023-06-01T09:00:00Z"}`)
+	assert.Equal(t, 3, res.Memories)
+
+	w = serve(router, "GET", "/m/"+res.ID.Hex()+"?limit=10", nil)
+	var memories RetrieveMemoriesResponse
+	assert.NoError(t, json.NewDecoder(w.Body).Decode(&memories))
+	assert.Equal(t, 3, len(memories.Memories))
+	for _, m := range memories.Memories {
+		assert.NotEqual(t, "went to school", m.Metadata.Content)
+	}
+
+	// it has never reflected, the importance is accumulated from the copied memories but the reflection
+	w = serve(router, "GET", "/s/"+res.ID.Hex(), nil)
+	sess = Session{}
+	assert.NoError(t, json.NewDecoder(w.Body).Decode(&sess))
+	assert.Equal(t, "aspirin2d-b", sess.Name)
+	assert.Equal(t, 5, sess.AccImportance)
+	assert.Zero(t, sess.ReflectedAt)
+
+	// the source is not changed
+	count, err := hs.vectors.Count(context.TODO(), sid, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(4), count)
+
+	assert.Equal(t, 400, serve(router, "POST", "/s/"+sid+"/fork", strings.NewReader(`{"name":""}`)).Code)
+	assert.Equal(t, 400, serve(router, "POST", "/s/"+sid+"/fork", strings.NewReader(`{"until":"yesterday"}`)).Code)
+	assert.Equal(t, 404, serve(router, "POST", "/s/"+primitive.NewObjectID().Hex()+"/fork", nil).Code)
+}
@@ -0,0 +1,102 @@
+package memo
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const pingTimeout = 3 * time.Second // timeout of each readiness check
+
+type ReadyResponse struct {
+	Ready  bool              `json:"ready"`
+	Checks map[string]string `json:"checks" example:"mongodb:ok,qdrant:ok,llm:ok"` // "ok" or "down" of each dependency, the errors are only logged
+}
+
+type MetricsResponse struct {
+	EmbeddingCache *CacheStats `json:"embedding_cache,omitempty"` // missing if the cache is disabled
+}
+
+// @Summary		liveness probe
+// @Description	the server is running
+// @Tags			health
+// @Produce		json
+// @Success		200	{object}	OK
+// @Router			/healthz [get]
+func (hs *Handlers) Healthz(c *gin.Context) {
+	c.JSON(http.StatusOK, OK{OK: true})
+}
+
+// @Summary		readiness probe
+// @Description	ping mongodb, qdrant and the llm provider
+// @Tags			health
+// @Produce		json
+// @Success		200	{object}	ReadyResponse
+// @Failure		503	{object}	ReadyResponse
+// @Router			/readyz [get]
+func (hs *Handlers) Readyz(c *gin.Context) {
+	res := hs.ready(c.Request.Context())
+
+	status := http.StatusOK
+	if !res.Ready {
+		status = http.StatusServiceUnavailable
+	}
+	c.JSON(status, res)
+}
+
+// ready pings all the dependencies concurrently
+func (hs *Handlers) ready(ctx context.Context) ReadyResponse {
+	checks := map[string]func(context.Context) error{
+		"mongodb": hs.sessions.Ping,
+		"qdrant":  hs.vectors.Ping,
+		"llm":     hs.llm.ping,
+	}
+
+	res := ReadyResponse{Ready: true, Checks: map[string]string{}}
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for name, ping := range checks {
+		wg.Add(1)
+		go func(name string, ping func(context.Context) error) {
+			defer wg.Done()
+
+			ctx, cancel := context.WithTimeout(ctx, pingTimeout)
+			defer cancel()
+
+			// the errors may contain the addresses of the dependencies, which are not exposed to the unauthenticated callers
+			result := "ok"
+			err := ping(ctx)
+			if err != nil {
+				log.Printf("%s is not ready: %v", name, err)
+				result = "down"
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			res.Checks[name] = result
+			res.Ready = res.Ready && err == nil
+		}(name, ping)
+	}
+	wg.Wait()
+
+	return res
+}
+
+// @Summary		metrics of the server
+// @Description	the hits and misses of the embedding cache since the server started
+// @Tags			health
+// @Produce		json
+// @Success		200	{object}	MetricsResponse
+// @Router			/metrics [get]
+func (hs *Handlers) Metrics(c *gin.Context) {
+	var res MetricsResponse
+	if e, ok := hs.embedder.(*CachedEmbedder); ok {
+		stats := e.Stats()
+		res.EmbeddingCache = &stats
+	}
+	c.JSON(http.StatusOK, res)
+}
@@ -0,0 +1,102 @@
+package memo
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// unreachableStore fails the ping
+type unreachableStore struct {
+	*InMemoryVectorStore
+}
+
+func (s unreachableStore) Ping(ctx context.Context) error {
+	return errors.New("connection refused")
+}
+
+func TestHealth(t *testing.T) {
+	hs := newTestHandlers(t)
+	router := newTestRouter(hs)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/healthz", nil)
+	router.ServeHTTP(w, req)
+	assert.Equal(t, 200, w.Code)
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/readyz", nil)
+	router.ServeHTTP(w, req)
+	assert.Equal(t, 200, w.Code)
+
+	var res ReadyResponse
+	assert.NoError(t, json.NewDecoder(w.Body).Decode(&res))
+	assert.True(t, res.Ready)
+	assert.Equal(t, map[string]string{"mongodb": "ok", "qdrant": "ok", "llm": "ok"}, res.Checks)
+
+	hs.vectors = unreachableStore{NewInMemoryVectorStore()}
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/readyz", nil)
+	router.ServeHTTP(w, req)
+	assert.Equal(t, 503, w.Code)
+
+	res = ReadyResponse{}
+	assert.NoError(t, json.NewDecoder(w.Body).Decode(&res))
+	assert.False(t, res.Ready)
+	assert.Equal(t, "down", res.Checks["qdrant"])
+	assert.Equal(t, "ok", res.Checks["mongodb"])
+}
+
+func TestRetry(t *testing.T) {
+	calls := 0
+	err := retry(context.TODO(), "test", 3, time.Millisecond, func(ctx context.Context) error {
+		calls++
+		if calls < 2 {
+			return errors.New("not yet")
+		}
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, calls)
+
+	calls = 0
+	err = retry(context.TODO(), "test", 3, time.Millisecond, func(ctx context.Context) error {
+		calls++
+		return errors.New("never")
+	})
+	assert.EqualError(t, err, "never")
+	assert.Equal(t, 3, calls)
+
+	// stop waiting when the context is done
+	ctx, cancel := context.WithCancel(context.TODO())
+	cancel()
+	err = retry(ctx, "test", 3, time.Hour, func(ctx context.Context) error {
+		return errors.New("never")
+	})
+	assert.ErrorIs(t, err, context.Canceled)
+}
+
+func TestClose(t *testing.T) {
+	hs := newTestHandlers(t)
+
+	done := make(chan struct{})
+	hs.background.Add(1)
+	go func() {
+		defer hs.background.Done()
+		<-done
+	}()
+
+	// gives up waiting when the context is done
+	ctx, cancel := context.WithTimeout(context.TODO(), 10*time.Millisecond)
+	defer cancel()
+	assert.NoError(t, hs.Close(ctx))
+
+	close(done)
+	assert.NoError(t, hs.Close(context.TODO()))
+}
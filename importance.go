@@ -0,0 +1,256 @@
+package memo
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const (
+	MinImportance = 1
+	MaxImportance = 10
+
+	defaultImportance = 5  // used when the importance can't be scored
+	scoreBatchSize    = 10 // how many memories to score in one request
+)
+
+// scoreImportance fills the missing importance of memories with the llm,
+// a failed memory falls back to the default importance instead of failing the whole request,
+// unless it's rate limited, then it fails with the error of its batch, so it can be retried later
+func (hs *Handlers) scoreImportance(ctx context.Context, memories []Memory) []error {
+	errs := make([]error, len(memories))
+	var limited error // the rest are not sent once the provider is rate limited
+	var unscored []int
+	for i, m := range memories {
+		if m.Metadata.Importance == 0 {
+			unscored = append(unscored, i)
+		}
+	}
+
+	for start := 0; start < len(unscored); start += scoreBatchSize {
+		end := start + scoreBatchSize
+		if end > len(unscored) {
+			end = len(unscored)
+		}
+		batch := unscored[start:end]
+
+		var contents []string
+		for _, i := range batch {
+			contents = append(contents, memories[i].Metadata.Content)
+		}
+
+		var scores []int
+		err := limited
+		if err == nil {
+			scores, err = hs.llm.ScoreMemories(ctx, hs.prompts.ScoreImportance, contents)
+			if err != nil {
+				log.Printf("can't score the importance of %d memories: %v", len(batch), err)
+			}
+			if err != nil && isRateLimited(err) {
+				limited = err
+			}
+		}
+
+		for j, i := range batch {
+			switch {
+			case j < len(scores) && scores[j] != 0:
+				memories[i].Metadata.Importance = scores[j]
+			case limited != nil:
+				errs[i] = &scoreError{cause: limited}
+			default:
+				memories[i].Metadata.Importance = defaultImportance
+			}
+		}
+	}
+	return errs
+}
+
+// scoreError is ErrScoreImportance to the client, and keeps the cause, e.g. the rate limit
+type scoreError struct {
+	cause error
+}
+
+func (e *scoreError) Error() string   { return ErrScoreImportance.Error() }
+func (e *scoreError) Unwrap() []error { return []error{ErrScoreImportance, e.cause} }
+
+// clampImportance limits the importance score from 1 to 10
+func clampImportance(score int) int {
+	if score < MinImportance {
+		return MinImportance
+	}
+	if score > MaxImportance {
+		return MaxImportance
+	}
+	return score
+}
+
+// scoreFunction asks the model to score the memories in json, the scores are keyed by the positions of the memories,
+// so the missing or extra ones are detected
+var scoreFunction = Function{
+	Name:        "score_memories",
+	Description: "save the importance scores of the memories, which are separated by semicolons",
+	Parameters: json.RawMessage(`{
+	"type": "object",
+	"properties": {
+		"scores": {
+			"type": "array",
+			"description": "one score for each memory",
+			"items": {
+				"type": "object",
+				"properties": {
+					"index": {"type": "integer", "description": "position of the memory, starting from 1"},
+					"score": {"type": "integer", "minimum": 1, "maximum": 10}
+				},
+				"required": ["index", "score"]
+			}
+		}
+	},
+	"required": ["scores"]
+}`),
+}
+
+var (
+	// e.g. "1. 8", "2) 7" or "3: 6", the dot must be followed by a space, or it's a decimal
+	numberedScore = regexp.MustCompile(`^\s*(\d+)\s*(?:\.\s+|[)）:：]\s*)(\d+(?:\.\d+)?)`)
+	scoreNumber   = regexp.MustCompile(`\d+(?:\.\d+)?`)
+)
+
+// parseScores parses the scores of n memories from the arguments of scoreFunction, a json reply,
+// or a free-text one, e.g. "1，8。" or one numbered score per line,
+// the scores are clamped from 1 to 10, and 0 means the memory's score is missing or invalid
+func parseScores(reply string, n int) []int {
+	reply = strings.TrimSpace(reply)
+	if scores, ok := parseJSONScores(reply, n); ok {
+		return scores
+	}
+	if scores, ok := parseNumberedScores(reply, n); ok {
+		return scores
+	}
+
+	scores := make([]int, n)
+	numbers := scoreNumber.FindAllString(reply, -1)
+	if n == 1 && len(numbers) > 0 {
+		// e.g. "8/10"
+		numbers = numbers[:1]
+	}
+	if len(numbers) != n {
+		// can't tell which score belongs to which memory
+		return scores
+	}
+	for i, number := range numbers {
+		scores[i] = toScore(number)
+	}
+	return scores
+}
+
+// parseJSONScores accepts {"scores": [...]} or [...], of which the items are either
+// {"index": 1, "score": 8}, or the scores in the order of the memories
+func parseJSONScores(reply string, n int) ([]int, bool) {
+	// the json may be quoted in a markdown code block
+	if strings.HasPrefix(reply, "```") {
+		reply = strings.TrimPrefix(reply, "```")
+		reply = strings.TrimPrefix(reply, "json")
+		reply = strings.TrimSuffix(strings.TrimSpace(reply), "```")
+	}
+
+	dec := json.NewDecoder(bytes.NewReader([]byte(reply)))
+	dec.UseNumber()
+	var v any
+	if err := dec.Decode(&v); err != nil {
+		return nil, false
+	}
+
+	if obj, ok := v.(map[string]any); ok {
+		v = obj["scores"]
+	}
+	items, ok := v.([]any)
+	if !ok {
+		return nil, false
+	}
+
+	scores := make([]int, n)
+	seen := make([]bool, n)
+	for i, item := range items {
+		index, value := i+1, item
+		if obj, ok := item.(map[string]any); ok {
+			index, value = toIndex(obj["index"]), obj["score"]
+		}
+		if index < 1 || index > n {
+			continue
+		}
+
+		// the conflicting scores of the same memory are both invalid
+		if seen[index-1] {
+			scores[index-1] = 0
+			continue
+		}
+		seen[index-1] = true
+		scores[index-1] = toScore(value)
+	}
+	return scores, true
+}
+
+// parseNumberedScores parses one score per line, which starts with the position of the memory
+func parseNumberedScores(reply string, n int) ([]int, bool) {
+	scores := make([]int, n)
+	var lines int
+	for _, line := range strings.Split(reply, "\n") {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		m := numberedScore.FindStringSubmatch(line)
+		if m == nil {
+			return nil, false
+		}
+		lines++
+
+		if index := toIndex(m[1]); index >= 1 && index <= n {
+			scores[index-1] = toScore(m[2])
+		}
+	}
+	return scores, lines > 0
+}
+
+// toScore converts the json number or the string into the clamped score, or 0 if it's not a number
+func toScore(v any) int {
+	var s string
+	switch v := v.(type) {
+	case json.Number:
+		s = v.String()
+	case string:
+		s = strings.TrimSpace(v)
+	default:
+		return 0
+	}
+
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil || math.IsNaN(f) || math.IsInf(f, 0) {
+		return 0
+	}
+	// clamp before the conversion, so a huge number doesn't overflow
+	return int(math.Max(math.Min(math.Round(f), MaxImportance), MinImportance))
+}
+
+// toIndex converts the json number or the string into the integer, or 0 if it's not one
+func toIndex(v any) int {
+	var s string
+	switch v := v.(type) {
+	case json.Number:
+		s = v.String()
+	case string:
+		s = strings.TrimSpace(v)
+	default:
+		return 0
+	}
+
+	i, err := strconv.Atoi(s)
+	if err != nil {
+		return 0
+	}
+	return i
+}
@@ -0,0 +1,487 @@
+package memo
+
+import (
+	"context"
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// InMemorySessionStore keeps the sessions in process, it's useful for testing
+type InMemorySessionStore struct {
+	mu       sync.RWMutex
+	sessions map[primitive.ObjectID]Session
+}
+
+func NewInMemorySessionStore() *InMemorySessionStore {
+	return &InMemorySessionStore{sessions: map[primitive.ObjectID]Session{}}
+}
+
+func (s *InMemorySessionStore) Ping(ctx context.Context) error  { return nil }
+func (s *InMemorySessionStore) Close(ctx context.Context) error { return nil }
+
+func (s *InMemorySessionStore) List(ctx context.Context, q SessionQuery) ([]Session, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	results := []Session{}
+	for id, sess := range s.sessions {
+		if !q.All && (sess.Owner != q.Owner || !sess.active()) {
+			continue
+		}
+		if !hasTags(sess.Tags, q.Tags) {
+			continue
+		}
+		if q.Offset.IsZero() || bytesLess(id, q.Offset) {
+			results = append(results, sess)
+		}
+	}
+
+	// the newest first, same as sorting by mongodb's object id
+	sort.Slice(results, func(i, j int) bool { return bytesLess(results[j].ID, results[i].ID) })
+
+	// zero limit means no limit, same as mongodb
+	if q.Limit > 0 && int64(len(results)) > q.Limit {
+		results = results[:q.Limit]
+	}
+	return results, nil
+}
+
+func (s *InMemorySessionStore) Create(ctx context.Context, sess *Session) (primitive.ObjectID, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if sess.ID.IsZero() {
+		sess.ID = primitive.NewObjectID()
+	}
+	if _, ok := s.sessions[sess.ID]; ok {
+		return primitive.NilObjectID, ErrInvalidID
+	}
+
+	s.sessions[sess.ID] = *sess
+	return sess.ID, nil
+}
+
+func (s *InMemorySessionStore) Get(ctx context.Context, id primitive.ObjectID) (*Session, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	sess, ok := s.sessions[id]
+	if !ok {
+		return nil, ErrSessionNotFound
+	}
+	return &sess, nil
+}
+
+func (s *InMemorySessionStore) Delete(ctx context.Context, id primitive.ObjectID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.sessions[id]; !ok {
+		return ErrSessionNotFound
+	}
+	delete(s.sessions, id)
+	return nil
+}
+
+func (s *InMemorySessionStore) SetState(ctx context.Context, id primitive.ObjectID, state SessionState, at time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sess, ok := s.sessions[id]
+	if !ok {
+		return ErrSessionNotFound
+	}
+	sess.State = state
+	sess.UpdatedAt = primitive.NewDateTimeFromTime(at)
+	s.sessions[id] = sess
+	return nil
+}
+
+func (s *InMemorySessionStore) Update(ctx context.Context, id primitive.ObjectID, req *UpdateSessionRequest, at time.Time) (*Session, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sess, ok := s.sessions[id]
+	if !ok {
+		return nil, ErrSessionNotFound
+	}
+
+	if req.Name != nil {
+		sess.Name = *req.Name
+	}
+	if req.Desc != nil {
+		sess.Desc = *req.Desc
+	}
+	if req.Tags != nil {
+		sess.Tags = append([]string(nil), *req.Tags...)
+	}
+	if len(req.Attributes) > 0 {
+		// copy the attributes, the returned sessions share the map
+		attrs := map[string]string{}
+		for k, v := range sess.Attributes {
+			attrs[k] = v
+		}
+		for k, v := range req.Attributes {
+			if v == nil {
+				delete(attrs, k)
+			} else {
+				attrs[k] = *v
+			}
+		}
+		sess.Attributes = attrs
+	}
+	sess.UpdatedAt = primitive.NewDateTimeFromTime(at)
+
+	s.sessions[id] = sess
+	return &sess, nil
+}
+
+func (s *InMemorySessionStore) AddImportance(ctx context.Context, id primitive.ObjectID, delta int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sess, ok := s.sessions[id]
+	if !ok {
+		return ErrSessionNotFound
+	}
+	sess.AccImportance += delta
+	s.sessions[id] = sess
+	return nil
+}
+
+func (s *InMemorySessionStore) ResetImportance(ctx context.Context, id primitive.ObjectID, min int, at time.Time) (*Session, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sess, ok := s.sessions[id]
+	if !ok || sess.AccImportance < min {
+		return nil, ErrSessionNotFound
+	}
+
+	updated := sess
+	updated.AccImportance = 0
+	updated.ReflectedAt = primitive.NewDateTimeFromTime(at)
+	s.sessions[id] = updated
+	return &sess, nil
+}
+
+func (s *InMemorySessionStore) RestoreImportance(ctx context.Context, id primitive.ObjectID, delta int, reflectedAt primitive.DateTime, at time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sess, ok := s.sessions[id]
+	if !ok {
+		return ErrSessionNotFound
+	}
+	sess.AccImportance += delta
+	if sess.ReflectedAt == primitive.NewDateTimeFromTime(at) {
+		sess.ReflectedAt = reflectedAt
+	}
+	s.sessions[id] = sess
+	return nil
+}
+
+// hasTags reports whether the tags contain all the wanted ones
+func hasTags(tags, wanted []string) bool {
+	for _, w := range wanted {
+		found := false
+		for _, t := range tags {
+			if t == w {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+func bytesLess(a, b primitive.ObjectID) bool {
+	for i := range a {
+		if a[i] != b[i] {
+			return a[i] < b[i]
+		}
+	}
+	return false
+}
+
+// InMemoryVectorStore keeps the memories in process, and searches them by brute force,
+// it's useful for testing
+type InMemoryVectorStore struct {
+	mu          sync.RWMutex
+	collections map[string]map[string]Memory
+	dimensions  map[string]int
+}
+
+func NewInMemoryVectorStore() *InMemoryVectorStore {
+	return &InMemoryVectorStore{
+		collections: map[string]map[string]Memory{},
+		dimensions:  map[string]int{},
+	}
+}
+
+func (s *InMemoryVectorStore) Ping(ctx context.Context) error  { return nil }
+func (s *InMemoryVectorStore) Close(ctx context.Context) error { return nil }
+
+func (s *InMemoryVectorStore) EnsureCollection(ctx context.Context, name string, dimension int) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.collections[name]; ok {
+		return false, nil
+	}
+	s.collections[name] = map[string]Memory{}
+	s.dimensions[name] = dimension
+	return true, nil
+}
+
+func (s *InMemoryVectorStore) DeleteCollection(ctx context.Context, name string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.collections[name]; !ok {
+		return false, nil
+	}
+	delete(s.collections, name)
+	delete(s.dimensions, name)
+	return true, nil
+}
+
+func (s *InMemoryVectorStore) Collections(ctx context.Context) ([]string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	names := make([]string, 0, len(s.collections))
+	for name := range s.collections {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+func (s *InMemoryVectorStore) Upsert(ctx context.Context, collection string, memories []Memory) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	coll, ok := s.collections[collection]
+	if !ok {
+		return ErrCollectionNotFound
+	}
+
+	// qdrant rejects the whole batch if any vector has a wrong dimension
+	for _, m := range memories {
+		if len(m.Embedding) != s.dimensions[collection] {
+			return ErrEmbeddingMismatch
+		}
+	}
+
+	for _, m := range memories {
+		// encode and decode the metadata, to keep the same precision as qdrant
+		coll[m.ID] = Memory{
+			ID:        m.ID,
+			Embedding: append([]float32(nil), m.Embedding...),
+			Metadata:  ParseMetadata(m.Metadata.Payload()),
+		}
+	}
+	return nil
+}
+
+func (s *InMemoryVectorStore) Search(ctx context.Context, collection string, vector []float32, limit uint64, filter *MemoryFilter) ([]Memory, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	coll, ok := s.collections[collection]
+	if !ok {
+		return nil, ErrCollectionNotFound
+	}
+
+	var memories []Memory
+	for _, m := range coll {
+		if !filter.match(m) {
+			continue
+		}
+		m.Score = cosine(vector, m.Embedding)
+		m.Embedding = nil
+		memories = append(memories, m)
+	}
+
+	sort.Slice(memories, func(i, j int) bool {
+		if memories[i].Score == memories[j].Score {
+			return memories[i].ID < memories[j].ID
+		}
+		return memories[i].Score > memories[j].Score
+	})
+
+	if uint64(len(memories)) > limit {
+		memories = memories[:limit]
+	}
+	return memories, nil
+}
+
+func (s *InMemoryVectorStore) Scroll(ctx context.Context, collection string, filter *MemoryFilter, offset string, limit uint32) ([]Memory, string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	coll, ok := s.collections[collection]
+	if !ok {
+		return nil, "", ErrCollectionNotFound
+	}
+
+	// ordered by id, and the offset is included, same as qdrant
+	var memories []Memory
+	for id, m := range coll {
+		if id >= offset && filter.match(m) {
+			m.Embedding = nil
+			memories = append(memories, m)
+		}
+	}
+	sort.Slice(memories, func(i, j int) bool { return memories[i].ID < memories[j].ID })
+
+	next := ""
+	if uint32(len(memories)) > limit {
+		next = memories[limit].ID
+		memories = memories[:limit]
+	}
+	return memories, next, nil
+}
+
+func (s *InMemoryVectorStore) Touch(ctx context.Context, collection string, ids []string, at time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	coll, ok := s.collections[collection]
+	if !ok {
+		return ErrCollectionNotFound
+	}
+
+	for _, id := range ids {
+		if m, ok := coll[id]; ok {
+			m.Metadata.AccessedAt = time.UnixMilli(at.UnixMilli()).UTC()
+			coll[id] = m
+		}
+	}
+	return nil
+}
+
+func (s *InMemoryVectorStore) Get(ctx context.Context, collection string, ids []string, withVectors bool) ([]Memory, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	coll, ok := s.collections[collection]
+	if !ok {
+		return nil, ErrCollectionNotFound
+	}
+
+	var memories []Memory
+	for _, id := range ids {
+		m, ok := coll[id]
+		if !ok {
+			continue
+		}
+		if withVectors {
+			m.Embedding = append([]float32(nil), m.Embedding...)
+		} else {
+			m.Embedding = nil
+		}
+		memories = append(memories, m)
+	}
+	return memories, nil
+}
+
+func (s *InMemoryVectorStore) Count(ctx context.Context, collection string, filter *MemoryFilter) (uint64, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	coll, ok := s.collections[collection]
+	if !ok {
+		return 0, ErrCollectionNotFound
+	}
+
+	var count uint64
+	for _, m := range coll {
+		if filter.match(m) {
+			count++
+		}
+	}
+	return count, nil
+}
+
+func (s *InMemoryVectorStore) Stats(ctx context.Context, collection string) (*MemoryStats, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	coll, ok := s.collections[collection]
+	if !ok {
+		return nil, ErrCollectionNotFound
+	}
+
+	stats := newMemoryStats()
+	for _, m := range coll {
+		stats.add(m.Metadata)
+	}
+	stats.Collection = CollectionInfo{
+		Status:    "green",
+		Points:    uint64(len(coll)),
+		Vectors:   uint64(len(coll)),
+		Segments:  1,
+		Dimension: s.dimensions[collection],
+	}
+	return stats, nil
+}
+
+func (s *InMemoryVectorStore) Delete(ctx context.Context, collection string, ids []string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	coll, ok := s.collections[collection]
+	if !ok {
+		return ErrCollectionNotFound
+	}
+
+	for _, id := range ids {
+		delete(coll, id)
+	}
+	return nil
+}
+
+func (s *InMemoryVectorStore) DeleteByFilter(ctx context.Context, collection string, filter *MemoryFilter) error {
+	if filter.qdrant() == nil {
+		return ErrEmptyFilter
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	coll, ok := s.collections[collection]
+	if !ok {
+		return ErrCollectionNotFound
+	}
+
+	for id, m := range coll {
+		if filter.match(m) {
+			delete(coll, id)
+		}
+	}
+	return nil
+}
+
+// cosine similarity of two vectors, 0 if any of them is zero
+func cosine(a, b []float32) float32 {
+	var dot, na, nb float64
+	for i := 0; i < len(a) && i < len(b); i++ {
+		dot += float64(a[i]) * float64(b[i])
+		na += float64(a[i]) * float64(a[i])
+		nb += float64(b[i]) * float64(b[i])
+	}
+
+	if na == 0 || nb == 0 {
+		return 0
+	}
+	return float32(dot / (math.Sqrt(na) * math.Sqrt(nb)))
+}
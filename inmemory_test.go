@@ -0,0 +1,152 @@
+package memo
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestInMemorySessionStore(t *testing.T) {
+	ctx := context.TODO()
+	store := NewInMemorySessionStore()
+
+	var ids []primitive.ObjectID
+	for i := 0; i < 3; i++ {
+		id, err := store.Create(ctx, &Session{Name: "aspirin"})
+		assert.NoError(t, err)
+		ids = append(ids, id)
+	}
+
+	// the newest first
+	sessions, err := store.List(ctx, SessionQuery{Limit: 2})
+	assert.NoError(t, err)
+	assert.Equal(t, []primitive.ObjectID{ids[2], ids[1]}, []primitive.ObjectID{sessions[0].ID, sessions[1].ID})
+
+	sessions, err = store.List(ctx, SessionQuery{Offset: ids[1], Limit: 2})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(sessions))
+	assert.Equal(t, ids[0], sessions[0].ID)
+
+	assert.NoError(t, store.AddImportance(ctx, ids[0], 10))
+	_, err = store.ResetImportance(ctx, ids[0], 20, time.Now())
+	assert.ErrorIs(t, err, ErrSessionNotFound)
+
+	sess, err := store.ResetImportance(ctx, ids[0], 10, time.Now())
+	assert.NoError(t, err)
+	assert.Equal(t, 10, sess.AccImportance)
+
+	sess, err = store.Get(ctx, ids[0])
+	assert.NoError(t, err)
+	assert.Equal(t, 0, sess.AccImportance)
+	assert.NotZero(t, sess.ReflectedAt)
+
+	// the failed reflection is undone, unless the session is reflected again
+	at := time.Now()
+	_, err = store.ResetImportance(ctx, ids[1], 0, at)
+	assert.NoError(t, err)
+	assert.NoError(t, store.AddImportance(ctx, ids[1], 2))
+	assert.NoError(t, store.RestoreImportance(ctx, ids[1], 10, 0, at))
+	sess, err = store.Get(ctx, ids[1])
+	assert.NoError(t, err)
+	assert.Equal(t, 12, sess.AccImportance)
+	assert.Zero(t, sess.ReflectedAt)
+
+	_, err = store.ResetImportance(ctx, ids[1], 0, at.Add(time.Second))
+	assert.NoError(t, err)
+	assert.NoError(t, store.RestoreImportance(ctx, ids[1], 10, 0, at))
+	sess, err = store.Get(ctx, ids[1])
+	assert.NoError(t, err)
+	assert.Equal(t, 10, sess.AccImportance)
+	assert.Equal(t, primitive.NewDateTimeFromTime(at.Add(time.Second)), sess.ReflectedAt)
+
+	assert.NoError(t, store.Delete(ctx, ids[0]))
+	assert.ErrorIs(t, store.Delete(ctx, ids[0]), ErrSessionNotFound)
+	_, err = store.Get(ctx, ids[0])
+	assert.ErrorIs(t, err, ErrSessionNotFound)
+}
+
+func TestInMemoryVectorStore(t *testing.T) {
+	ctx := context.TODO()
+	store := NewInMemoryVectorStore()
+
+	err := store.Upsert(ctx, "404", []Memory{{ID: "a"}})
+	assert.ErrorIs(t, err, ErrCollectionNotFound)
+
+	created, err := store.EnsureCollection(ctx, "test", 2)
+	assert.NoError(t, err)
+	assert.True(t, created)
+
+	created, err = store.EnsureCollection(ctx, "test", 2)
+	assert.NoError(t, err)
+	assert.False(t, created)
+
+	err = store.Upsert(ctx, "test", []Memory{{ID: "a", Embedding: []float32{1, 0, 0}}})
+	assert.ErrorIs(t, err, ErrEmbeddingMismatch)
+
+	now := time.Now()
+	err = store.Upsert(ctx, "test", []Memory{
+		{ID: "a", Embedding: []float32{1, 0}, Metadata: MemoryMetadata{Type: BasicMemory, Content: "a", CreatedAt: now}},
+		{ID: "b", Embedding: []float32{0, 1}, Metadata: MemoryMetadata{Type: PlanMemory, Content: "b", CreatedAt: now}},
+		{ID: "c", Embedding: []float32{1, 1}, Metadata: MemoryMetadata{Type: BasicMemory, Content: "c", CreatedAt: now}},
+	})
+	assert.NoError(t, err)
+
+	memories, err := store.Search(ctx, "test", []float32{1, 0.1}, 2, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(memories))
+	assert.Equal(t, "a", memories[0].ID)
+	assert.Equal(t, "c", memories[1].ID)
+	assert.Nil(t, memories[0].Embedding)
+
+	memories, err = store.Search(ctx, "test", []float32{0, 1}, 3, &MemoryFilter{Types: []MemoryType{BasicMemory}})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(memories))
+	assert.Equal(t, "c", memories[0].ID)
+
+	memories, next, err := store.Scroll(ctx, "test", nil, "", 2)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(memories))
+	assert.Equal(t, "c", next)
+
+	memories, next, err = store.Scroll(ctx, "test", nil, next, 2)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(memories))
+	assert.Equal(t, "", next)
+
+	at := now.Add(time.Hour)
+	assert.NoError(t, store.Touch(ctx, "test", []string{"c"}, at))
+	memories, _, err = store.Scroll(ctx, "test", nil, "c", 1)
+	assert.NoError(t, err)
+	assert.Equal(t, at.UnixMilli(), memories[0].Metadata.AccessedAt.UnixMilli())
+
+	memories, err = store.Get(ctx, "test", []string{"b", "x"}, true)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(memories))
+	assert.Equal(t, []float32{0, 1}, memories[0].Embedding)
+
+	assert.ErrorIs(t, store.DeleteByFilter(ctx, "test", &MemoryFilter{}), ErrEmptyFilter)
+	assert.NoError(t, store.DeleteByFilter(ctx, "test", &MemoryFilter{Types: []MemoryType{PlanMemory}}))
+	assert.NoError(t, store.Delete(ctx, "test", []string{"a", "x"}))
+
+	count, err := store.Count(ctx, "test", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(1), count)
+
+	deleted, err := store.DeleteCollection(ctx, "test")
+	assert.NoError(t, err)
+	assert.True(t, deleted)
+}
+
+func TestStubEmbedding(t *testing.T) {
+	a := hashEmbedding("i'm from shanghai.", 1536)
+	b := hashEmbedding("where are you from?", 1536)
+	c := hashEmbedding("i like playing basketball.", 1536)
+
+	assert.Equal(t, a, hashEmbedding("i'm from shanghai.", 1536))
+	assert.InDelta(t, 1, cosine(a, a), 1e-6)
+	assert.Greater(t, cosine(a, b), cosine(c, b))
+	assert.Equal(t, float32(0), cosine(hashEmbedding("", 1536), a))
+}
@@ -0,0 +1,631 @@
+package memo
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	defaultWorkers = 2              // workers of the jobs
+	defaultJobTTL  = 24 * time.Hour // how long the finished jobs are kept, and the unfinished ones without progress
+
+	jobChunkSize     = 100                    // memories ingested at once, the progress is saved after each chunk
+	jobAttempts      = 5                      // how many times to ingest the rate limited memories
+	jobPollInterval  = 1 * time.Second        // how long the redis queue blocks, so the workers can stop
+	jobStaleAfter    = 10 * time.Minute       // a dequeued job without progress is requeued, its worker may be stopped
+	jobReapInterval  = 1 * time.Minute        // how often each server looks for the stale jobs
+	jobKeyPrefix     = "memo:job:"            // the job and its payload in redis
+	jobQueueKey      = "memo:jobs:queue"      // ids of the queued jobs in redis
+	jobProcessingKey = "memo:jobs:processing" // ids of the dequeued jobs in redis, until they are finished
+	jobLeasesKey     = "memo:jobs:leases"     // when the dequeued jobs made progress last time, in unix milliseconds
+)
+
+var (
+	// jobBackoff is the first backoff of the rate limited memories, doubled after each attempt
+	jobBackoff = 2 * time.Second
+	// jobChunkTimeout bounds the ingestion of a chunk with its retries, so a hung call fails the chunk
+	// instead of blocking the worker, it's shorter than jobStaleAfter, so the job is not requeued meanwhile
+	jobChunkTimeout = 5 * time.Minute
+)
+
+type JobState string
+
+const (
+	JobQueued    JobState = "queued"
+	JobRunning   JobState = "running"
+	JobCompleted JobState = "completed" // some of the memories may fail, see the results
+	JobFailed    JobState = "failed"    // none of the memories is added
+)
+
+// Job adds the memories in background, the progress is saved after each chunk
+type Job struct {
+	ID        string            `json:"id"`
+	Owner     string            `json:"owner,omitempty"` // tenant which owns the session
+	Session   string            `json:"session"`
+	State     JobState          `json:"state" enums:"queued,running,completed,failed"`
+	Total     int               `json:"total"`     // number of the memories
+	Processed int               `json:"processed"` // number of the memories which are added or failed
+	Failed    int               `json:"failed"`
+	IDs       []string          `json:"ids"`               // ids of the memories in the same order, assigned when the job is enqueued
+	Counted   int               `json:"-"`                 // number of the first memories whose importance is accumulated, a rerun doesn't count them again
+	Results   []AddMemoryResult `json:"results,omitempty"` // one for each memory in the same order, filled as they are processed
+	Error     string            `json:"error,omitempty"`   // why the job failed
+	CreatedAt time.Time         `json:"created_at"`
+	UpdatedAt time.Time         `json:"updated_at"`
+}
+
+func (j *Job) finished() bool {
+	return j.State == JobCompleted || j.State == JobFailed
+}
+
+// JobQueue stores the jobs with their payloads, and queues them for the workers
+type JobQueue interface {
+	// Enqueue saves the job and its payload, then queues it
+	Enqueue(ctx context.Context, job *Job, req *AddMemoriesRequest) error
+	// Dequeue blocks until a job is queued, or the context is done
+	Dequeue(ctx context.Context) (*Job, *AddMemoriesRequest, error)
+	// Get returns ErrJobNotFound if the job doesn't exist or it's expired
+	Get(ctx context.Context, id string) (*Job, error)
+	// Update saves the progress with the counted memories, the payload is dropped after the job is finished
+	Update(ctx context.Context, job *Job) error
+	Close() error
+}
+
+// @Summary		get a job
+// @Description	the progress of the job which adds the memories in background, with the result of each memory when it's finished
+// @Tags			jobs
+// @Produce		json
+// @Param			id		path		string	true	"the job id"
+// @Success		200		{object}	Job
+// @Failure		default	{object}	APIError
+// @Security		ApiKeyAuth
+// @Router			/jobs/{id} [get]
+func (hs *Handlers) GetJob(c *gin.Context) {
+	if hs.jobs == nil {
+		NewError(c, http.StatusNotFound, ErrJobsDisabled)
+		return
+	}
+
+	// the id is a part of the redis key, so only the job ids are accepted
+	id := c.Param("id")
+	if _, err := uuid.Parse(id); err != nil {
+		NewError(c, http.StatusNotFound, ErrJobNotFound)
+		return
+	}
+
+	job, err := hs.jobs.Get(c.Request.Context(), id)
+	if err == nil && job.Owner != tenant(c) {
+		err = ErrJobNotFound
+	}
+	if err != nil {
+		if errors.Is(err, ErrJobNotFound) {
+			NewError(c, http.StatusNotFound, err)
+			return
+		}
+		NewError(c, http.StatusInternalServerError, err)
+		return
+	}
+	c.JSON(http.StatusOK, job)
+}
+
+// enqueue creates the job of the memories
+func (hs *Handlers) enqueue(ctx context.Context, owner, sid string, req *AddMemoriesRequest) (*Job, error) {
+	if hs.jobs == nil {
+		return nil, ErrJobsDisabled
+	}
+
+	// the ids are assigned before the job is saved, so a requeued job overwrites the memories it already added,
+	// and they are returned with the job
+	ids := make([]string, len(req.Memories))
+	for i := range req.Memories {
+		if req.Memories[i].ID == "" {
+			req.Memories[i].ID = newMemoryID()
+		}
+		ids[i] = req.Memories[i].ID
+	}
+
+	now := time.Now()
+	job := &Job{
+		ID:        uuid.NewString(),
+		Owner:     owner,
+		Session:   sid,
+		State:     JobQueued,
+		Total:     len(req.Memories),
+		IDs:       ids,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	if err := hs.jobs.Enqueue(ctx, job, req); err != nil {
+		return nil, err
+	}
+	return job, nil
+}
+
+// StartWorkers runs the workers until the context is done, Close waits for their running jobs
+func (hs *Handlers) StartWorkers(ctx context.Context) {
+	if hs.jobs == nil {
+		return
+	}
+
+	for i := 0; i < hs.Workers; i++ {
+		hs.workers.Add(1)
+		go func() {
+			defer hs.workers.Done()
+			for {
+				job, req, err := hs.jobs.Dequeue(ctx)
+				if err != nil {
+					if ctx.Err() != nil {
+						return
+					}
+					log.Printf("can't dequeue the job: %v", err)
+					time.Sleep(jobPollInterval)
+					continue
+				}
+
+				// the running job is finished even if the workers are stopped
+				hs.runJob(context.Background(), job, req)
+			}
+		}()
+	}
+}
+
+// runJob ingests the memories chunk by chunk, the rate limited ones are retried with backoff
+func (hs *Handlers) runJob(ctx context.Context, job *Job, req *AddMemoriesRequest) {
+	// a requeued job starts over, the memories keep their ids,
+	// and the importance of the counted ones is not accumulated again
+	job.State = JobRunning
+	job.Processed, job.Failed = 0, 0
+	hs.saveJob(ctx, job)
+
+	job.Results = make([]AddMemoryResult, len(req.Memories))
+	added := 0
+	for start := 0; start < len(req.Memories); start += jobChunkSize {
+		end := start + jobChunkSize
+		if end > len(req.Memories) {
+			end = len(req.Memories)
+		}
+		chunk := req.Memories[start:end]
+
+		chunkCtx, cancel := context.WithTimeout(ctx, jobChunkTimeout)
+		errs := hs.ingestWithRetry(chunkCtx, job.Session, chunk, req.SkipScoring)
+		cancel()
+		var uncounted []Memory
+		for i, err := range errs {
+			r := &job.Results[start+i]
+			if err != nil {
+				r.Error = err.Error()
+				job.Failed++
+				continue
+			}
+			r.ID = chunk[i].ID
+			added++
+			if start+i >= job.Counted {
+				uncounted = append(uncounted, chunk[i])
+			}
+		}
+
+		// the counted memories are saved right after their importance is accumulated
+		if end > job.Counted {
+			hs.accumulateImportance(ctx, job.Session, uncounted)
+			job.Counted = end
+		}
+		job.Processed = end
+		if end < len(req.Memories) {
+			hs.saveJob(ctx, job)
+		}
+	}
+
+	job.State = JobCompleted
+	if added == 0 && job.Total > 0 {
+		job.State = JobFailed
+		job.Error = "none of the memories is added"
+	}
+	hs.saveJob(ctx, job)
+}
+
+// ingestWithRetry ingests the memories, then ingests the rate limited ones again after the backoff,
+// either their importance or embeddings are rate limited, the ids and scored importance are kept,
+// so only the missing importance is scored again
+func (hs *Handlers) ingestWithRetry(ctx context.Context, sid string, memories []Memory, skipScoring bool) []error {
+	_, errs := hs.ingest(ctx, sid, memories, skipScoring)
+
+	backoff := jobBackoff
+	for attempt := 1; attempt < jobAttempts; attempt++ {
+		var limited []int
+		for i, err := range errs {
+			if err != nil && isRateLimited(err) {
+				limited = append(limited, i)
+			}
+		}
+		if len(limited) == 0 {
+			break
+		}
+
+		log.Printf("%d memories are rate limited (attempt %d/%d), retry in %v", len(limited), attempt, jobAttempts, backoff)
+		select {
+		case <-ctx.Done():
+			return errs
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+		if backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+
+		retrying := make([]Memory, len(limited))
+		for j, i := range limited {
+			retrying[j] = memories[i]
+		}
+		_, retried := hs.ingest(ctx, sid, retrying, skipScoring)
+		for j, i := range limited {
+			memories[i] = retrying[j]
+			errs[i] = retried[j]
+		}
+	}
+	return errs
+}
+
+func (hs *Handlers) saveJob(ctx context.Context, job *Job) {
+	job.UpdatedAt = time.Now()
+	if err := hs.jobs.Update(ctx, job); err != nil {
+		log.Printf("can't save job %s: %v", job.ID, err)
+	}
+}
+
+// InMemoryJobQueue is the queue in the process for testing, the jobs are lost when it stops, so it can't be configured
+type InMemoryJobQueue struct {
+	ttl time.Duration
+
+	mu       sync.Mutex
+	jobs     map[string]*Job
+	payloads map[string]*AddMemoriesRequest
+	pending  []string
+	signal   chan struct{} // notifies a waiting worker
+}
+
+func NewInMemoryJobQueue(ttl time.Duration) *InMemoryJobQueue {
+	if ttl <= 0 {
+		ttl = defaultJobTTL
+	}
+	return &InMemoryJobQueue{
+		ttl:      ttl,
+		jobs:     map[string]*Job{},
+		payloads: map[string]*AddMemoriesRequest{},
+		signal:   make(chan struct{}, 1),
+	}
+}
+
+func (q *InMemoryJobQueue) Enqueue(ctx context.Context, job *Job, req *AddMemoriesRequest) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	// drop the expired jobs
+	for id, j := range q.jobs {
+		if j.finished() && time.Since(j.UpdatedAt) > q.ttl {
+			delete(q.jobs, id)
+		}
+	}
+
+	j := *job
+	q.jobs[job.ID] = &j
+	q.payloads[job.ID] = req
+	q.pending = append(q.pending, job.ID)
+
+	select {
+	case q.signal <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+func (q *InMemoryJobQueue) Dequeue(ctx context.Context) (*Job, *AddMemoriesRequest, error) {
+	for {
+		q.mu.Lock()
+		if len(q.pending) > 0 {
+			id := q.pending[0]
+			q.pending = q.pending[1:]
+			job := *q.jobs[id]
+			req := q.payloads[id]
+
+			// wake up another worker if there are more
+			if len(q.pending) > 0 {
+				select {
+				case q.signal <- struct{}{}:
+				default:
+				}
+			}
+			q.mu.Unlock()
+			return &job, req, nil
+		}
+		q.mu.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return nil, nil, ctx.Err()
+		case <-q.signal:
+		}
+	}
+}
+
+func (q *InMemoryJobQueue) Get(ctx context.Context, id string) (*Job, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	j, ok := q.jobs[id]
+	if !ok || (j.finished() && time.Since(j.UpdatedAt) > q.ttl) {
+		return nil, ErrJobNotFound
+	}
+
+	job := *j
+	job.Results = append([]AddMemoryResult(nil), j.Results...)
+	return &job, nil
+}
+
+func (q *InMemoryJobQueue) Update(ctx context.Context, job *Job) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if _, ok := q.jobs[job.ID]; !ok {
+		return ErrJobNotFound
+	}
+
+	j := *job
+	j.Results = append([]AddMemoryResult(nil), job.Results...)
+	q.jobs[job.ID] = &j
+	if job.finished() {
+		delete(q.payloads, job.ID)
+	}
+	return nil
+}
+
+func (q *InMemoryJobQueue) Close() error {
+	return nil
+}
+
+// RedisJobQueue shares the jobs between the servers, the dequeued jobs are kept in a processing list until they are finished,
+// and requeued if they make no progress for a while, e.g. their workers are stopped, so a job may run more than once
+type RedisJobQueue struct {
+	client redis.UniversalClient
+	ttl    time.Duration
+
+	mu     sync.Mutex
+	reaped time.Time // when the stale jobs were requeued last time
+}
+
+// requeueScript moves the job from the processing list back to the queue, unless it's finished or requeued by another server
+var requeueScript = redis.NewScript(`
+if redis.call("LREM", KEYS[1], 1, ARGV[1]) == 1 then
+	redis.call("RPUSH", KEYS[2], ARGV[1])
+	redis.call("HDEL", KEYS[3], ARGV[1])
+	return 1
+end
+return 0
+`)
+
+func NewRedisJobQueue(client redis.UniversalClient, ttl time.Duration) *RedisJobQueue {
+	if ttl <= 0 {
+		ttl = defaultJobTTL
+	}
+	return &RedisJobQueue{client: client, ttl: ttl}
+}
+
+// Enqueue saves the job and its payload with the ttl, so they don't leak if the job is never finished
+func (q *RedisJobQueue) Enqueue(ctx context.Context, job *Job, req *AddMemoriesRequest) error {
+	b, err := json.Marshal(job)
+	if err != nil {
+		return err
+	}
+	payload, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+
+	_, err = q.client.TxPipelined(ctx, func(p redis.Pipeliner) error {
+		p.Set(ctx, jobKeyPrefix+job.ID, b, q.ttl)
+		p.Set(ctx, jobKeyPrefix+job.ID+":payload", payload, q.ttl)
+		p.LPush(ctx, jobQueueKey, job.ID)
+		return nil
+	})
+	return err
+}
+
+// Dequeue moves the job to the processing list, the stale jobs are requeued first if it's time to
+func (q *RedisJobQueue) Dequeue(ctx context.Context) (*Job, *AddMemoriesRequest, error) {
+	for {
+		q.reapIfDue(ctx)
+
+		id, err := q.client.BLMove(ctx, jobQueueKey, jobProcessingKey, "RIGHT", "LEFT", jobPollInterval).Result()
+		if errors.Is(err, redis.Nil) {
+			if ctx.Err() != nil {
+				return nil, nil, ctx.Err()
+			}
+			continue
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		if err := q.client.HSet(ctx, jobLeasesKey, id, time.Now().UnixMilli()).Err(); err != nil {
+			return nil, nil, fmt.Errorf("lease of job %s: %w", id, err)
+		}
+
+		job, req, err := q.load(ctx, id)
+		if errors.Is(err, ErrJobNotFound) || (err == nil && job.finished()) {
+			// it's expired, or finished by the worker which was thought to be stopped
+			if err := q.release(ctx, id); err != nil {
+				return nil, nil, fmt.Errorf("job %s: %w", id, err)
+			}
+			continue
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("job %s: %w", id, err)
+		}
+		return job, req, nil
+	}
+}
+
+// load gets the job with its payload and counted memories, ErrJobNotFound if either is expired or dropped
+func (q *RedisJobQueue) load(ctx context.Context, id string) (*Job, *AddMemoriesRequest, error) {
+	job, err := q.Get(ctx, id)
+	if err != nil {
+		return nil, nil, err
+	}
+	if job.finished() {
+		return job, nil, nil
+	}
+
+	b, err := q.client.Get(ctx, jobKeyPrefix+id+":payload").Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, nil, ErrJobNotFound
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var req AddMemoriesRequest
+	if err := json.Unmarshal(b, &req); err != nil {
+		return nil, nil, fmt.Errorf("payload: %w", err)
+	}
+
+	// the counted memories are kept out of the job, which is returned by the api
+	counted, err := q.client.Get(ctx, jobKeyPrefix+id+":counted").Int()
+	if err != nil && !errors.Is(err, redis.Nil) {
+		return nil, nil, fmt.Errorf("counted: %w", err)
+	}
+	job.Counted = counted
+	return job, &req, nil
+}
+
+// release removes the job from the processing list with its lease
+func (q *RedisJobQueue) release(ctx context.Context, id string) error {
+	_, err := q.client.TxPipelined(ctx, func(p redis.Pipeliner) error {
+		p.LRem(ctx, jobProcessingKey, 1, id)
+		p.HDel(ctx, jobLeasesKey, id)
+		return nil
+	})
+	return err
+}
+
+// reapIfDue requeues the stale jobs at most once in the interval, the errors are only logged,
+// since they are requeued next time
+func (q *RedisJobQueue) reapIfDue(ctx context.Context) {
+	q.mu.Lock()
+	due := time.Since(q.reaped) >= jobReapInterval
+	if due {
+		q.reaped = time.Now()
+	}
+	q.mu.Unlock()
+
+	if due {
+		if err := q.reap(ctx); err != nil && ctx.Err() == nil {
+			log.Printf("can't requeue the stale jobs: %v", err)
+		}
+	}
+}
+
+// reap requeues the processing jobs whose leases are not renewed in jobStaleAfter,
+// the lease of a job is renewed when it's dequeued and its progress is saved
+func (q *RedisJobQueue) reap(ctx context.Context) error {
+	ids, err := q.client.LRange(ctx, jobProcessingKey, 0, -1).Result()
+	if err != nil {
+		return err
+	}
+	leases, err := q.client.HGetAll(ctx, jobLeasesKey).Result()
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	for _, id := range ids {
+		lease, ok := leases[id]
+		if !ok {
+			// it's dequeued just now, or its worker stopped before the lease was set, start the lease from now
+			if err := q.client.HSetNX(ctx, jobLeasesKey, id, now.UnixMilli()).Err(); err != nil {
+				return err
+			}
+			continue
+		}
+		if at, err := strconv.ParseInt(lease, 10, 64); err == nil && now.Sub(time.UnixMilli(at)) < jobStaleAfter {
+			continue
+		}
+
+		requeued, err := requeueScript.Run(ctx, q.client, []string{jobProcessingKey, jobQueueKey, jobLeasesKey}, id).Int()
+		if err != nil {
+			return err
+		}
+		if requeued == 1 {
+			log.Printf("job %s is stale, requeue it", id)
+		}
+	}
+	return nil
+}
+
+func (q *RedisJobQueue) Get(ctx context.Context, id string) (*Job, error) {
+	b, err := q.client.Get(ctx, jobKeyPrefix+id).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, ErrJobNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var job Job
+	if err := json.Unmarshal(b, &job); err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+// Update saves the job, and renews its payload, counted memories and lease if it's unfinished,
+// or releases it from the processing list if it's finished, then it's kept for the ttl
+func (q *RedisJobQueue) Update(ctx context.Context, job *Job) error {
+	b, err := json.Marshal(job)
+	if err != nil {
+		return err
+	}
+
+	_, err = q.client.TxPipelined(ctx, func(p redis.Pipeliner) error {
+		p.Set(ctx, jobKeyPrefix+job.ID, b, q.ttl)
+		if !job.finished() {
+			p.Expire(ctx, jobKeyPrefix+job.ID+":payload", q.ttl)
+			p.Set(ctx, jobKeyPrefix+job.ID+":counted", job.Counted, q.ttl)
+			p.HSet(ctx, jobLeasesKey, job.ID, time.Now().UnixMilli())
+			return nil
+		}
+		p.Del(ctx, jobKeyPrefix+job.ID+":payload", jobKeyPrefix+job.ID+":counted")
+		p.LRem(ctx, jobProcessingKey, 1, job.ID)
+		p.HDel(ctx, jobLeasesKey, job.ID)
+		return nil
+	})
+	return err
+}
+
+func (q *RedisJobQueue) Close() error {
+	return q.client.Close()
+}
+
+// NewJobQueue creates the queue of the configured backend, nil if it's disabled
+func NewJobQueue(cfg JobsConfig) (JobQueue, error) {
+	switch cfg.Backend {
+	case "", "none":
+		return nil, nil
+	case "redis":
+		opts, err := redis.ParseURL(cfg.RedisURL)
+		if err != nil {
+			return nil, fmt.Errorf("invalid redis url: %w", err)
+		}
+		return NewRedisJobQueue(redis.NewClient(opts), cfg.TTL), nil
+	default:
+		return nil, fmt.Errorf("unknown job queue: %q", cfg.Backend)
+	}
+}
@@ -0,0 +1,272 @@
+package memo
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	openai "github.com/sashabaranov/go-openai"
+	"github.com/stretchr/testify/assert"
+)
+
+// rateLimitedEmbedder rejects the first requests by the rate limit
+type rateLimitedEmbedder struct {
+	Embedder
+
+	mu       sync.Mutex
+	rejected int
+	limit    int
+}
+
+func (e *rateLimitedEmbedder) Embed(ctx context.Context, inputs []string) ([][]float32, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.rejected < e.limit {
+		e.rejected++
+		return nil, &openai.APIError{HTTPStatusCode: http.StatusTooManyRequests, Message: "rate limit reached"}
+	}
+	return e.Embedder.Embed(ctx, inputs)
+}
+
+// hangingEmbedder blocks until the context is done
+type hangingEmbedder struct {
+	Embedder
+}
+
+func (e *hangingEmbedder) Embed(ctx context.Context, inputs []string) ([][]float32, error) {
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+// rateLimitedLLM rejects the first completions by the rate limit
+type rateLimitedLLM struct {
+	LLM
+
+	mu    sync.Mutex
+	calls int
+	limit int
+}
+
+func (l *rateLimitedLLM) Complete(ctx context.Context, model string, messages []Message) (string, error) {
+	l.mu.Lock()
+	l.calls++
+	rejected := l.calls <= l.limit
+	l.mu.Unlock()
+
+	if rejected {
+		return "", &openai.APIError{HTTPStatusCode: http.StatusTooManyRequests, Message: "rate limit reached"}
+	}
+	return l.LLM.Complete(ctx, model, messages)
+}
+
+func TestAsyncAddMemories(t *testing.T) {
+	backoff := jobBackoff
+	jobBackoff = time.Millisecond
+	defer func() { jobBackoff = backoff }()
+
+	hs := newTestHandlers(t)
+	hs.APIKeys = map[string]string{"key-a": "a", "key-b": "b"}
+	hs.AuthDisabled = false
+	embedder := &rateLimitedEmbedder{Embedder: hs.embedder, limit: 2}
+	hs.embedder = embedder
+
+	router := newTestRouter(hs)
+
+	ctx, stop := context.WithCancel(context.Background())
+	hs.StartWorkers(ctx)
+
+	id, err := hs.sessions.Create(context.TODO(), &Session{Name: "aspirin", Owner: "a"})
+	assert.NoError(t, err)
+	_, err = hs.vectors.EnsureCollection(context.TODO(), id.Hex(), hs.embedder.Dimension())
+	assert.NoError(t, err)
+
+	body := `{"async":true, "memories":[{"metadata":{"content":"aspirin likes swimming."}}, {"metadata":{"content":"aspirin is a boy."}}]}`
+	w := serveAs(router, "key-a", "POST", "/m/"+id.Hex()+"/add", strings.NewReader(body))
+	assert.Equal(t, http.StatusAccepted, w.Code)
+
+	var job Job
+	assert.NoError(t, json.NewDecoder(w.Body).Decode(&job))
+	assert.Equal(t, JobQueued, job.State)
+	assert.Equal(t, 2, job.Total)
+	ids := job.IDs
+	assert.Equal(t, 2, len(ids))
+
+	// the rate limited memories are retried
+	assert.Eventually(t, func() bool {
+		w := serveAs(router, "key-a", "GET", "/jobs/"+job.ID, nil)
+		assert.Equal(t, 200, w.Code)
+		assert.NotContains(t, w.Body.String(), `"counted"`)
+		job = Job{}
+		assert.NoError(t, json.NewDecoder(w.Body).Decode(&job))
+		return job.State == JobCompleted
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.Equal(t, 2, job.Processed)
+	assert.Zero(t, job.Failed)
+	assert.Equal(t, 2, len(job.Results))
+	// the memories are added with the ids returned at first
+	for i, r := range job.Results {
+		assert.Equal(t, ids[i], r.ID)
+	}
+	assert.Equal(t, 2, embedder.rejected)
+
+	count, err := hs.vectors.Count(context.TODO(), id.Hex(), nil)
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(2), count)
+
+	// the jobs of other tenants are not found
+	assert.Equal(t, 404, serveAs(router, "key-b", "GET", "/jobs/"+job.ID, nil).Code)
+	assert.Equal(t, 404, serveAs(router, "key-a", "GET", "/jobs/unknown", nil).Code)
+	assert.Equal(t, 404, serveAs(router, "key-a", "GET", "/jobs/"+job.ID+":payload", nil).Code)
+
+	// the workers are stopped, then the handlers are closed
+	stop()
+	closeCtx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	assert.NoError(t, hs.Close(closeCtx))
+	assert.NoError(t, closeCtx.Err())
+}
+
+func TestJobScoringRateLimited(t *testing.T) {
+	backoff := jobBackoff
+	jobBackoff = time.Millisecond
+	defer func() { jobBackoff = backoff }()
+
+	hs := newTestHandlers(t)
+	expected, err := hs.llm.ScoreMemories(context.TODO(), hs.prompts.ScoreImportance, []string{"aspirin likes swimming."})
+	assert.NoError(t, err)
+	provider := &rateLimitedLLM{LLM: hs.llm.provider, limit: 2}
+	hs.llm.provider = provider
+
+	id, err := hs.sessions.Create(context.TODO(), &Session{Name: "aspirin"})
+	assert.NoError(t, err)
+	_, err = hs.vectors.EnsureCollection(context.TODO(), id.Hex(), hs.embedder.Dimension())
+	assert.NoError(t, err)
+
+	job, err := hs.enqueue(context.TODO(), "", id.Hex(), &AddMemoriesRequest{
+		Memories: []Memory{{Metadata: MemoryMetadata{Content: "aspirin likes swimming."}}},
+	})
+	assert.NoError(t, err)
+	queued, req, err := hs.jobs.Dequeue(context.TODO())
+	assert.NoError(t, err)
+	hs.runJob(context.TODO(), queued, req)
+
+	// the scoring is retried, instead of falling back to the default importance
+	job, err = hs.jobs.Get(context.TODO(), job.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, JobCompleted, job.State)
+	assert.Zero(t, job.Failed)
+	assert.Equal(t, 3, provider.calls)
+
+	memories, err := hs.vectors.Get(context.TODO(), id.Hex(), []string{job.Results[0].ID}, false)
+	assert.NoError(t, err)
+	assert.Equal(t, expected[0], memories[0].Metadata.Importance)
+}
+
+func TestJobRerun(t *testing.T) {
+	hs := newTestHandlers(t)
+	// accumulate the importance without reflecting
+	hs.ReflectThreshold = 1000
+
+	id, err := hs.sessions.Create(context.TODO(), &Session{Name: "aspirin"})
+	assert.NoError(t, err)
+	_, err = hs.vectors.EnsureCollection(context.TODO(), id.Hex(), hs.embedder.Dimension())
+	assert.NoError(t, err)
+
+	memories := make([]Memory, jobChunkSize+1)
+	for i := range memories {
+		memories[i].Metadata = MemoryMetadata{Content: fmt.Sprintf("memory %d", i), Importance: 2}
+	}
+	job, err := hs.enqueue(context.TODO(), "", id.Hex(), &AddMemoriesRequest{Memories: memories})
+	assert.NoError(t, err)
+
+	queued, req, err := hs.jobs.Dequeue(context.TODO())
+	assert.NoError(t, err)
+	// the payload as it's saved, a requeued job is loaded from it again
+	payload := *req
+	payload.Memories = append([]Memory(nil), req.Memories...)
+	hs.runJob(context.TODO(), queued, req)
+
+	count, err := hs.vectors.Count(context.TODO(), id.Hex(), nil)
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(len(memories)), count)
+	sess, err := hs.sessions.Get(context.TODO(), id)
+	assert.NoError(t, err)
+	assert.Equal(t, 2*len(memories), sess.AccImportance)
+
+	// the worker stops before the job is finished, then the job is requeued and runs again
+	rerun, err := hs.jobs.Get(context.TODO(), job.ID)
+	assert.NoError(t, err)
+	rerun.State = JobRunning
+	hs.runJob(context.TODO(), rerun, &payload)
+
+	rerun, err = hs.jobs.Get(context.TODO(), job.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, JobCompleted, rerun.State)
+	assert.Equal(t, queued.Results, rerun.Results)
+
+	count, err = hs.vectors.Count(context.TODO(), id.Hex(), nil)
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(len(memories)), count)
+	sess, err = hs.sessions.Get(context.TODO(), id)
+	assert.NoError(t, err)
+	assert.Equal(t, 2*len(memories), sess.AccImportance)
+}
+
+func TestJobFailed(t *testing.T) {
+	hs := newTestHandlers(t)
+
+	// the collection doesn't exist
+	job, err := hs.enqueue(context.TODO(), "", "missing", &AddMemoriesRequest{
+		SkipScoring: true,
+		Memories:    []Memory{{Metadata: MemoryMetadata{Content: "hello"}}},
+	})
+	assert.NoError(t, err)
+
+	queued, req, err := hs.jobs.Dequeue(context.TODO())
+	assert.NoError(t, err)
+	assert.Equal(t, job.ID, queued.ID)
+	hs.runJob(context.TODO(), queued, req)
+
+	job, err = hs.jobs.Get(context.TODO(), job.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, JobFailed, job.State)
+	assert.Equal(t, 1, job.Failed)
+	assert.Equal(t, ErrQdrantUpsert.Error(), job.Results[0].Error)
+
+	// the chunk fails if it's not ingested in time
+	timeout := jobChunkTimeout
+	jobChunkTimeout = 10 * time.Millisecond
+	defer func() { jobChunkTimeout = timeout }()
+	hs.embedder = &hangingEmbedder{Embedder: hs.embedder}
+
+	id, err := hs.sessions.Create(context.TODO(), &Session{Name: "aspirin"})
+	assert.NoError(t, err)
+	_, err = hs.vectors.EnsureCollection(context.TODO(), id.Hex(), hs.embedder.Dimension())
+	assert.NoError(t, err)
+	job, err = hs.enqueue(context.TODO(), "", id.Hex(), &AddMemoriesRequest{
+		SkipScoring: true,
+		Memories:    []Memory{{Metadata: MemoryMetadata{Content: "hello"}}},
+	})
+	assert.NoError(t, err)
+	queued, req, err = hs.jobs.Dequeue(context.TODO())
+	assert.NoError(t, err)
+	hs.runJob(context.TODO(), queued, req)
+
+	job, err = hs.jobs.Get(context.TODO(), job.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, JobFailed, job.State)
+	assert.Equal(t, 1, job.Failed)
+
+	assert.True(t, isRateLimited(&embedError{cause: fmt.Errorf("embedding: %w", ErrRateLimited)}))
+	assert.False(t, isRateLimited(ErrOpenAIEmbedding))
+
+	hs.jobs = nil
+	_, err = hs.enqueue(context.TODO(), "", "missing", &AddMemoriesRequest{})
+	assert.ErrorIs(t, err, ErrJobsDisabled)
+}
@@ -0,0 +1,82 @@
+package memo
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	openai "github.com/sashabaranov/go-openai"
+)
+
+// Message is one message of a chat, the prompts are written in it, so they don't depend on the provider
+type Message struct {
+	Role    string `toml:"role" json:"role"` // system, user or assistant
+	Content string `toml:"content" json:"content"`
+}
+
+const (
+	RoleSystem    = "system"
+	RoleUser      = "user"
+	RoleAssistant = "assistant"
+)
+
+// LLM completes the chats, it's implemented for openai, the openai compatible servers, azure openai and anthropic
+type LLM interface {
+	// Complete returns the reply to the messages by the model, the default model of the provider is used if it's empty
+	Complete(ctx context.Context, model string, messages []Message) (string, error)
+	// Ping checks whether the provider is reachable and the key is valid
+	Ping(ctx context.Context) error
+}
+
+// Function is what the model is asked to call, so the reply is structured by the schema of its arguments
+type Function struct {
+	Name        string
+	Description string
+	Parameters  json.RawMessage // json schema of the arguments
+}
+
+// FunctionCaller is implemented by the llm which supports function calling (or tool use)
+type FunctionCaller interface {
+	// CompleteFunction forces the model to call the function, and returns the arguments in json,
+	// or the text reply if the model doesn't call it
+	CompleteFunction(ctx context.Context, model string, messages []Message, fn Function) (string, error)
+}
+
+// Task is what the llm is used for, each task can be done by its own model
+type Task string
+
+const (
+	TaskImportance    Task = "importance"    // score the importance of memories
+	TaskReflection    Task = "reflection"    // ask questions about the memories, and draw insights from them
+	TaskPlanning      Task = "planning"      // plan the day and decompose it
+	TaskSummarization Task = "summarization" // summarize the memories into the agent's recent status for planning
+)
+
+// NewLLM creates the llm of the configured provider,
+// the openai provider uses the given client unless the llm has its own api key
+func NewLLM(cfg LLMConfig, client OpenAIClient) (LLM, error) {
+	switch cfg.Provider {
+	case "", "openai":
+		if cfg.APIKey != "" {
+			client = openai.NewClient(cfg.APIKey)
+		}
+		return NewOpenAILLM(client, cfg.Model), nil
+	case "compatible":
+		c := openai.DefaultConfig(cfg.APIKey)
+		c.BaseURL = strings.TrimRight(cfg.BaseURL, "/")
+		return NewOpenAILLM(openai.NewClientWithConfig(c), cfg.Model), nil
+	case "azure":
+		c := openai.DefaultAzureConfig(cfg.APIKey, cfg.BaseURL)
+		if cfg.APIVersion != "" {
+			c.APIVersion = cfg.APIVersion
+		}
+		// the models are the names of the deployments
+		c.AzureModelMapperFunc = func(model string) string { return model }
+		return NewOpenAILLM(openai.NewClientWithConfig(c), cfg.Model), nil
+	case "anthropic":
+		return NewAnthropicLLM(cfg.BaseURL, cfg.APIKey, cfg.Model, cfg.MaxTokens), nil
+	default:
+		return nil, fmt.Errorf("unknown llm provider: %q", cfg.Provider)
+	}
+}
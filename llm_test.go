@@ -0,0 +1,163 @@
+package memo
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	openai "github.com/sashabaranov/go-openai"
+	"github.com/stretchr/testify/assert"
+)
+
+// chatServer replies the openai chat completions with the requested model
+func chatServer(t *testing.T, check func(r *http.Request)) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		check(r)
+
+		var req openai.ChatCompletionRequest
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+		json.NewEncoder(w).Encode(openai.ChatCompletionResponse{
+			Model: req.Model,
+			Choices: []openai.ChatCompletionChoice{{
+				Message: openai.ChatCompletionMessage{Role: RoleAssistant, Content: "reply from " + req.Model},
+			}},
+		})
+	}))
+}
+
+func TestCompatibleLLM(t *testing.T) {
+	srv := chatServer(t, func(r *http.Request) {
+		assert.Equal(t, "/v1/chat/completions", r.URL.Path)
+		assert.Equal(t, "Bearer key", r.Header.Get("Authorization"))
+	})
+	defer srv.Close()
+
+	l, err := NewLLM(LLMConfig{Provider: "compatible", BaseURL: srv.URL + "/v1/", APIKey: "key", Model: "llama2"}, nil)
+	assert.NoError(t, err)
+
+	// each task uses its own model, or the default one
+	tasks := &llm{provider: l, models: TaskModels{Reflection: "mistral"}}
+	reply, err := tasks.complete(context.TODO(), TaskReflection, nil, "hello")
+	assert.NoError(t, err)
+	assert.Equal(t, "reply from mistral", reply)
+
+	reply, err = tasks.complete(context.TODO(), TaskPlanning, nil, "hello")
+	assert.NoError(t, err)
+	assert.Equal(t, "reply from llama2", reply)
+
+	tasks.models.Summarization = "phi"
+	reply, err = tasks.complete(context.TODO(), TaskSummarization, nil, "hello")
+	assert.NoError(t, err)
+	assert.Equal(t, "reply from phi", reply)
+}
+
+func TestAzureLLM(t *testing.T) {
+	srv := chatServer(t, func(r *http.Request) {
+		// the model is the deployment
+		assert.Equal(t, "/openai/deployments/gpt-35.prod/chat/completions", r.URL.Path)
+		assert.Equal(t, "2023-07-01-preview", r.URL.Query().Get("api-version"))
+		assert.Equal(t, "key", r.Header.Get("api-key"))
+	})
+	defer srv.Close()
+
+	l, err := NewLLM(LLMConfig{Provider: "azure", BaseURL: srv.URL, APIKey: "key", APIVersion: "2023-07-01-preview", Model: "gpt-35.prod"}, nil)
+	assert.NoError(t, err)
+
+	reply, err := l.Complete(context.TODO(), "", []Message{{Role: RoleUser, Content: "hello"}})
+	assert.NoError(t, err)
+	assert.Equal(t, "reply from gpt-35.prod", reply)
+}
+
+func TestAnthropicLLM(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, anthropicVersion, r.Header.Get("anthropic-version"))
+		if r.Header.Get("x-api-key") != "key" {
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte(`{"type":"error","error":{"type":"authentication_error","message":"invalid x-api-key"}}`))
+			return
+		}
+
+		switch r.URL.Path {
+		case "/v1/models":
+			w.Write([]byte(`{"data":[]}`))
+		case "/v1/messages":
+			var req anthropicRequest
+			assert.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+			if req.ToolChoice != nil {
+				assert.Equal(t, scoreFunction.Name, req.ToolChoice.Name)
+				assert.Equal(t, scoreFunction.Name, req.Tools[0].Name)
+				w.Write([]byte(`{"content":[{"type":"tool_use","name":"score_memories","input":{"scores":[{"index":1,"score":6}]}}]}`))
+				return
+			}
+			assert.Equal(t, "claude", req.Model)
+			assert.Equal(t, 100, req.MaxTokens)
+			assert.Equal(t, "you are a scorer\n\nbe brief", req.System)
+			// the roles alternate
+			assert.Equal(t, []Message{
+				{Role: RoleUser, Content: "example"},
+				{Role: RoleAssistant, Content: "1"},
+				{Role: RoleUser, Content: "hello\n\nworld"},
+			}, req.Messages)
+			w.Write([]byte(`{"content":[{"type":"text","text":"3, "},{"type":"text","text":"4"}]}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+
+	l, err := NewLLM(LLMConfig{Provider: "anthropic", BaseURL: srv.URL, APIKey: "key", Model: "claude", MaxTokens: 100}, nil)
+	assert.NoError(t, err)
+	assert.NoError(t, l.Ping(context.TODO()))
+
+	reply, err := l.Complete(context.TODO(), "", []Message{
+		{Role: RoleSystem, Content: "you are a scorer"},
+		{Role: RoleSystem, Content: "be brief"},
+		{Role: RoleUser, Content: "example"},
+		{Role: RoleAssistant, Content: "1"},
+		{Role: RoleUser, Content: "hello"},
+		{Role: RoleUser, Content: "world"},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "3, 4", reply)
+
+	tasks := &llm{provider: l, functions: true}
+	scores, err := tasks.ScoreMemories(context.TODO(), nil, []string{"hello"})
+	assert.NoError(t, err)
+	assert.Equal(t, []int{6}, scores)
+
+	l = NewAnthropicLLM(srv.URL, "wrong", "claude", 0)
+	assert.ErrorContains(t, l.Ping(context.TODO()), "invalid x-api-key")
+	_, err = l.Complete(context.TODO(), "", []Message{{Role: RoleUser, Content: "hello"}})
+	assert.ErrorContains(t, err, "status 401")
+}
+
+func TestNewLLM(t *testing.T) {
+	l, err := NewLLM(LLMConfig{Provider: "openai"}, NewStubClient())
+	assert.NoError(t, err)
+	assert.Equal(t, openai.GPT3Dot5Turbo, l.(*OpenAILLM).model)
+	assert.NoError(t, l.Ping(context.TODO()))
+
+	_, err = NewLLM(LLMConfig{Provider: "unknown"}, nil)
+	assert.Error(t, err)
+
+	// the openai api key is only needed by openai
+	cfg := DefaultConfig()
+	cfg.Auth.Disabled = true
+	cfg.LLM = LLMConfig{Provider: "anthropic", APIKey: "key", Model: "claude", MaxTokens: 1024}
+	cfg.Embedding.Provider = "compatible"
+	cfg.Embedding.BaseURL = "http://localhost:11434/v1"
+	cfg.Embedding.Model = "nomic-embed-text"
+	assert.NoError(t, cfg.Validate())
+
+	cfg.LLM = LLMConfig{Provider: "azure"}
+	err = cfg.Validate()
+	for _, field := range []string{"llm.base_url", "llm.model", "llm.api_key"} {
+		assert.ErrorContains(t, err, field)
+	}
+	assert.NotErrorIs(t, err, ErrInvalidOpenAPIKey)
+
+	cfg.LLM = LLMConfig{Provider: "openai"}
+	assert.ErrorIs(t, cfg.Validate(), ErrInvalidOpenAPIKey)
+}
@@ -1,67 +1,188 @@
 package memo
 
 import (
-	"fmt"
-	"os"
+	"context"
+	"errors"
+	"io"
+	"log"
+	"sync"
 
-	"github.com/BurntSushi/toml"
-	pb "github.com/qdrant/go-client/qdrant"
 	openai "github.com/sashabaranov/go-openai"
-	mongo "go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo"
+	"google.golang.org/grpc"
 )
 
 type Handlers struct {
-	sessions *mongo.Collection // mongodb collection for sessions
+	sessions SessionStore // stores for sessions
+	vectors  VectorStore  // stores for memories
 
-	qCollections pb.CollectionsClient // qdrant collection for memories
-	qPoints      pb.PointsClient      // qdrant points for memories
+	llm      *llm     // does the tasks with the llm provider
+	embedder Embedder // embeds the memories and queries
+	jobs     JobQueue // jobs of adding memories in background, nil if it's disabled
 
-	llm *llm // openai wrapper
+	APIKeys          map[string]string // api key to tenant
+	AuthDisabled     bool              // all requests belong to the default tenant "", don't expose the server
+	SearchLimit      int64             // search limit per page
+	ReflectThreshold int               // accumulated importance to trigger a reflection, 0 to disable
+	ImportLimit      int64             // max bytes of an imported session, both compressed and decompressed
+	Batch            BatchConfig       // limits of embedding and upserting the memories
+	Workers          int               // workers of the jobs, started by StartWorkers
+	prompts          promptsConfig     // prompts config
 
-	SearchLimit int64         // search limit per page
-	prompts     promptsConfig // prompts config
+	background sync.WaitGroup // running background reflections
+	workers    sync.WaitGroup // running workers of the jobs
 }
 
-func Default() *Handlers {
-	sessions, err := SetupMongo()
+// NewHandlers creates the handlers with the given stores, llm and embedder, using the embedded prompts
+func NewHandlers(sessions SessionStore, vectors VectorStore, provider LLM, embedder Embedder) (*Handlers, error) {
+	prompts, err := loadPrompts("")
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
 
-	collection, points, err := SetupQdrant()
+	hs := &Handlers{
+		sessions:    sessions,
+		vectors:     vectors,
+		llm:         &llm{provider: provider, functions: true},
+		embedder:    embedder,
+		prompts:     prompts,
+		SearchLimit: 5,
+
+		ReflectThreshold: defaultReflectThreshold,
+		ImportLimit:      defaultImportLimit,
+		Batch:            defaultBatchConfig(),
+		Workers:          defaultWorkers,
+	}
+
+	return hs, nil
+}
+
+// Default creates the handlers configured by environment variables, see LoadConfig
+func Default(ctx context.Context) (*Handlers, error) {
+	cfg, err := LoadConfig(nil)
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
+	return New(ctx, cfg)
+}
 
-	key := os.Getenv("OPENAI_API_KEY")
-	if key == "" {
-		panic(ErrInvalidOpenAPIKey)
+// New creates the handlers with mongodb, qdrant, the llm and embedder by the config,
+// the databases are retried with backoff, since they may start later than the server
+func New(ctx context.Context, cfg *Config) (hs *Handlers, err error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
 	}
 
-	llm := &llm{
-		client: openai.NewClient(key),
+	prompts, err := loadPrompts(cfg.Prompts)
+	if err != nil {
+		return nil, err
 	}
 
-	// read prompts config
-	cfg, err := os.ReadFile("prompts.toml")
+	client := openai.NewClient(cfg.OpenAI.APIKey)
+	embedding := cfg.Embedding
+	if embedding.Provider == "openai" && embedding.APIKey == "" {
+		embedding.APIKey = cfg.OpenAI.APIKey
+	}
+	embedder, err := NewEmbedder(embedding, client)
 	if err != nil {
-		panic(fmt.Errorf("fatal error load prompts config file: %w", err))
+		return nil, err
 	}
+	provider, err := NewLLM(cfg.LLM, client)
+	if err != nil {
+		return nil, err
+	}
+
+	// the opened clients are closed in reverse order if any later step fails
+	var closers []func()
+	defer func() {
+		if err != nil {
+			for i := len(closers) - 1; i >= 0; i-- {
+				closers[i]()
+			}
+		}
+	}()
+
+	var sessions *mongo.Collection
+	err = retry(ctx, "mongodb", startupAttempts, startupBackoff, func(ctx context.Context) (err error) {
+		sessions, err = SetupMongo(ctx, cfg.Mongo)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	closers = append(closers, func() { sessions.Database().Client().Disconnect(context.Background()) })
 
-	var prompts promptsConfig
-	toml.Decode(string(cfg), &prompts)
-	if err != nil { // Handle errors reading the config file
-		panic(fmt.Errorf("fatal error parse prompts config file: %w", err))
+	var conn *grpc.ClientConn
+	err = retry(ctx, "qdrant", startupAttempts, startupBackoff, func(ctx context.Context) (err error) {
+		conn, err = SetupQdrant(ctx, cfg.Qdrant)
+		return err
+	})
+	if err != nil {
+		return nil, err
 	}
+	closers = append(closers, func() { conn.Close() })
 
-	hs := &Handlers{
-		sessions:     sessions,
-		qCollections: collection,
-		qPoints:      points,
-		llm:          llm,
-		prompts:      prompts,
-		SearchLimit:  5,
+	cache, err := NewEmbeddingCache(cfg.Embedding.Cache)
+	if err != nil {
+		return nil, err
+	}
+	if cache != nil {
+		closers = append(closers, func() { cache.Close() })
+		embedder = NewCachedEmbedder(embedder, cfg.Embedding.Cache.Backend, cache)
+	}
+
+	jobs, err := NewJobQueue(cfg.Jobs)
+	if err != nil {
+		return nil, err
+	}
+	if jobs != nil {
+		closers = append(closers, func() { jobs.Close() })
+	}
+
+	hs, err = NewHandlers(NewMongoSessionStore(sessions), NewQdrantVectorStore(conn), provider, embedder)
+	if err != nil {
+		return nil, err
 	}
 
-	return hs
+	hs.prompts = prompts
+	hs.llm.models = cfg.LLM.Models
+	hs.llm.functions = cfg.LLM.Functions
+	hs.SearchLimit = cfg.Memory.SearchLimit
+	hs.ReflectThreshold = cfg.Memory.ReflectThreshold
+	hs.ImportLimit = cfg.Memory.ImportLimit
+	hs.Batch = cfg.Memory.Batch
+	hs.jobs = jobs
+	hs.Workers = cfg.Jobs.Workers
+	hs.APIKeys = cfg.Auth.Keys
+	hs.AuthDisabled = cfg.Auth.Disabled
+	if hs.AuthDisabled {
+		log.Println("authentication is disabled, all requests belong to the default tenant")
+	}
+	return hs, nil
+}
+
+// Close waits for the running jobs and the background reflections, then closes the stores, the embedding cache and the job queue,
+// the workers must be stopped by the context of StartWorkers
+func (hs *Handlers) Close(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		hs.workers.Wait()
+		hs.background.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-ctx.Done():
+		log.Println("stop waiting for the running jobs and background reflections:", ctx.Err())
+	}
+
+	errs := []error{hs.sessions.Close(ctx), hs.vectors.Close(ctx)}
+	if c, ok := hs.embedder.(io.Closer); ok {
+		errs = append(errs, c.Close())
+	}
+	if hs.jobs != nil {
+		errs = append(errs, hs.jobs.Close())
+	}
+	return errors.Join(errs...)
 }
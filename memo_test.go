@@ -0,0 +1,51 @@
+package memo
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// newTestHandlers creates the handlers with the in-memory stores and the stub llm,
+// so the tests can run offline
+func newTestHandlers(t *testing.T) *Handlers {
+	hs, err := NewHandlers(NewInMemorySessionStore(), NewInMemoryVectorStore(), NewOpenAILLM(NewStubClient(), ""), NewHashEmbedder(0))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// reflections run in background, disable them unless the test needs
+	hs.ReflectThreshold = 0
+	// the authentication is tested with the keys
+	hs.AuthDisabled = true
+	// the async memories are queued in the process
+	hs.jobs = NewInMemoryJobQueue(0)
+	return hs
+}
+
+// newTestRouter registers all the routes of the handlers in test mode
+func newTestRouter(hs *Handlers) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	RegisterRoutes(router, hs)
+	return router
+}
+
+// serve sends the request to the router, the body can be nil
+func serve(router http.Handler, method, path string, body io.Reader) *httptest.ResponseRecorder {
+	return serveAs(router, "", method, path, body)
+}
+
+// serveAs sends the request with the api key, which is omitted if it's empty
+func serveAs(router http.Handler, key, method, path string, body io.Reader) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest(method, path, body)
+	if key != "" {
+		req.Header.Set(apiKeyHeader, key)
+	}
+	router.ServeHTTP(w, req)
+	return w
+}
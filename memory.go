@@ -3,6 +3,7 @@ package memo
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
@@ -13,17 +14,16 @@ import (
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
 	pb "github.com/qdrant/go-client/qdrant"
-	"google.golang.org/grpc/codes"
-	"google.golang.org/grpc/status"
 )
 
 type MemoryType int8
 
 const (
-	UndefinedMemory MemoryType = iota
-	BasicMemory                // default type of memory
-	InteractMemory             // agent interacted with someone or something
-	PlanMemory                 // the plan which agent is going to follow
+	UndefinedMemory  MemoryType = iota
+	BasicMemory                 // default type of memory
+	InteractMemory              // agent interacted with someone or something
+	PlanMemory                  // the plan which agent is going to follow
+	ReflectionMemory            // the insight which agent reflected from other memories
 )
 
 var (
@@ -32,12 +32,14 @@ var (
 		1: "basic",
 		2: "interact",
 		3: "plan",
+		4: "reflection",
 	}
 	MemoryTypeInt = map[string]int8{
-		"undefined": 0,
-		"basic":     1,
-		"interact":  2,
-		"plan":      3,
+		"undefined":  0,
+		"basic":      1,
+		"interact":   2,
+		"plan":       3,
+		"reflection": 4,
 	}
 )
 
@@ -75,40 +77,186 @@ type MemoryMetadata struct {
 	Content    string     `bson:"content" json:"content"`
 	Importance int        `bson:"importance" json:"importance"` // importance score, from 1 to 10
 	CreatedAt  time.Time  `bson:"created_at" json:"created_at"`
+	AccessedAt time.Time  `bson:"accessed_at" json:"accessed_at"`               // last retrieved time
+	Evidence   []string   `bson:"evidence,omitempty" json:"evidence,omitempty"` // ids of the memories which a reflection is based on
+	StartAt    *time.Time `bson:"start_at,omitempty" json:"start_at,omitempty"` // when a plan starts
+	EndAt      *time.Time `bson:"end_at,omitempty" json:"end_at,omitempty"`     // when a plan ends
 }
 
+// payload keys of the metadata stored in qdrant
+const (
+	payloadType       = "type"
+	payloadContent    = "content"
+	payloadImportance = "importance"
+	payloadCreatedAt  = "created_at"  // unix milliseconds
+	payloadAccessedAt = "accessed_at" // unix milliseconds
+	payloadEvidence   = "evidence"    // list of memory ids
+	payloadStartAt    = "start_at"    // unix milliseconds
+	payloadEndAt      = "end_at"      // unix milliseconds
+)
+
+// Payload encodes the metadata into qdrant payload
 func (m MemoryMetadata) Payload() map[string]*pb.Value {
-	return map[string]*pb.Value{
-		"content": {Kind: &pb.Value_StringValue{StringValue: m.Content}},
+	payload := map[string]*pb.Value{
+		payloadType:       {Kind: &pb.Value_StringValue{StringValue: m.Type.String()}},
+		payloadContent:    {Kind: &pb.Value_StringValue{StringValue: m.Content}},
+		payloadImportance: {Kind: &pb.Value_IntegerValue{IntegerValue: int64(m.Importance)}},
+		payloadCreatedAt:  {Kind: &pb.Value_IntegerValue{IntegerValue: m.CreatedAt.UnixMilli()}},
+		payloadAccessedAt: {Kind: &pb.Value_IntegerValue{IntegerValue: m.AccessedAt.UnixMilli()}},
+	}
+
+	if len(m.Evidence) > 0 {
+		var ids []*pb.Value
+		for _, id := range m.Evidence {
+			ids = append(ids, &pb.Value{Kind: &pb.Value_StringValue{StringValue: id}})
+		}
+		payload[payloadEvidence] = &pb.Value{Kind: &pb.Value_ListValue{ListValue: &pb.ListValue{Values: ids}}}
+	}
+
+	if m.StartAt != nil {
+		payload[payloadStartAt] = &pb.Value{Kind: &pb.Value_IntegerValue{IntegerValue: m.StartAt.UnixMilli()}}
+	}
+	if m.EndAt != nil {
+		payload[payloadEndAt] = &pb.Value{Kind: &pb.Value_IntegerValue{IntegerValue: m.EndAt.UnixMilli()}}
 	}
+
+	return payload
+}
+
+// ParseMetadata decodes the metadata from qdrant payload,
+// missing or unknown fields are left zero-valued
+func ParseMetadata(payload map[string]*pb.Value) MemoryMetadata {
+	typ, _ := ParseMemoryType(payload[payloadType].GetStringValue())
+
+	m := MemoryMetadata{
+		Type:       typ,
+		Content:    payload[payloadContent].GetStringValue(),
+		Importance: int(payload[payloadImportance].GetIntegerValue()),
+	}
+
+	if v, ok := payload[payloadCreatedAt]; ok {
+		m.CreatedAt = time.UnixMilli(v.GetIntegerValue()).UTC()
+	}
+
+	if v, ok := payload[payloadAccessedAt]; ok {
+		m.AccessedAt = time.UnixMilli(v.GetIntegerValue()).UTC()
+	}
+
+	for _, v := range payload[payloadEvidence].GetListValue().GetValues() {
+		m.Evidence = append(m.Evidence, v.GetStringValue())
+	}
+
+	if v, ok := payload[payloadStartAt]; ok {
+		t := time.UnixMilli(v.GetIntegerValue()).UTC()
+		m.StartAt = &t
+	}
+	if v, ok := payload[payloadEndAt]; ok {
+		t := time.UnixMilli(v.GetIntegerValue()).UTC()
+		m.EndAt = &t
+	}
+
+	return m
 }
 
 type Memory struct {
-	ID        string         `bson:"id,omitempty" json:"id,omitempty"`
-	Embedding []float32      `bson:"embedding,omitempty" json:"embedding,omitempty"`
-	Metadata  MemoryMetadata `bson:"metadata" json:"metadata"`
+	ID        string           `bson:"id,omitempty" json:"id,omitempty"`
+	Embedding []float32        `bson:"embedding,omitempty" json:"embedding,omitempty"`
+	Metadata  MemoryMetadata   `bson:"metadata" json:"metadata"`
+	Score     float32          `bson:"score,omitempty" json:"score,omitempty"`   // similarity or retrieval score, only set by search
+	Scores    *RetrievalScores `bson:"scores,omitempty" json:"scores,omitempty"` // component scores, only set by weighted search
+}
+
+// MemoryFromRetrievedPoint decodes a memory from qdrant's retrieved point
+func MemoryFromRetrievedPoint(p *pb.RetrievedPoint) Memory {
+	return Memory{
+		ID:        p.GetId().GetUuid(),
+		Embedding: p.GetVectors().GetVector().GetData(),
+		Metadata:  ParseMetadata(p.GetPayload()),
+	}
 }
 
+// MemoryFromScoredPoint decodes a memory with its score from qdrant's search result
+func MemoryFromScoredPoint(p *pb.ScoredPoint) Memory {
+	return Memory{
+		ID:        p.GetId().GetUuid(),
+		Embedding: p.GetVectors().GetVector().GetData(),
+		Metadata:  ParseMetadata(p.GetPayload()),
+		Score:     p.GetScore(),
+	}
+}
+
+// Point encodes the memory into qdrant point, a new id is generated if it's not set
 func (m Memory) Point() *pb.PointStruct {
-	uuid, _ := uuid.NewUUID() // using uuid v1 which contains timestamp info
+	id := m.ID
+	if id == "" {
+		id = newMemoryID()
+	}
+
 	return &pb.PointStruct{
-		Id:      &pb.PointId{PointIdOptions: &pb.PointId_Uuid{Uuid: uuid.String()}},
+		Id:      &pb.PointId{PointIdOptions: &pb.PointId_Uuid{Uuid: id}},
 		Payload: m.Metadata.Payload(),
 		Vectors: &pb.Vectors{VectorsOptions: &pb.Vectors_Vector{Vector: &pb.Vector{Data: m.Embedding}}},
 	}
 }
 
+// newMemoryID generates the memory id, using uuid v1 which contains timestamp info
+func newMemoryID() string {
+	id, _ := uuid.NewUUID()
+	return id.String()
+}
+
 type AddMemoriesRequest struct {
-	Memories []Memory `bson:"memories" json:"memories"`
+	Memories    []Memory `bson:"memories" json:"memories"`
+	SkipScoring bool     `bson:"skip_scoring" json:"skip_scoring"` // don't score the missing importance with llm
+	Async       bool     `bson:"async" json:"async"`               // add the memories in background, the job is returned
 }
 
 type AddMemoriesResponse struct {
-	IDs []string `bson:"ids" json:"ids"` // inserted memory id in qdrant
+	IDs     []string          `bson:"ids" json:"ids"`         // inserted memory id in qdrant
+	Results []AddMemoryResult `bson:"results" json:"results"` // one for each memory in the same order
+}
+
+// AddMemoryResult is the id of the inserted memory, or the error why it's not
+type AddMemoryResult struct {
+	ID    string `bson:"id,omitempty" json:"id,omitempty"`
+	Error string `bson:"error,omitempty" json:"error,omitempty"`
 }
 
 type SearchMemoryRequest struct {
-	Query string `bson:"query" json:"query"`
-	Limit int64  `bson:"limit" json:"limit" default:"5"`
+	Query   string            `bson:"query" json:"query"`
+	Limit   int64             `bson:"limit" json:"limit" default:"5"`
+	Weights *RetrievalWeights `bson:"weights,omitempty" json:"weights,omitempty"` // rank by recency, importance and relevance if set
+	Filter  *MemoryFilter     `bson:"filter,omitempty" json:"filter,omitempty"`   // only search the memories which match the filter
+}
+
+type GetMemoriesRequest struct {
+	IDs         []string `bson:"ids" json:"ids"`
+	WithVectors bool     `bson:"with_vectors" json:"with_vectors"` // include the embeddings
+}
+
+type GetMemoriesResponse struct {
+	Memories []Memory `bson:"memories" json:"memories"`                       // in the same order as the ids
+	NotFound []string `bson:"not_found,omitempty" json:"not_found,omitempty"` // the given ids which don't exist
+}
+
+// UpdateMemoryRequest changes the given fields of the memory, the others are kept
+type UpdateMemoryRequest struct {
+	Type       *MemoryType `bson:"type,omitempty" json:"type,omitempty"`
+	Content    *string     `bson:"content,omitempty" json:"content,omitempty"` // the memory is re-embedded if its content changes
+	Importance *int        `bson:"importance,omitempty" json:"importance,omitempty"`
+	StartAt    *time.Time  `bson:"start_at,omitempty" json:"start_at,omitempty"`
+	EndAt      *time.Time  `bson:"end_at,omitempty" json:"end_at,omitempty"`
+}
+
+// DeleteMemoriesRequest deletes the memories by ids, or by filter if no id is given
+type DeleteMemoriesRequest struct {
+	IDs    []string      `bson:"ids,omitempty" json:"ids,omitempty"`
+	Filter *MemoryFilter `bson:"filter,omitempty" json:"filter,omitempty"`
+}
+
+type DeleteMemoriesResponse struct {
+	Deleted  uint64   `bson:"deleted" json:"deleted"`                         // how many memories are deleted
+	NotFound []string `bson:"not_found,omitempty" json:"not_found,omitempty"` // the given ids which don't exist
 }
 
 type RetrieveMemoriesResponse struct {
@@ -117,18 +265,23 @@ type RetrieveMemoriesResponse struct {
 }
 
 // @Summary		add memories
-// @Description	add one or more memories to the session
+// @Description	add one or more memories to the session, they are embedded and upserted in batches,
+// @Description	207 if some of them failed, with the error of each in the results,
+// @Description	or 202 with the job and the ids of the memories if it's async, see /jobs/{id} for its progress
 // @Tags			memories
 // @Accept			json
 // @Produce		json
 // @Param			session	path		string	true	"memory belonging to which session"
-// @Param			memory	body		AddMemoryRequest	true	"the memory info"
-// @Success		200	{object}	AddMemoryResponse
+// @Param			memory	body		AddMemoriesRequest	true	"the memory info"
+// @Success		200	{object}	AddMemoriesResponse
+// @Success		207	{object}	AddMemoriesResponse
+// @Success		202	{object}	Job
 // @Failure		default	{object}	APIError
-// @Router			/m/:session/add [put]
+// @Security		ApiKeyAuth
+// @Router			/m/{session}/add [post]
 func (hs *Handlers) AddMemories(c *gin.Context) {
 	ctx := c.Request.Context()
-	sid := c.Param("session") // session id
+	sid := authorizedSession(c).ID.Hex()
 
 	// decode body
 	var req AddMemoriesRequest
@@ -138,30 +291,49 @@ func (hs *Handlers) AddMemories(c *gin.Context) {
 		return
 	}
 
-	// build inputs from memories
-	var inputs []string = []string{}
-	for _, mem := range req.Memories {
-		inputs = append(inputs, mem.Metadata.Content)
-	}
-
-	// get embeddings from openai api
-	em, err := hs.llm.embedding(ctx, inputs)
-	if err != nil {
-		NewError(c, http.StatusBadRequest, ErrOpenAIEmbedding)
+	if req.Async {
+		job, err := hs.enqueue(ctx, tenant(c), sid, &req)
+		if err != nil {
+			if errors.Is(err, ErrJobsDisabled) {
+				NewError(c, http.StatusBadRequest, err)
+				return
+			}
+			NewError(c, http.StatusInternalServerError, err)
+			return
+		}
+		c.JSON(http.StatusAccepted, job)
 		return
 	}
 
-	for _, d := range em.Data {
-		req.Memories[d.Index].Embedding = d.Embedding
+	ids, errs := hs.ingest(ctx, sid, req.Memories, req.SkipScoring)
+
+	res := AddMemoriesResponse{IDs: []string{}, Results: make([]AddMemoryResult, len(ids))}
+	var added []Memory
+	var failure error
+	for i, err := range errs {
+		if err != nil {
+			res.Results[i].Error = err.Error()
+			failure = err
+			continue
+		}
+		res.IDs = append(res.IDs, ids[i])
+		res.Results[i].ID = ids[i]
+		added = append(added, req.Memories[i])
 	}
 
-	ids, err := hs.upsert(ctx, sid, req.Memories)
-	if err != nil {
-		NewError(c, http.StatusBadRequest, ErrQdrantUpsert)
+	if len(added) == 0 && failure != nil {
+		NewError(c, http.StatusBadRequest, failure)
 		return
 	}
 
-	c.JSON(http.StatusOK, AddMemoriesResponse{IDs: ids})
+	// accumulate the importance which may trigger a reflection
+	hs.accumulateImportance(ctx, sid, added)
+
+	status := http.StatusOK
+	if failure != nil {
+		status = http.StatusMultiStatus
+	}
+	c.JSON(status, res)
 }
 
 // @Summary		search memory by similarity
@@ -173,10 +345,11 @@ func (hs *Handlers) AddMemories(c *gin.Context) {
 // @Param			query	body		SearchMemoryRequest	true	"query object"
 // @Success		200	{object}	RetrieveMemoriesResponse
 // @Failure		default	{object}	APIError
-// @Router			/m/:session/search [get]
+// @Security		ApiKeyAuth
+// @Router			/m/{session}/search [post]
 func (hs *Handlers) SearchMemories(c *gin.Context) {
 	ctx := c.Request.Context()
-	sid := c.Param("session") // session id
+	sid := authorizedSession(c).ID.Hex()
 
 	// decode body
 	var req SearchMemoryRequest
@@ -186,44 +359,17 @@ func (hs *Handlers) SearchMemories(c *gin.Context) {
 		return
 	}
 
-	if req.Limit == 0 {
-		req.Limit = hs.SearchLimit
-	}
-
-	// get the query's embedding from openai
-	embedding, err := hs.llm.embedding(ctx, []string{req.Query})
-	if err != nil {
-		NewError(c, http.StatusInternalServerError, ErrOpenAIEmbedding)
+	if req.Limit < 0 || (req.Weights != nil && req.Weights.Candidates < 0) {
+		NewError(c, http.StatusBadRequest, ErrNegativeLimit)
 		return
 	}
 
-	// search
-	res, err := hs.qPoints.Search(ctx, &pb.SearchPoints{
-		CollectionName: sid,
-		Vector:         embedding.Data[0].Embedding,
-		Limit:          uint64(req.Limit),
-		// will not include vectors
-		WithVectors: &pb.WithVectorsSelector{SelectorOptions: &pb.WithVectorsSelector_Enable{Enable: false}},
-		// will include metadata
-		WithPayload: &pb.WithPayloadSelector{SelectorOptions: &pb.WithPayloadSelector_Enable{Enable: true}},
-	})
+	memories, err := hs.search(ctx, sid, req)
 	if err != nil {
-		NewError(c, http.StatusInternalServerError, ErrQdrantSearch)
+		NewError(c, http.StatusInternalServerError, err)
 		return
 	}
 
-	// covert all points into memories
-	var memories []Memory
-	for _, r := range res.GetResult() {
-		m := Memory{
-			ID: r.Id.GetUuid(),
-			Metadata: MemoryMetadata{
-				Content: r.GetPayload()["content"].GetStringValue(),
-			},
-		}
-		memories = append(memories, m)
-	}
-
 	c.JSON(http.StatusOK, RetrieveMemoriesResponse{Memories: memories})
 }
 
@@ -233,23 +379,23 @@ func (hs *Handlers) SearchMemories(c *gin.Context) {
 // @Accept			json
 // @Produce		json
 // @Param			session	path		string	true	"memory belonging to which session"
-// @Param			offset	query		string	false	"pagination offset id"
-// @Param			limit	query		int		false	"pagination limit" default(5)
+// @Param			offset			query		string		false	"pagination offset id"
+// @Param			limit			query		int			false	"pagination limit" default(5)
+// @Param			type			query		[]string	false	"any of the memory types"	collectionFormat(multi)
+// @Param			min_importance	query		int			false	"importance greater than or equal to"
+// @Param			max_importance	query		int			false	"importance less than or equal to"
+// @Param			created_after	query		string		false	"RFC3339 time, created at or after"
+// @Param			created_before	query		string		false	"RFC3339 time, created before"
 // @Success		200	{object}	RetrieveMemoriesResponse
 // @Failure		default	{object}	APIError
-// @Router			/m/:session [get]
+// @Security		ApiKeyAuth
+// @Router			/m/{session} [get]
 func (hs *Handlers) GetAllMemories(c *gin.Context) {
 	ctx := c.Request.Context()
-	sid := c.Param("session") // session id
+	sid := authorizedSession(c).ID.Hex()
 
 	// offset
 	offset := c.Query("offset")
-	var offsetId *pb.PointId
-	if offset != "" {
-		offsetId = &pb.PointId{PointIdOptions: &pb.PointId_Uuid{Uuid: offset}}
-	} else {
-		offsetId = nil
-	}
 
 	// limit
 	limit := c.Query("limit")
@@ -265,76 +411,498 @@ func (hs *Handlers) GetAllMemories(c *gin.Context) {
 		sLimit = uint32(hs.SearchLimit)
 	}
 
-	// limit
-	resp, err := hs.qPoints.Scroll(ctx, &pb.ScrollPoints{CollectionName: sid, Offset: offsetId, Limit: &sLimit})
+	filter, err := filterFromQuery(c)
+	if err != nil {
+		NewError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	memories, next, err := hs.vectors.Scroll(ctx, sid, filter, offset, sLimit)
 	if err != nil {
 		log.Println(err)
 		NewError(c, http.StatusInternalServerError, ErrQdrantScroll)
 		return
 	}
 
-	var memories []Memory
-	for _, r := range resp.GetResult() {
-		m := Memory{
-			ID: r.Id.GetUuid(),
-			Metadata: MemoryMetadata{
-				Content: r.GetPayload()["content"].GetStringValue(),
-			},
+	c.JSON(http.StatusOK, RetrieveMemoriesResponse{Memories: memories, Offset: next})
+}
+
+// @Summary		get one memory
+// @Description	get one memory by id
+// @Tags			memories
+// @Produce		json
+// @Param			session	path		string	true	"memory belonging to which session"
+// @Param			id		path		string	true	"the memory to get"
+// @Param			vectors	query		bool	false	"include the embedding"
+// @Success		200	{object}	Memory
+// @Failure		default	{object}	APIError
+// @Security		ApiKeyAuth
+// @Router			/m/{session}/{id} [get]
+func (hs *Handlers) GetMemory(c *gin.Context) {
+	ctx := c.Request.Context()
+	sid, id := authorizedSession(c).ID.Hex(), c.Param("id")
+
+	if _, err := uuid.Parse(id); err != nil {
+		NewError(c, http.StatusBadRequest, ErrInvalidID)
+		return
+	}
+
+	withVectors := false
+	if v := c.Query("vectors"); v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			NewError(c, http.StatusBadRequest, err)
+			return
 		}
-		memories = append(memories, m)
+		withVectors = b
 	}
 
-	c.JSON(http.StatusOK, RetrieveMemoriesResponse{Memories: memories, Offset: resp.GetNextPageOffset().GetUuid()})
+	found, err := hs.vectors.Get(ctx, sid, []string{id}, withVectors)
+	if err != nil {
+		memoryError(c, err)
+		return
+	}
+	if len(found) == 0 {
+		NewError(c, http.StatusNotFound, ErrMemoryNotFound)
+		return
+	}
+
+	c.JSON(http.StatusOK, found[0])
 }
 
-// ensure qdrant collection MUST exist, if not create one
-func (hs *Handlers) EnsureQCollection(ctx context.Context, name string) (upsert bool, err error) {
-	_, err = hs.qCollections.Get(ctx, &pb.GetCollectionInfoRequest{CollectionName: name})
-	// already created
-	if err == nil {
-		return false, nil
+// @Summary		get memories
+// @Description	get the memories by ids
+// @Tags			memories
+// @Accept			json
+// @Produce		json
+// @Param			session	path		string				true	"memory belonging to which session"
+// @Param			query	body		GetMemoriesRequest	true	"ids of the memories"
+// @Success		200	{object}	GetMemoriesResponse
+// @Failure		default	{object}	APIError
+// @Security		ApiKeyAuth
+// @Router			/m/{session}/get [post]
+func (hs *Handlers) GetMemories(c *gin.Context) {
+	ctx := c.Request.Context()
+	sid := authorizedSession(c).ID.Hex()
+
+	var req GetMemoriesRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		NewError(c, http.StatusBadRequest, ErrJSONDecode)
+		return
 	}
 
-	st, ok := status.FromError(err)
-	if !ok {
-		return false, err
+	for _, id := range req.IDs {
+		if _, err := uuid.Parse(id); err != nil {
+			NewError(c, http.StatusBadRequest, ErrInvalidID)
+			return
+		}
+	}
+
+	res := GetMemoriesResponse{Memories: []Memory{}}
+	if len(req.IDs) == 0 {
+		c.JSON(http.StatusOK, res)
+		return
+	}
+
+	found, err := hs.vectors.Get(ctx, sid, req.IDs, req.WithVectors)
+	if err != nil {
+		memoryError(c, err)
+		return
+	}
+
+	// qdrant doesn't keep the order of ids
+	byID := map[string]Memory{}
+	for _, m := range found {
+		byID[m.ID] = m
+	}
+	for _, id := range req.IDs {
+		if m, ok := byID[id]; ok {
+			res.Memories = append(res.Memories, m)
+		} else {
+			res.NotFound = append(res.NotFound, id)
+		}
+	}
+
+	c.JSON(http.StatusOK, res)
+}
+
+// @Summary		update one memory
+// @Description	update the given fields of the memory, it's re-embedded if the content changes
+// @Tags			memories
+// @Accept			json
+// @Produce		json
+// @Param			session	path		string				true	"memory belonging to which session"
+// @Param			id		path		string				true	"the memory to update"
+// @Param			memory	body		UpdateMemoryRequest	true	"the fields to update"
+// @Success		200	{object}	Memory
+// @Failure		default	{object}	APIError
+// @Security		ApiKeyAuth
+// @Router			/m/{session}/{id} [patch]
+func (hs *Handlers) UpdateMemory(c *gin.Context) {
+	ctx := c.Request.Context()
+	sid, id := authorizedSession(c).ID.Hex(), c.Param("id")
+
+	if _, err := uuid.Parse(id); err != nil {
+		NewError(c, http.StatusBadRequest, ErrInvalidID)
+		return
+	}
+
+	var req UpdateMemoryRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		NewError(c, http.StatusBadRequest, ErrJSONDecode)
+		return
+	}
+
+	mem, err := hs.updateMemory(ctx, sid, id, req)
+	if err != nil {
+		memoryError(c, err)
+		return
 	}
 
-	// if collection not found, then create one
-	if st.Code() == codes.NotFound {
-		_, err := hs.qCollections.Create(ctx, &pb.CreateCollection{
-			CollectionName: name,
-			VectorsConfig: &pb.VectorsConfig{
-				Config: &pb.VectorsConfig_Params{
-					Params: &pb.VectorParams{
-						Size:     1536,
-						Distance: pb.Distance_Cosine,
-					},
-				},
-			},
-		})
-		return true, err
+	c.JSON(http.StatusOK, mem)
+}
+
+// @Summary		delete one memory
+// @Description	delete one memory from the session
+// @Tags			memories
+// @Produce		json
+// @Param			session	path		string	true	"memory belonging to which session"
+// @Param			id		path		string	true	"the memory to delete"
+// @Success		200	{object}	OK
+// @Failure		default	{object}	APIError
+// @Security		ApiKeyAuth
+// @Router			/m/{session}/{id} [delete]
+func (hs *Handlers) DeleteMemory(c *gin.Context) {
+	ctx := c.Request.Context()
+	sid, id := authorizedSession(c).ID.Hex(), c.Param("id")
+
+	if _, err := uuid.Parse(id); err != nil {
+		NewError(c, http.StatusBadRequest, ErrInvalidID)
+		return
+	}
+
+	found, err := hs.vectors.Get(ctx, sid, []string{id}, false)
+	if err != nil {
+		memoryError(c, err)
+		return
+	}
+	if len(found) == 0 {
+		NewError(c, http.StatusNotFound, ErrMemoryNotFound)
+		return
+	}
+
+	if err := hs.vectors.Delete(ctx, sid, []string{id}); err != nil {
+		memoryError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, OK{OK: true})
+}
+
+// @Summary		delete memories
+// @Description	delete the memories by ids, or by filter if no id is given
+// @Tags			memories
+// @Accept			json
+// @Produce		json
+// @Param			session	path		string					true	"memory belonging to which session"
+// @Param			query	body		DeleteMemoriesRequest	true	"ids or filter"
+// @Success		200	{object}	DeleteMemoriesResponse
+// @Failure		default	{object}	APIError
+// @Security		ApiKeyAuth
+// @Router			/m/{session}/del [post]
+func (hs *Handlers) DeleteMemories(c *gin.Context) {
+	ctx := c.Request.Context()
+	sid := authorizedSession(c).ID.Hex()
+
+	var req DeleteMemoriesRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		NewError(c, http.StatusBadRequest, ErrJSONDecode)
+		return
+	}
+
+	var res *DeleteMemoriesResponse
+	var err error
+	if len(req.IDs) > 0 {
+		res, err = hs.deleteByIDs(ctx, sid, req.IDs)
+	} else {
+		res, err = hs.deleteByFilter(ctx, sid, req.Filter)
+	}
+	if err != nil {
+		memoryError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, res)
+}
+
+// updateMemory applies the changes to the stored memory, and keeps its creation time
+func (hs *Handlers) updateMemory(ctx context.Context, sid string, id string, req UpdateMemoryRequest) (*Memory, error) {
+	found, err := hs.vectors.Get(ctx, sid, []string{id}, true)
+	if err != nil {
+		return nil, err
+	}
+	if len(found) == 0 {
+		return nil, ErrMemoryNotFound
+	}
+
+	mem := found[0]
+	md := &mem.Metadata
+	if req.Type != nil && *req.Type != UndefinedMemory {
+		md.Type = *req.Type
+	}
+	if req.Importance != nil {
+		md.Importance = clampImportance(*req.Importance)
+	}
+	if req.StartAt != nil {
+		md.StartAt = req.StartAt
+	}
+	if req.EndAt != nil {
+		md.EndAt = req.EndAt
+	}
+	// the time range is checked if it's changed, e.g. the plans are sorted by their durations
+	if req.StartAt != nil || req.EndAt != nil {
+		if md.StartAt == nil || md.EndAt == nil || !md.StartAt.Before(*md.EndAt) {
+			return nil, ErrInvalidTimeRange
+		}
+	}
+
+	if req.Content != nil && *req.Content != md.Content {
+		md.Content = *req.Content
+
+		vectors, err := hs.embedder.Embed(ctx, []string{md.Content})
+		if err != nil {
+			log.Println(err)
+			return nil, ErrOpenAIEmbedding
+		}
+		mem.Embedding = vectors[0]
+	}
+
+	if err := hs.vectors.Upsert(ctx, sid, []Memory{mem}); err != nil {
+		log.Println(err)
+		return nil, ErrQdrantUpsert
+	}
+
+	mem.Embedding = nil
+	return &mem, nil
+}
+
+// deleteByIDs deletes the existing memories, it fails if none of them exists
+func (hs *Handlers) deleteByIDs(ctx context.Context, sid string, ids []string) (*DeleteMemoriesResponse, error) {
+	for _, id := range ids {
+		if _, err := uuid.Parse(id); err != nil {
+			return nil, ErrInvalidID
+		}
+	}
+
+	found, err := hs.vectors.Get(ctx, sid, ids, false)
+	if err != nil {
+		return nil, err
+	}
+	if len(found) == 0 {
+		return nil, ErrMemoryNotFound
+	}
+
+	existing := map[string]bool{}
+	var deleting []string
+	for _, m := range found {
+		existing[m.ID] = true
+		deleting = append(deleting, m.ID)
+	}
+
+	res := &DeleteMemoriesResponse{Deleted: uint64(len(deleting))}
+	for _, id := range ids {
+		if !existing[id] {
+			res.NotFound = append(res.NotFound, id)
+		}
+	}
+
+	if err := hs.vectors.Delete(ctx, sid, deleting); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// deleteByFilter deletes the memories which match the filter, an empty filter is rejected
+func (hs *Handlers) deleteByFilter(ctx context.Context, sid string, filter *MemoryFilter) (*DeleteMemoriesResponse, error) {
+	if filter.qdrant() == nil {
+		return nil, ErrEmptyFilter
+	}
+
+	count, err := hs.vectors.Count(ctx, sid, filter)
+	if err != nil {
+		return nil, err
 	}
 
-	return false, err
+	if count > 0 {
+		if err := hs.vectors.DeleteByFilter(ctx, sid, filter); err != nil {
+			return nil, err
+		}
+	}
+	return &DeleteMemoriesResponse{Deleted: count}, nil
 }
 
-// upsert memories into qdrant collection
-func (hs *Handlers) upsert(ctx context.Context, collection string, memories []Memory) (ids []string, err error) {
-	var upsertPoints []*pb.PointStruct
+// memoryError sends the error with the status code of it
+func memoryError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, ErrInvalidID), errors.Is(err, ErrEmptyFilter), errors.Is(err, ErrInvalidTimeRange):
+		NewError(c, http.StatusBadRequest, err)
+	case errors.Is(err, ErrMemoryNotFound), errors.Is(err, ErrCollectionNotFound):
+		NewError(c, http.StatusNotFound, err)
+	default:
+		NewError(c, http.StatusInternalServerError, err)
+	}
+}
 
-	for _, m := range memories {
-		point := m.Point()
-		upsertPoints = append(upsertPoints, point)
-		ids = append(ids, point.Id.GetUuid())
+// addMemories adds all the memories, or returns the first error, even though the others may be added
+func (hs *Handlers) addMemories(ctx context.Context, sid string, memories []Memory, skipScoring bool) ([]string, error) {
+	ids, errs := hs.ingest(ctx, sid, memories, skipScoring)
+	for _, err := range errs {
+		if err != nil {
+			return nil, err
+		}
 	}
+	return ids, nil
+}
 
-	waitUpsert := true
-	_, err = hs.qPoints.Upsert(ctx, &pb.UpsertPoints{
-		CollectionName: collection,
-		Wait:           &waitUpsert,
-		Points:         upsertPoints,
+// ingest fills the default metadata, scores the missing importance,
+// then embeds and upserts the memories into the session's collection in batches,
+// returns the id and error of each memory
+func (hs *Handlers) ingest(ctx context.Context, sid string, memories []Memory, skipScoring bool) ([]string, []error) {
+	// fill the default metadata
+	now := time.Now()
+	for i, mem := range memories {
+		if mem.Metadata.Type == UndefinedMemory {
+			memories[i].Metadata.Type = BasicMemory
+		}
+		if mem.Metadata.CreatedAt.IsZero() {
+			memories[i].Metadata.CreatedAt = now
+		}
+		if mem.Metadata.AccessedAt.IsZero() {
+			memories[i].Metadata.AccessedAt = memories[i].Metadata.CreatedAt
+		}
+		if mem.Metadata.Importance != 0 {
+			memories[i].Metadata.Importance = clampImportance(mem.Metadata.Importance)
+		}
+	}
+
+	// score the importance which is not given by client, the rate limited memories fail
+	errs := make([]error, len(memories))
+	if !skipScoring {
+		errs = hs.scoreImportance(ctx, memories)
+	}
+
+	// get embeddings of the scored memories from the embedder
+	var inputs []string
+	var scored []int
+	for i, mem := range memories {
+		if errs[i] == nil {
+			inputs = append(inputs, mem.Metadata.Content)
+			scored = append(scored, i)
+		}
+	}
+	vectors, embedErrs := hs.embedBatches(ctx, inputs)
+	for j, i := range scored {
+		memories[i].Embedding = vectors[j]
+		errs[i] = embedErrs[j]
+	}
+
+	ids := make([]string, len(memories))
+	for i := range memories {
+		if memories[i].ID == "" {
+			memories[i].ID = newMemoryID()
+		}
+		ids[i] = memories[i].ID
+	}
+
+	hs.upsertBatches(ctx, sid, memories, errs)
+	return ids, errs
+}
+
+// search the memories by similarity, or by retrieval score if the weights are given
+func (hs *Handlers) search(ctx context.Context, sid string, req SearchMemoryRequest) ([]Memory, error) {
+	if req.Limit == 0 {
+		req.Limit = hs.SearchLimit
+	}
+
+	// weighted retrieval ranks more candidates than the limit
+	limit := req.Limit
+	if req.Weights != nil {
+		w := req.Weights.withDefaults(req.Limit)
+		req.Weights = &w
+		limit = w.Candidates
+	}
+
+	// get the query's embedding
+	vectors, err := hs.embedder.Embed(ctx, []string{req.Query})
+	if err != nil {
+		log.Println(err)
+		return nil, ErrOpenAIEmbedding
+	}
+
+	// search
+	memories, err := hs.vectors.Search(ctx, sid, vectors[0], uint64(limit), req.Filter)
+	if err != nil {
+		return nil, ErrQdrantSearch
+	}
+
+	now := time.Now()
+	if req.Weights != nil {
+		memories = rankMemories(memories, *req.Weights, now, int(req.Limit))
+	}
+
+	// update the last accessed time, a failure here should not fail the search
+	if err := hs.touch(ctx, sid, memories, now); err != nil {
+		log.Println(err)
+	}
+
+	return memories, nil
+}
+
+// scrollAll retrieves all the memories matching the filter, page by page
+func (hs *Handlers) scrollAll(ctx context.Context, sid string, filter *MemoryFilter) ([]Memory, error) {
+	var memories []Memory
+	err := hs.scrollPages(ctx, sid, filter, false, func(page []Memory) error {
+		memories = append(memories, page...)
+		return nil
 	})
+	if err != nil {
+		return nil, ErrQdrantScroll
+	}
+	return memories, nil
+}
 
-	return
+// scrollPageSize is the number of memories scrolled at once
+const scrollPageSize = 100
+
+// scrollPages calls fn with the memories page by page, with their vectors if needed
+func (hs *Handlers) scrollPages(ctx context.Context, sid string, filter *MemoryFilter, withVectors bool, fn func([]Memory) error) error {
+	offset := ""
+	for {
+		memories, next, err := hs.vectors.Scroll(ctx, sid, filter, offset, scrollPageSize)
+		if err != nil {
+			return err
+		}
+
+		// scroll doesn't return the vectors
+		if withVectors && len(memories) > 0 {
+			ids := make([]string, 0, len(memories))
+			for _, m := range memories {
+				ids = append(ids, m.ID)
+			}
+			if memories, err = hs.vectors.Get(ctx, sid, ids, true); err != nil {
+				return err
+			}
+		}
+
+		if len(memories) > 0 {
+			if err := fn(memories); err != nil {
+				return err
+			}
+		}
+
+		if next == "" {
+			return nil
+		}
+		offset = next
+	}
 }
@@ -2,15 +2,18 @@ package memo
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"net/http"
 	"net/http/httptest"
-	"os"
 	"testing"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	pb "github.com/qdrant/go-client/qdrant"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/suite"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
 type MemoryTestSuite struct {
@@ -21,26 +24,15 @@ type MemoryTestSuite struct {
 }
 
 func (s *MemoryTestSuite) SetupSuite() {
-	loadEnv(s.T())
-
-	gin.SetMode(gin.TestMode)
-	os.Setenv("MONGO_SESSIONS", "test_sessions")
-
-	s.router = gin.New()
-	hs := Default()
-	s.hs = hs
-
-	s.router.PUT("/s/add", s.hs.AddSession)
-	s.router.DELETE("/s/:id/del", s.hs.DeleteSession)
-
-	s.router.PUT("/m/:session/add", s.hs.AddMemories)
-	s.router.GET("/m/:session/search", s.hs.SearchMemories)
-	s.router.GET("/m/:session", s.hs.GetAllMemories)
+	s.hs = newTestHandlers(s.T())
+	s.router = newTestRouter(s.hs)
+}
 
+func (s *MemoryTestSuite) SetupTest() {
 	// add a session
 	w := httptest.NewRecorder()
 	jsonStr := []byte(`{"name":"aspirin2d", "tags":["hello", "world"]}`)
-	req, _ := http.NewRequest("PUT", "/s/add", bytes.NewBuffer(jsonStr))
+	req, _ := http.NewRequest("POST", "/s/add", bytes.NewBuffer(jsonStr))
 	s.router.ServeHTTP(w, req)
 	assert.Equal(s.T(), 200, w.Code)
 
@@ -69,7 +61,7 @@ func (s *MemoryTestSuite) TestAddMemoriesAndSearch() {
   {"metadata":{"content":"i'm a little shy", "type":"plan"}},
   {"metadata":{"content":"i like playing basketball.", "type":"interact"}}
   ]}`)
-	req, _ := http.NewRequest("PUT", "/m/"+s.sess+"/add", bytes.NewBuffer(jsonStr))
+	req, _ := http.NewRequest("POST", "/m/"+s.sess+"/add", bytes.NewBuffer(jsonStr))
 	s.router.ServeHTTP(w, req)
 	assert.Equal(t, 200, w.Code)
 
@@ -78,13 +70,13 @@ func (s *MemoryTestSuite) TestAddMemoriesAndSearch() {
 	assert.Equal(t, 6, len(resp.IDs))
 
 	w = httptest.NewRecorder()
-	req, _ = http.NewRequest("PUT", "/m/404/add", nil)
+	req, _ = http.NewRequest("POST", "/m/404/add", nil)
 	s.router.ServeHTTP(w, req)
 	assert.Equal(t, 400, w.Code)
 
 	jsonStr = []byte(`{"query":"where are you from?"}`)
 	w = httptest.NewRecorder()
-	req, _ = http.NewRequest("GET", "/m/"+s.sess+"/search", bytes.NewBuffer(jsonStr))
+	req, _ = http.NewRequest("POST", "/m/"+s.sess+"/search", bytes.NewBuffer(jsonStr))
 	s.router.ServeHTTP(w, req)
 	assert.Equal(t, 200, w.Code)
 
@@ -92,12 +84,17 @@ func (s *MemoryTestSuite) TestAddMemoriesAndSearch() {
 	err := json.NewDecoder(w.Body).Decode(&result)
 	assert.NoError(t, err)
 	assert.Contains(t, result.Memories[0].Metadata.Content, "shanghai")
+	assert.Equal(t, BasicMemory, result.Memories[0].Metadata.Type)
+	assert.False(t, result.Memories[0].Metadata.CreatedAt.IsZero())
+	assert.Greater(t, result.Memories[0].Score, float32(0))
+	assert.GreaterOrEqual(t, result.Memories[0].Metadata.Importance, MinImportance)
+	assert.LessOrEqual(t, result.Memories[0].Metadata.Importance, MaxImportance)
 	assert.LessOrEqual(t, int64(len(result.Memories)), s.hs.SearchLimit)
 
 	// set the top k to 3
 	jsonStr = []byte(`{"query":"what's your name?", "limit":3}`)
 	w = httptest.NewRecorder()
-	req, _ = http.NewRequest("GET", "/m/"+s.sess+"/search", bytes.NewBuffer(jsonStr))
+	req, _ = http.NewRequest("POST", "/m/"+s.sess+"/search", bytes.NewBuffer(jsonStr))
 	s.router.ServeHTTP(w, req)
 	assert.Equal(t, 200, w.Code)
 
@@ -106,6 +103,70 @@ func (s *MemoryTestSuite) TestAddMemoriesAndSearch() {
 	assert.Contains(t, result.Memories[0].Metadata.Content, "aspirin")
 	assert.Equal(t, len(result.Memories), 3)
 
+	// rank with recency, importance and relevance
+	jsonStr = []byte(`{"query":"what's your name?", "limit":2, "weights":{"relevance":1}}`)
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("POST", "/m/"+s.sess+"/search", bytes.NewBuffer(jsonStr))
+	s.router.ServeHTTP(w, req)
+	assert.Equal(t, 200, w.Code)
+
+	result = RetrieveMemoriesResponse{}
+	err = json.NewDecoder(w.Body).Decode(&result)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(result.Memories))
+	assert.Contains(t, result.Memories[0].Metadata.Content, "aspirin")
+	assert.NotNil(t, result.Memories[0].Scores)
+	assert.Equal(t, 1.0, result.Memories[0].Scores.Relevance)
+
+	// the negative limit and candidates are rejected
+	for _, body := range []string{`{"query":"what's your name?", "limit":-1}`, `{"query":"what's your name?", "weights":{"candidates":-1}}`} {
+		w = httptest.NewRecorder()
+		req, _ = http.NewRequest("POST", "/m/"+s.sess+"/search", bytes.NewBufferString(body))
+		s.router.ServeHTTP(w, req)
+		assert.Equal(t, 400, w.Code)
+	}
+
+	// only search the interact memories
+	jsonStr = []byte(`{"query":"where are you from?", "filter":{"types":["interact"]}}`)
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("POST", "/m/"+s.sess+"/search", bytes.NewBuffer(jsonStr))
+	s.router.ServeHTTP(w, req)
+	assert.Equal(t, 200, w.Code)
+
+	result = RetrieveMemoriesResponse{}
+	err = json.NewDecoder(w.Body).Decode(&result)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(result.Memories))
+	assert.Equal(t, InteractMemory, result.Memories[0].Metadata.Type)
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/m/"+s.sess+"?type=basic,plan&limit=10", nil)
+	s.router.ServeHTTP(w, req)
+	assert.Equal(t, 200, w.Code)
+
+	result = RetrieveMemoriesResponse{}
+	err = json.NewDecoder(w.Body).Decode(&result)
+	assert.NoError(t, err)
+	assert.Equal(t, 5, len(result.Memories))
+	for _, m := range result.Memories {
+		assert.NotEqual(t, InteractMemory, m.Metadata.Type)
+	}
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/m/"+s.sess+"?created_before="+time.Now().Add(-time.Hour).Format(time.RFC3339), nil)
+	s.router.ServeHTTP(w, req)
+	assert.Equal(t, 200, w.Code)
+
+	result = RetrieveMemoriesResponse{}
+	err = json.NewDecoder(w.Body).Decode(&result)
+	assert.NoError(t, err)
+	assert.Zero(t, len(result.Memories))
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/m/"+s.sess+"?min_importance=x", nil)
+	s.router.ServeHTTP(w, req)
+	assert.Equal(t, 400, w.Code)
+
 	w = httptest.NewRecorder()
 	req, _ = http.NewRequest("GET", "/m/"+s.sess, nil)
 	s.router.ServeHTTP(w, req)
@@ -125,6 +186,244 @@ func (s *MemoryTestSuite) TestAddMemoriesAndSearch() {
 	assert.NoError(t, err)
 	assert.Equal(t, len(result.Memories), 1)
 	assert.Contains(t, result.Memories[0].Metadata.Content, "i like")
+	assert.Equal(t, InteractMemory, result.Memories[0].Metadata.Type)
+}
+
+func (s *MemoryTestSuite) TestUpdateAndDeleteMemories() {
+	t := s.T()
+	w := httptest.NewRecorder()
+	jsonStr := []byte(`{"memories":[
+    {"metadata":{"content":"hello, my name is aspirin.", "importance":3}},
+    {"metadata":{"content":"i'm from shanghai.", "type":"interact"}},
+    {"metadata":{"content":"i like playing basketball.", "type":"interact"}},
+    {"metadata":{"content":"i'm a little shy", "type":"plan"}}
+  ]}`)
+	req, _ := http.NewRequest("POST", "/m/"+s.sess+"/add", bytes.NewBuffer(jsonStr))
+	s.router.ServeHTTP(w, req)
+	assert.Equal(t, 200, w.Code)
+
+	var added AddMemoriesResponse
+	json.NewDecoder(w.Body).Decode(&added)
+	assert.Equal(t, 4, len(added.IDs))
+
+	before, err := s.hs.vectors.Get(context.TODO(), s.sess, added.IDs[:1], true)
+	assert.NoError(t, err)
+
+	// change the content and importance
+	w = httptest.NewRecorder()
+	jsonStr = []byte(`{"content":"hello, my name is aspirin2d.", "importance":12}`)
+	req, _ = http.NewRequest("PATCH", "/m/"+s.sess+"/"+added.IDs[0], bytes.NewBuffer(jsonStr))
+	s.router.ServeHTTP(w, req)
+	assert.Equal(t, 200, w.Code)
+
+	var updated Memory
+	json.NewDecoder(w.Body).Decode(&updated)
+	assert.Equal(t, "hello, my name is aspirin2d.", updated.Metadata.Content)
+	assert.Equal(t, MaxImportance, updated.Metadata.Importance)
+	assert.Equal(t, BasicMemory, updated.Metadata.Type)
+
+	after, err := s.hs.vectors.Get(context.TODO(), s.sess, added.IDs[:1], true)
+	assert.NoError(t, err)
+	assert.True(t, before[0].Metadata.CreatedAt.Equal(after[0].Metadata.CreatedAt))
+	assert.NotEqual(t, before[0].Embedding, after[0].Embedding)
+
+	// only the type changes, so the embedding is kept
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("PATCH", "/m/"+s.sess+"/"+added.IDs[0], bytes.NewBuffer([]byte(`{"type":"interact"}`)))
+	s.router.ServeHTTP(w, req)
+	assert.Equal(t, 200, w.Code)
+
+	kept, err := s.hs.vectors.Get(context.TODO(), s.sess, added.IDs[:1], true)
+	assert.NoError(t, err)
+	assert.Equal(t, after[0].Embedding, kept[0].Embedding)
+	assert.Equal(t, InteractMemory, kept[0].Metadata.Type)
+
+	// the time range is set together, and it starts before it ends
+	for _, body := range []string{
+		`{"start_at":"2023-06-01T08:00:00Z"}`,
+		`{"start_at":"2023-06-01T09:00:00Z", "end_at":"2023-06-01T08:00:00Z"}`,
+	} {
+		w = httptest.NewRecorder()
+		req, _ = http.NewRequest("PATCH", "/m/"+s.sess+"/"+added.IDs[3], bytes.NewBufferString(body))
+		s.router.ServeHTTP(w, req)
+		assert.Equal(t, 400, w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("PATCH", "/m/"+s.sess+"/"+added.IDs[3], bytes.NewBufferString(`{"start_at":"2023-06-01T08:00:00Z", "end_at":"2023-06-01T09:00:00Z"}`))
+	s.router.ServeHTTP(w, req)
+	assert.Equal(t, 200, w.Code)
+
+	// then either of them can be changed
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("PATCH", "/m/"+s.sess+"/"+added.IDs[3], bytes.NewBufferString(`{"end_at":"2023-06-01T07:00:00Z"}`))
+	s.router.ServeHTTP(w, req)
+	assert.Equal(t, 400, w.Code)
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("PATCH", "/m/"+s.sess+"/"+added.IDs[3], bytes.NewBufferString(`{"end_at":"2023-06-01T10:00:00Z"}`))
+	s.router.ServeHTTP(w, req)
+	assert.Equal(t, 200, w.Code)
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("PATCH", "/m/"+s.sess+"/"+newMemoryID(), bytes.NewBuffer([]byte(`{"importance":1}`)))
+	s.router.ServeHTTP(w, req)
+	assert.Equal(t, 404, w.Code)
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("PATCH", "/m/"+s.sess+"/123", bytes.NewBuffer([]byte(`{"importance":1}`)))
+	s.router.ServeHTTP(w, req)
+	assert.Equal(t, 400, w.Code)
+
+	// delete one memory
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("DELETE", "/m/"+s.sess+"/"+added.IDs[3], nil)
+	s.router.ServeHTTP(w, req)
+	assert.Equal(t, 200, w.Code)
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("DELETE", "/m/"+s.sess+"/"+added.IDs[3], nil)
+	s.router.ServeHTTP(w, req)
+	assert.Equal(t, 404, w.Code)
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("DELETE", "/m/"+primitive.NewObjectID().Hex()+"/"+added.IDs[0], nil)
+	s.router.ServeHTTP(w, req)
+	assert.Equal(t, 404, w.Code)
+
+	// delete by ids
+	w = httptest.NewRecorder()
+	jsonStr = []byte(`{"ids":["` + added.IDs[2] + `", "` + added.IDs[3] + `"]}`)
+	req, _ = http.NewRequest("POST", "/m/"+s.sess+"/del", bytes.NewBuffer(jsonStr))
+	s.router.ServeHTTP(w, req)
+	assert.Equal(t, 200, w.Code)
+
+	var deleted DeleteMemoriesResponse
+	json.NewDecoder(w.Body).Decode(&deleted)
+	assert.Equal(t, uint64(1), deleted.Deleted)
+	assert.Equal(t, []string{added.IDs[3]}, deleted.NotFound)
+
+	// delete by filter
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("POST", "/m/"+s.sess+"/del", bytes.NewBuffer([]byte(`{"filter":{"types":["interact"]}}`)))
+	s.router.ServeHTTP(w, req)
+	assert.Equal(t, 200, w.Code)
+
+	deleted = DeleteMemoriesResponse{}
+	json.NewDecoder(w.Body).Decode(&deleted)
+	assert.Equal(t, uint64(2), deleted.Deleted)
+
+	count, err := s.hs.vectors.Count(context.TODO(), s.sess, nil)
+	assert.NoError(t, err)
+	assert.Zero(t, count)
+
+	// an empty filter can't delete everything
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("POST", "/m/"+s.sess+"/del", bytes.NewBuffer([]byte(`{}`)))
+	s.router.ServeHTTP(w, req)
+	assert.Equal(t, 400, w.Code)
+}
+
+func (s *MemoryTestSuite) TestGetMemories() {
+	t := s.T()
+	w := httptest.NewRecorder()
+	jsonStr := []byte(`{"memories":[
+    {"metadata":{"content":"hello, my name is aspirin.", "importance":3}},
+    {"metadata":{"content":"i'm from shanghai.", "type":"interact"}}
+  ]}`)
+	req, _ := http.NewRequest("POST", "/m/"+s.sess+"/add", bytes.NewBuffer(jsonStr))
+	s.router.ServeHTTP(w, req)
+	assert.Equal(t, 200, w.Code)
+
+	var added AddMemoriesResponse
+	json.NewDecoder(w.Body).Decode(&added)
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/m/"+s.sess+"/"+added.IDs[0], nil)
+	s.router.ServeHTTP(w, req)
+	assert.Equal(t, 200, w.Code)
+
+	var mem Memory
+	json.NewDecoder(w.Body).Decode(&mem)
+	assert.Equal(t, added.IDs[0], mem.ID)
+	assert.Equal(t, "hello, my name is aspirin.", mem.Metadata.Content)
+	assert.Equal(t, 3, mem.Metadata.Importance)
+	assert.False(t, mem.Metadata.CreatedAt.IsZero())
+	assert.Nil(t, mem.Embedding)
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/m/"+s.sess+"/"+added.IDs[0]+"?vectors=true", nil)
+	s.router.ServeHTTP(w, req)
+	assert.Equal(t, 200, w.Code)
+
+	mem = Memory{}
+	json.NewDecoder(w.Body).Decode(&mem)
+	assert.Equal(t, s.hs.embedder.Dimension(), len(mem.Embedding))
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/m/"+s.sess+"/"+added.IDs[0]+"?vectors=maybe", nil)
+	s.router.ServeHTTP(w, req)
+	assert.Equal(t, 400, w.Code)
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/m/"+s.sess+"/"+newMemoryID(), nil)
+	s.router.ServeHTTP(w, req)
+	assert.Equal(t, 404, w.Code)
+
+	// batch in the given order
+	missing := newMemoryID()
+	w = httptest.NewRecorder()
+	jsonStr = []byte(`{"ids":["` + added.IDs[1] + `", "` + missing + `", "` + added.IDs[0] + `"]}`)
+	req, _ = http.NewRequest("POST", "/m/"+s.sess+"/get", bytes.NewBuffer(jsonStr))
+	s.router.ServeHTTP(w, req)
+	assert.Equal(t, 200, w.Code)
+
+	var res GetMemoriesResponse
+	json.NewDecoder(w.Body).Decode(&res)
+	assert.Equal(t, 2, len(res.Memories))
+	assert.Equal(t, added.IDs[1], res.Memories[0].ID)
+	assert.Equal(t, InteractMemory, res.Memories[0].Metadata.Type)
+	assert.Equal(t, added.IDs[0], res.Memories[1].ID)
+	assert.Equal(t, []string{missing}, res.NotFound)
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("POST", "/m/"+s.sess+"/get", bytes.NewBuffer([]byte(`{"ids":["123"]}`)))
+	s.router.ServeHTTP(w, req)
+	assert.Equal(t, 400, w.Code)
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("POST", "/m/"+primitive.NewObjectID().Hex()+"/get", bytes.NewBuffer([]byte(`{"ids":["`+missing+`"]}`)))
+	s.router.ServeHTTP(w, req)
+	assert.Equal(t, 404, w.Code)
+}
+
+func TestMemoryPayload(t *testing.T) {
+	md := MemoryMetadata{
+		Type:       InteractMemory,
+		Content:    "i like playing basketball.",
+		Importance: 7,
+		CreatedAt:  time.Date(2023, 6, 1, 8, 30, 0, 0, time.UTC),
+		AccessedAt: time.Date(2023, 6, 2, 9, 0, 0, 0, time.UTC),
+	}
+
+	p := &pb.RetrievedPoint{
+		Id:      &pb.PointId{PointIdOptions: &pb.PointId_Uuid{Uuid: "a-uuid"}},
+		Payload: md.Payload(),
+	}
+	m := MemoryFromRetrievedPoint(p)
+	assert.Equal(t, "a-uuid", m.ID)
+	assert.Equal(t, md, m.Metadata)
+
+	sp := &pb.ScoredPoint{Id: p.Id, Payload: md.Payload(), Score: 0.8}
+	m = MemoryFromScoredPoint(sp)
+	assert.Equal(t, md, m.Metadata)
+	assert.Equal(t, float32(0.8), m.Score)
+
+	// legacy payload which only has content
+	md = ParseMetadata(map[string]*pb.Value{"content": {Kind: &pb.Value_StringValue{StringValue: "hello"}}})
+	assert.Equal(t, UndefinedMemory, md.Type)
+	assert.Equal(t, "hello", md.Content)
+	assert.True(t, md.CreatedAt.IsZero())
 }
 
 func TestMemory(t *testing.T) {
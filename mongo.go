@@ -0,0 +1,195 @@
+package memo
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+)
+
+// MongoSessionStore stores the sessions in a mongodb collection
+type MongoSessionStore struct {
+	coll *mongo.Collection
+}
+
+func NewMongoSessionStore(coll *mongo.Collection) *MongoSessionStore {
+	return &MongoSessionStore{coll: coll}
+}
+
+func (s *MongoSessionStore) Ping(ctx context.Context) error {
+	return s.coll.Database().Client().Ping(ctx, readpref.Primary())
+}
+
+// Close disconnects the mongodb client
+func (s *MongoSessionStore) Close(ctx context.Context) error {
+	return s.coll.Database().Client().Disconnect(ctx)
+}
+
+func (s *MongoSessionStore) List(ctx context.Context, q SessionQuery) ([]Session, error) {
+	filter := bson.M{}
+	if !q.All {
+		filter["owner"] = q.Owner
+		// the sessions created before the owner was introduced belong to the default tenant
+		if q.Owner == "" {
+			filter["owner"] = bson.M{"$in": bson.A{"", nil}}
+		}
+		// so are the sessions created before the state was introduced active
+		filter["state"] = bson.M{"$in": bson.A{SessionActive, nil}}
+	}
+
+	if len(q.Tags) > 0 {
+		filter["tags"] = bson.M{"$all": q.Tags}
+	}
+
+	// set search offset id
+	if !q.Offset.IsZero() {
+		filter["_id"] = bson.M{"$lt": q.Offset}
+	}
+
+	opts := options.Find().SetSort(bson.M{"_id": -1}).SetLimit(q.Limit)
+
+	cur, err := s.coll.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	results := []Session{}
+	if err = cur.All(ctx, &results); err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+func (s *MongoSessionStore) Create(ctx context.Context, sess *Session) (primitive.ObjectID, error) {
+	res, err := s.coll.InsertOne(ctx, sess)
+	if err != nil {
+		return primitive.NilObjectID, err
+	}
+
+	id, ok := res.InsertedID.(primitive.ObjectID)
+	if !ok {
+		return primitive.NilObjectID, ErrInvalidID
+	}
+	sess.ID = id
+	return id, nil
+}
+
+func (s *MongoSessionStore) Get(ctx context.Context, id primitive.ObjectID) (*Session, error) {
+	var sess Session
+	err := s.coll.FindOne(ctx, bson.M{"_id": id}).Decode(&sess)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrSessionNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &sess, nil
+}
+
+func (s *MongoSessionStore) Delete(ctx context.Context, id primitive.ObjectID) error {
+	err := s.coll.FindOneAndDelete(ctx, bson.M{"_id": id}).Err()
+	if err == mongo.ErrNoDocuments {
+		return ErrSessionNotFound
+	}
+	return err
+}
+
+func (s *MongoSessionStore) SetState(ctx context.Context, id primitive.ObjectID, state SessionState, at time.Time) error {
+	update := bson.M{"$set": bson.M{"state": state, "updated_at": primitive.NewDateTimeFromTime(at)}}
+	res, err := s.coll.UpdateOne(ctx, bson.M{"_id": id}, update)
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return ErrSessionNotFound
+	}
+	return nil
+}
+
+func (s *MongoSessionStore) Update(ctx context.Context, id primitive.ObjectID, req *UpdateSessionRequest, at time.Time) (*Session, error) {
+	set := bson.M{"updated_at": primitive.NewDateTimeFromTime(at)}
+	unset := bson.M{}
+	if req.Name != nil {
+		set["name"] = *req.Name
+	}
+	if req.Desc != nil {
+		set["desc"] = *req.Desc
+	}
+	if req.Tags != nil {
+		set["tags"] = *req.Tags
+	}
+	for k, v := range req.Attributes {
+		if v == nil {
+			unset["attributes."+k] = ""
+		} else {
+			set["attributes."+k] = *v
+		}
+	}
+
+	update := bson.M{"$set": set}
+	if len(unset) > 0 {
+		update["$unset"] = unset
+	}
+
+	var sess Session
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	err := s.coll.FindOneAndUpdate(ctx, bson.M{"_id": id}, update, opts).Decode(&sess)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrSessionNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &sess, nil
+}
+
+func (s *MongoSessionStore) AddImportance(ctx context.Context, id primitive.ObjectID, delta int) error {
+	res, err := s.coll.UpdateOne(ctx, bson.M{"_id": id}, bson.M{"$inc": bson.M{"acc_importance": delta}})
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return ErrSessionNotFound
+	}
+	return nil
+}
+
+func (s *MongoSessionStore) ResetImportance(ctx context.Context, id primitive.ObjectID, min int, at time.Time) (*Session, error) {
+	filter := bson.M{"_id": id}
+	if min > 0 {
+		// the sessions created before the reflection have no accumulated importance
+		filter["acc_importance"] = bson.M{"$gte": min}
+	}
+	update := bson.M{"$set": bson.M{
+		"acc_importance": 0,
+		"reflected_at":   primitive.NewDateTimeFromTime(at),
+	}}
+
+	var sess Session
+	err := s.coll.FindOneAndUpdate(ctx, filter, update).Decode(&sess)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrSessionNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &sess, nil
+}
+
+func (s *MongoSessionStore) RestoreImportance(ctx context.Context, id primitive.ObjectID, delta int, reflectedAt primitive.DateTime, at time.Time) error {
+	if err := s.AddImportance(ctx, id, delta); err != nil {
+		return err
+	}
+
+	// the legacy sessions were never reflected
+	update := bson.M{"$unset": bson.M{"reflected_at": ""}}
+	if reflectedAt != 0 {
+		update = bson.M{"$set": bson.M{"reflected_at": reflectedAt}}
+	}
+	_, err := s.coll.UpdateOne(ctx, bson.M{"_id": id, "reflected_at": primitive.NewDateTimeFromTime(at)}, update)
+	return err
+}
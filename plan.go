@@ -0,0 +1,314 @@
+package memo
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	PlanDepthDay    = 1 // broad strokes of the day
+	PlanDepthHour   = 2 // decomposed into hour-long chunks
+	PlanDepthMinute = 3 // decomposed into 5-15 minutes chunks
+
+	planMemoryLimit = 10 // how many memories to retrieve for planning
+	planTimeout     = 2 * time.Minute
+)
+
+// e.g. "08:00-09:30 have breakfast", "8:00 ～ 9:30：吃早饭"
+var planItem = regexp.MustCompile(`(\d{1,2})[:：](\d{2})\s*(?:-|~|～|—|–|至|到)+\s*(\d{1,2})[:：](\d{2})\s*[:：,，]?\s*(.+)`)
+
+type PlanRequest struct {
+	Date  time.Time `bson:"date" json:"date"`                           // any time of the day to plan, default is today
+	Depth int       `bson:"depth" json:"depth" default:"3" example:"3"` // 1: day, 2: hours, 3: 5-15 minutes
+}
+
+type PlanResponse struct {
+	Plans []Memory `bson:"plans" json:"plans"` // plans of all levels, ordered by start time
+}
+
+// plan is a parsed plan item
+type plan struct {
+	Start   time.Time
+	End     time.Time
+	Content string
+}
+
+// @Summary		plan the day
+// @Description	generate a day plan for the session's agent, and decompose it into hours and minutes, the previous plans of the day are replaced
+// @Tags			plans
+// @Accept			json
+// @Produce		json
+// @Param			session	path		string		true	"plan belonging to which session"
+// @Param			plan	body		PlanRequest	false	"plan options"
+// @Success		200	{object}	PlanResponse
+// @Failure		default	{object}	APIError
+// @Security		ApiKeyAuth
+// @Router			/m/{session}/plan [post]
+func (hs *Handlers) Plan(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	sess := authorizedSession(c)
+
+	var req PlanRequest
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			NewError(c, http.StatusBadRequest, ErrJSONDecode)
+			return
+		}
+	}
+
+	if req.Date.IsZero() {
+		req.Date = time.Now()
+	}
+	if req.Depth < PlanDepthDay || req.Depth > PlanDepthMinute {
+		req.Depth = PlanDepthMinute
+	}
+
+	plans, err := hs.plan(ctx, sess, req)
+	if err != nil {
+		NewError(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, PlanResponse{Plans: plans})
+}
+
+// @Summary		get current plan
+// @Description	what is the agent doing at the given time, the finest plan first
+// @Tags			plans
+// @Produce		json
+// @Param			session	path		string	true	"plan belonging to which session"
+// @Param			at		query		string	false	"RFC3339 time, default is now"
+// @Success		200	{object}	RetrieveMemoriesResponse
+// @Failure		default	{object}	APIError
+// @Security		ApiKeyAuth
+// @Router			/m/{session}/plan [get]
+func (hs *Handlers) GetPlan(c *gin.Context) {
+	ctx := c.Request.Context()
+	sid := authorizedSession(c).ID.Hex()
+
+	at := time.Now()
+	if q := c.Query("at"); q != "" {
+		t, err := time.Parse(time.RFC3339, q)
+		if err != nil {
+			NewError(c, http.StatusBadRequest, err)
+			return
+		}
+		at = t
+	}
+
+	plans, err := hs.scrollAll(ctx, sid, &MemoryFilter{Types: []MemoryType{PlanMemory}, ActiveAt: &at})
+	if err != nil {
+		NewError(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	if len(plans) == 0 {
+		NewError(c, http.StatusNotFound, ErrPlanNotFound)
+		return
+	}
+
+	// the shortest plan is the most detailed one
+	sort.SliceStable(plans, func(i, j int) bool {
+		return plans[i].Metadata.EndAt.Sub(*plans[i].Metadata.StartAt) < plans[j].Metadata.EndAt.Sub(*plans[j].Metadata.StartAt)
+	})
+
+	c.JSON(http.StatusOK, RetrieveMemoriesResponse{Memories: plans})
+}
+
+// plan the day for the agent, decompose it recursively, then store all the plans,
+// which replace the previous plans of the day
+func (hs *Handlers) plan(ctx context.Context, sess *Session, req PlanRequest) ([]Memory, error) {
+	// one llm call for each plan of each level
+	ctx, cancel := context.WithTimeout(ctx, planTimeout)
+	defer cancel()
+
+	sid := sess.ID.Hex()
+	y, m, d := req.Date.Date()
+	day := time.Date(y, m, d, 0, 0, 0, 0, req.Date.Location())
+
+	memories, err := hs.search(ctx, sid, SearchMemoryRequest{Query: sess.describe(), Limit: planMemoryLimit, Weights: &RetrievalWeights{}})
+	if err != nil {
+		return nil, err
+	}
+
+	agent := fmt.Sprintf("%s\n%s\n", day.Format("2006-01-02 Monday"), sess.describe())
+	if len(memories) > 0 {
+		summary, err := hs.summarize(ctx, sess, memories)
+		if err != nil {
+			return nil, err
+		}
+		agent += summary + "\n"
+	}
+
+	dayPlans, err := hs.decompose(ctx, hs.prompts.PlanDay, agent, plan{Start: day, End: day.AddDate(0, 0, 1)})
+	if err != nil {
+		return nil, err
+	}
+
+	all := dayPlans
+	parents := dayPlans
+	levels := [][]Message{hs.prompts.PlanHours, hs.prompts.PlanMinutes}
+	for _, prompts := range levels[:req.Depth-PlanDepthDay] {
+		var children []plan
+		for _, p := range parents {
+			items, err := hs.decompose(ctx, prompts, agent, p)
+			if err != nil {
+				return nil, err
+			}
+			children = append(children, items...)
+		}
+		all = append(all, children...)
+		parents = children
+	}
+
+	plans := make([]Memory, 0, len(all))
+	for _, p := range all {
+		start, end := p.Start, p.End
+		plans = append(plans, Memory{
+			Metadata: MemoryMetadata{
+				Type:    PlanMemory,
+				Content: p.Content,
+				StartAt: &start,
+				EndAt:   &end,
+			},
+		})
+	}
+
+	sort.SliceStable(plans, func(i, j int) bool { return plans[i].Metadata.StartAt.Before(*plans[j].Metadata.StartAt) })
+
+	if len(plans) == 0 {
+		return plans, nil
+	}
+
+	previous, err := hs.dayPlans(ctx, sid, day)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := hs.addMemories(ctx, sid, plans, false); err != nil {
+		return nil, err
+	}
+
+	// the previous plans are deleted after the new ones are added, so the day is never left without plans
+	if len(previous) > 0 {
+		if err := hs.vectors.Delete(ctx, sid, previous); err != nil {
+			return nil, err
+		}
+	}
+
+	// the vectors are not returned
+	for i := range plans {
+		plans[i].Embedding = nil
+	}
+	return plans, nil
+}
+
+// dayPlans returns the ids of the plans which start in the day
+func (hs *Handlers) dayPlans(ctx context.Context, sid string, day time.Time) ([]string, error) {
+	end := day.AddDate(0, 0, 1)
+	var ids []string
+	err := hs.scrollPages(ctx, sid, &MemoryFilter{Types: []MemoryType{PlanMemory}}, false, func(memories []Memory) error {
+		for _, m := range memories {
+			if start := m.Metadata.StartAt; start != nil && !start.Before(day) && start.Before(end) {
+				ids = append(ids, m.ID)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, ErrQdrantScroll
+	}
+	return ids, nil
+}
+
+// summarize the memories into the agent's recent status, which is shared by all the levels of planning
+func (hs *Handlers) summarize(ctx context.Context, sess *Session, memories []Memory) (string, error) {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s\n", sess.describe())
+	for _, mem := range memories {
+		fmt.Fprintf(&b, "- %s\n", mem.Metadata.Content)
+	}
+
+	summary, err := hs.llm.complete(ctx, TaskSummarization, hs.prompts.SummarizeMemories, strings.TrimSpace(b.String()))
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(summary), nil
+}
+
+// decompose the parent plan into smaller ones with the llm
+func (hs *Handlers) decompose(ctx context.Context, prompts []Message, agent string, parent plan) ([]plan, error) {
+	content := fmt.Sprintf("%s\n%s-%s %s", agent, parent.Start.Format("15:04"), formatClock(parent.End, parent.Start), parent.Content)
+
+	reply, err := hs.llm.complete(ctx, TaskPlanning, prompts, strings.TrimSpace(content))
+	if err != nil {
+		return nil, err
+	}
+
+	return parsePlans(reply, parent), nil
+}
+
+// parsePlans reads one plan per line, plans out of the parent's time range are dropped
+func parsePlans(reply string, parent plan) []plan {
+	y, m, d := parent.Start.Date()
+	day := time.Date(y, m, d, 0, 0, 0, 0, parent.Start.Location())
+
+	var plans []plan
+	for _, line := range strings.Split(reply, "\n") {
+		match := planItem.FindStringSubmatch(line)
+		if match == nil {
+			continue
+		}
+
+		start, ok := clock(day, match[1], match[2])
+		if !ok {
+			continue
+		}
+		end, ok := clock(day, match[3], match[4])
+		if !ok {
+			continue
+		}
+
+		content := strings.TrimSpace(match[5])
+		if content == "" || !end.After(start) || start.Before(parent.Start) || end.After(parent.End) {
+			continue
+		}
+
+		plans = append(plans, plan{Start: start, End: end, Content: content})
+	}
+	return plans
+}
+
+// clock converts the hour and minute into time of the day, 24:00 is the end of the day
+func clock(day time.Time, hour, minute string) (time.Time, bool) {
+	h, err := strconv.Atoi(hour)
+	if err != nil {
+		return time.Time{}, false
+	}
+	m, err := strconv.Atoi(minute)
+	if err != nil {
+		return time.Time{}, false
+	}
+
+	if h > 24 || m > 59 || (h == 24 && m != 0) {
+		return time.Time{}, false
+	}
+	return day.Add(time.Duration(h)*time.Hour + time.Duration(m)*time.Minute), true
+}
+
+// formatClock formats the end time, which is 24:00 if it's the midnight after start
+func formatClock(t time.Time, start time.Time) string {
+	if t.Hour() == 0 && t.Minute() == 0 && t.After(start) {
+		return "24:00"
+	}
+	return t.Format("15:04")
+}
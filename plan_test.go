@@ -0,0 +1,136 @@
+package memo
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	pb "github.com/qdrant/go-client/qdrant"
+	openai "github.com/sashabaranov/go-openai"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestParsePlans(t *testing.T) {
+	day := time.Date(2023, 6, 1, 0, 0, 0, 0, time.UTC)
+	parent := plan{Start: day, End: day.AddDate(0, 0, 1)}
+
+	reply := `1. 00:00-07:30 睡觉
+07:30 ～ 08:00：起床洗漱
+8:00-12:00 go to class
+13:00-12:00 invalid range
+25:00-26:00 invalid clock
+no plan here
+22:30-24:00 睡觉`
+
+	plans := parsePlans(reply, parent)
+	assert.Equal(t, 4, len(plans))
+	assert.Equal(t, plan{Start: day, End: day.Add(7*time.Hour + 30*time.Minute), Content: "睡觉"}, plans[0])
+	assert.Equal(t, "起床洗漱", plans[1].Content)
+	assert.Equal(t, day.Add(8*time.Hour), plans[2].Start)
+	assert.Equal(t, "go to class", plans[2].Content)
+	assert.Equal(t, parent.End, plans[3].End)
+
+	// out of the parent's range
+	parent = plan{Start: day.Add(8 * time.Hour), End: day.Add(9 * time.Hour)}
+	plans = parsePlans("08:00-08:15 walk to class\n08:15-09:15 have a class", parent)
+	assert.Equal(t, 1, len(plans))
+	assert.Equal(t, "walk to class", plans[0].Content)
+
+	assert.Equal(t, "24:00", formatClock(day.AddDate(0, 0, 1), day))
+	assert.Equal(t, "00:00", formatClock(day, day))
+}
+
+func TestPlanPayload(t *testing.T) {
+	start := time.Date(2023, 6, 1, 8, 0, 0, 0, time.UTC)
+	end := start.Add(15 * time.Minute)
+	md := MemoryMetadata{Type: PlanMemory, Content: "walk to class", StartAt: &start, EndAt: &end}
+
+	m := MemoryFromRetrievedPoint(&pb.RetrievedPoint{Payload: md.Payload()})
+	assert.Equal(t, start, *m.Metadata.StartAt)
+	assert.Equal(t, end, *m.Metadata.EndAt)
+
+	f := MemoryFilter{Types: []MemoryType{PlanMemory}, ActiveAt: &start}
+	assert.Equal(t, 3, len(f.qdrant().Must))
+	assert.Nil(t, (&MemoryFilter{}).qdrant())
+}
+
+func TestPlan(t *testing.T) {
+	hs := newTestHandlers(t)
+	var agent string // the agent of the last day plan
+	hs.llm.provider = NewOpenAILLM(&StubClient{Dimension: 1536, Reply: func(messages []openai.ChatCompletionMessage) string {
+		switch messages[0].Content {
+		case hs.prompts.SummarizeMemories[0].Content:
+			return "aspirin is preparing for a basketball game."
+		case hs.prompts.PlanDay[0].Content:
+			agent = messages[len(messages)-1].Content
+			return "00:00-08:00 sleep\n08:00-24:00 play basketball"
+		case hs.prompts.PlanHours[0].Content:
+			// only fits in the second plan of the day
+			return "08:00-12:00 practice\n12:00-13:00 have lunch"
+		}
+		return stubScores(messages)
+	}}, "")
+
+	router := newTestRouter(hs)
+
+	ctx := context.TODO()
+	id, err := hs.sessions.Create(ctx, &Session{Name: "aspirin"})
+	assert.NoError(t, err)
+	_, err = hs.vectors.EnsureCollection(ctx, id.Hex(), hs.embedder.Dimension())
+	assert.NoError(t, err)
+
+	w := httptest.NewRecorder()
+	body := []byte(`{"date":"2023-06-01T10:00:00Z", "depth":2}`)
+	req, _ := http.NewRequest("POST", "/m/"+id.Hex()+"/plan", bytes.NewBuffer(body))
+	router.ServeHTTP(w, req)
+	assert.Equal(t, 200, w.Code)
+	assert.NotContains(t, w.Body.String(), `"embedding"`)
+
+	var res PlanResponse
+	assert.NoError(t, json.NewDecoder(w.Body).Decode(&res))
+	assert.Equal(t, 4, len(res.Plans))
+	assert.Equal(t, "sleep", res.Plans[0].Metadata.Content)
+	// there is nothing to summarize
+	assert.NotContains(t, agent, "basketball game")
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/m/"+id.Hex()+"/plan?at=2023-06-01T12:30:00Z", nil)
+	router.ServeHTTP(w, req)
+	assert.Equal(t, 200, w.Code)
+
+	var current RetrieveMemoriesResponse
+	assert.NoError(t, json.NewDecoder(w.Body).Decode(&current))
+	assert.Equal(t, 2, len(current.Memories))
+	assert.Equal(t, "have lunch", current.Memories[0].Metadata.Content)
+	assert.Equal(t, "play basketball", current.Memories[1].Metadata.Content)
+
+	// plan the next day, then plan the day again, the plans of the day are replaced
+	assert.Equal(t, 200, serve(router, "POST", "/m/"+id.Hex()+"/plan", strings.NewReader(`{"date":"2023-06-03T10:00:00Z", "depth":1}`)).Code)
+	assert.Equal(t, 200, serve(router, "POST", "/m/"+id.Hex()+"/plan", strings.NewReader(`{"date":"2023-06-01T10:00:00Z", "depth":2}`)).Code)
+	count, err := hs.vectors.Count(ctx, id.Hex(), &MemoryFilter{Types: []MemoryType{PlanMemory}})
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(4+2), count)
+	// the memories are summarized for planning
+	assert.Contains(t, agent, "aspirin is preparing for a basketball game.")
+
+	w = serve(router, "GET", "/m/"+id.Hex()+"/plan?at=2023-06-01T12:30:00Z", nil)
+	current = RetrieveMemoriesResponse{}
+	assert.NoError(t, json.NewDecoder(w.Body).Decode(&current))
+	assert.Equal(t, 2, len(current.Memories))
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/m/"+id.Hex()+"/plan?at=2023-06-02T12:30:00Z", nil)
+	router.ServeHTTP(w, req)
+	assert.Equal(t, 404, w.Code)
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("POST", "/m/"+primitive.NewObjectID().Hex()+"/plan", nil)
+	router.ServeHTTP(w, req)
+	assert.Equal(t, 404, w.Code)
+}
@@ -1,7 +1,39 @@
 package memo
 
-import "github.com/sashabaranov/go-openai"
+import (
+	_ "embed"
+	"fmt"
+
+	"github.com/BurntSushi/toml"
+)
+
+// defaultPrompts is embedded, so the server doesn't depend on the working directory
+//
+//go:embed prompts.toml
+var defaultPrompts string
 
 type promptsConfig struct {
-	ScoreImportance []openai.ChatCompletionMessage `toml:"score_importance"`
+	ScoreImportance   []Message `toml:"score_importance"`
+	ReflectQuestions  []Message `toml:"reflect_questions"`
+	ReflectInsights   []Message `toml:"reflect_insights"`
+	PlanDay           []Message `toml:"plan_day"`
+	PlanHours         []Message `toml:"plan_hours"`
+	PlanMinutes       []Message `toml:"plan_minutes"`
+	SummarizeMemories []Message `toml:"summarize_memories"`
+}
+
+// loadPrompts reads the prompts from the file, or the embedded ones if the path is empty
+func loadPrompts(path string) (promptsConfig, error) {
+	var prompts promptsConfig
+	if path == "" {
+		if _, err := toml.Decode(defaultPrompts, &prompts); err != nil {
+			return prompts, fmt.Errorf("can't parse the embedded prompts: %w", err)
+		}
+		return prompts, nil
+	}
+
+	if _, err := toml.DecodeFile(path, &prompts); err != nil {
+		return prompts, fmt.Errorf("can't load prompts file %s: %w", path, err)
+	}
+	return prompts, nil
 }
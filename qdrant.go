@@ -0,0 +1,326 @@
+package memo
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	pb "github.com/qdrant/go-client/qdrant"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// QdrantVectorStore stores the memories in qdrant, one collection for each session
+type QdrantVectorStore struct {
+	conn        *grpc.ClientConn
+	qdrant      pb.QdrantClient
+	collections pb.CollectionsClient
+	points      pb.PointsClient
+}
+
+func NewQdrantVectorStore(conn *grpc.ClientConn) *QdrantVectorStore {
+	return &QdrantVectorStore{
+		conn:        conn,
+		qdrant:      pb.NewQdrantClient(conn),
+		collections: pb.NewCollectionsClient(conn),
+		points:      pb.NewPointsClient(conn),
+	}
+}
+
+func (s *QdrantVectorStore) Ping(ctx context.Context) error {
+	_, err := s.qdrant.HealthCheck(ctx, &pb.HealthCheckRequest{})
+	return err
+}
+
+// Close the grpc connection
+func (s *QdrantVectorStore) Close(ctx context.Context) error {
+	return s.conn.Close()
+}
+
+// EnsureCollection makes sure the qdrant collection exists, if not create one,
+// the missing payload indexes are created for the existing collections too
+func (s *QdrantVectorStore) EnsureCollection(ctx context.Context, name string, dimension int) (created bool, err error) {
+	resp, err := s.collections.Get(ctx, &pb.GetCollectionInfoRequest{CollectionName: name})
+	// already created
+	if err == nil {
+		return false, s.createIndexes(ctx, name, resp.GetResult().GetPayloadSchema())
+	}
+
+	st, ok := status.FromError(err)
+	if !ok {
+		return false, err
+	}
+
+	// if collection not found, then create one
+	if st.Code() == codes.NotFound {
+		_, err = s.collections.Create(ctx, &pb.CreateCollection{
+			CollectionName: name,
+			VectorsConfig: &pb.VectorsConfig{
+				Config: &pb.VectorsConfig_Params{
+					Params: &pb.VectorParams{
+						Size:     uint64(dimension),
+						Distance: pb.Distance_Cosine,
+					},
+				},
+			},
+		})
+		if err != nil {
+			return false, err
+		}
+		return true, s.createIndexes(ctx, name, nil)
+	}
+
+	return false, err
+}
+
+// payloadIndexes are the payload fields used by the filters
+var payloadIndexes = map[string]pb.FieldType{
+	payloadType:       pb.FieldType_FieldTypeKeyword,
+	payloadImportance: pb.FieldType_FieldTypeInteger,
+	payloadCreatedAt:  pb.FieldType_FieldTypeInteger,
+	payloadStartAt:    pb.FieldType_FieldTypeInteger,
+	payloadEndAt:      pb.FieldType_FieldTypeInteger,
+}
+
+// createIndexes indexes the payload fields which are not in the schema yet,
+// so the filters don't need to scan all the points
+func (s *QdrantVectorStore) createIndexes(ctx context.Context, name string, schema map[string]*pb.PayloadSchemaInfo) error {
+	wait := true
+	for field, typ := range payloadIndexes {
+		if _, ok := schema[field]; ok {
+			continue
+		}
+
+		typ := typ
+		_, err := s.points.CreateFieldIndex(ctx, &pb.CreateFieldIndexCollection{
+			CollectionName: name,
+			Wait:           &wait,
+			FieldName:      field,
+			FieldType:      &typ,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *QdrantVectorStore) DeleteCollection(ctx context.Context, name string) (bool, error) {
+	resp, err := s.collections.Delete(ctx, &pb.DeleteCollection{CollectionName: name})
+	if err != nil {
+		return false, err
+	}
+	return resp.GetResult(), nil
+}
+
+func (s *QdrantVectorStore) Collections(ctx context.Context) ([]string, error) {
+	resp, err := s.collections.List(ctx, &pb.ListCollectionsRequest{})
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for _, c := range resp.GetCollections() {
+		names = append(names, c.GetName())
+	}
+	return names, nil
+}
+
+func (s *QdrantVectorStore) Upsert(ctx context.Context, collection string, memories []Memory) error {
+	var points []*pb.PointStruct
+	for _, m := range memories {
+		points = append(points, m.Point())
+	}
+
+	wait := true
+	_, err := s.points.Upsert(ctx, &pb.UpsertPoints{
+		CollectionName: collection,
+		Wait:           &wait,
+		Points:         points,
+	})
+	return err
+}
+
+func (s *QdrantVectorStore) Search(ctx context.Context, collection string, vector []float32, limit uint64, filter *MemoryFilter) ([]Memory, error) {
+	res, err := s.points.Search(ctx, &pb.SearchPoints{
+		CollectionName: collection,
+		Vector:         vector,
+		Filter:         filter.qdrant(),
+		Limit:          limit,
+		// will not include vectors
+		WithVectors: &pb.WithVectorsSelector{SelectorOptions: &pb.WithVectorsSelector_Enable{Enable: false}},
+		// will include metadata
+		WithPayload: &pb.WithPayloadSelector{SelectorOptions: &pb.WithPayloadSelector_Enable{Enable: true}},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// covert all points into memories
+	var memories []Memory
+	for _, r := range res.GetResult() {
+		memories = append(memories, MemoryFromScoredPoint(r))
+	}
+	return memories, nil
+}
+
+func (s *QdrantVectorStore) Scroll(ctx context.Context, collection string, filter *MemoryFilter, offset string, limit uint32) ([]Memory, string, error) {
+	var offsetId *pb.PointId
+	if offset != "" {
+		offsetId = &pb.PointId{PointIdOptions: &pb.PointId_Uuid{Uuid: offset}}
+	}
+
+	resp, err := s.points.Scroll(ctx, &pb.ScrollPoints{
+		CollectionName: collection,
+		Filter:         filter.qdrant(),
+		Offset:         offsetId,
+		Limit:          &limit,
+		WithPayload:    &pb.WithPayloadSelector{SelectorOptions: &pb.WithPayloadSelector_Enable{Enable: true}},
+	})
+	if err != nil {
+		return nil, "", err
+	}
+
+	var memories []Memory
+	for _, r := range resp.GetResult() {
+		memories = append(memories, MemoryFromRetrievedPoint(r))
+	}
+	return memories, resp.GetNextPageOffset().GetUuid(), nil
+}
+
+func (s *QdrantVectorStore) Touch(ctx context.Context, collection string, ids []string, at time.Time) error {
+	wait := true
+	_, err := s.points.SetPayload(ctx, &pb.SetPayloadPoints{
+		CollectionName: collection,
+		Wait:           &wait,
+		Payload: map[string]*pb.Value{
+			payloadAccessedAt: {Kind: &pb.Value_IntegerValue{IntegerValue: at.UnixMilli()}},
+		},
+		PointsSelector: &pb.PointsSelector{
+			PointsSelectorOneOf: &pb.PointsSelector_Points{Points: &pb.PointsIdsList{Ids: pointIDs(ids)}},
+		},
+	})
+	return err
+}
+
+func (s *QdrantVectorStore) Get(ctx context.Context, collection string, ids []string, withVectors bool) ([]Memory, error) {
+	resp, err := s.points.Get(ctx, &pb.GetPoints{
+		CollectionName: collection,
+		Ids:            pointIDs(ids),
+		WithVectors:    &pb.WithVectorsSelector{SelectorOptions: &pb.WithVectorsSelector_Enable{Enable: withVectors}},
+		WithPayload:    &pb.WithPayloadSelector{SelectorOptions: &pb.WithPayloadSelector_Enable{Enable: true}},
+	})
+	if err != nil {
+		return nil, qdrantError(err)
+	}
+
+	var memories []Memory
+	for _, r := range resp.GetResult() {
+		memories = append(memories, MemoryFromRetrievedPoint(r))
+	}
+	return memories, nil
+}
+
+func (s *QdrantVectorStore) Count(ctx context.Context, collection string, filter *MemoryFilter) (uint64, error) {
+	exact := true
+	resp, err := s.points.Count(ctx, &pb.CountPoints{
+		CollectionName: collection,
+		Filter:         filter.qdrant(),
+		Exact:          &exact,
+	})
+	if err != nil {
+		return 0, qdrantError(err)
+	}
+	return resp.GetResult().GetCount(), nil
+}
+
+// statsPageSize is the number of points scanned at once by Stats
+const statsPageSize = 1000
+
+// Stats scans the payloads needed by the statistics page by page,
+// since qdrant can't aggregate the payloads
+func (s *QdrantVectorStore) Stats(ctx context.Context, collection string) (*MemoryStats, error) {
+	info, err := s.collections.Get(ctx, &pb.GetCollectionInfoRequest{CollectionName: collection})
+	if err != nil {
+		return nil, qdrantError(err)
+	}
+
+	stats := newMemoryStats()
+	res := info.GetResult()
+	stats.Collection = CollectionInfo{
+		Status:    strings.ToLower(res.GetStatus().String()),
+		Points:    res.GetPointsCount(),
+		Vectors:   res.GetVectorsCount(),
+		Segments:  res.GetSegmentsCount(),
+		Dimension: int(res.GetConfig().GetParams().GetVectorsConfig().GetParams().GetSize()),
+	}
+
+	limit := uint32(statsPageSize)
+	var offset *pb.PointId
+	for {
+		resp, err := s.points.Scroll(ctx, &pb.ScrollPoints{
+			CollectionName: collection,
+			Offset:         offset,
+			Limit:          &limit,
+			WithPayload: &pb.WithPayloadSelector{SelectorOptions: &pb.WithPayloadSelector_Include{
+				Include: &pb.PayloadIncludeSelector{Fields: []string{payloadType, payloadImportance, payloadCreatedAt}},
+			}},
+		})
+		if err != nil {
+			return nil, qdrantError(err)
+		}
+
+		for _, r := range resp.GetResult() {
+			stats.add(ParseMetadata(r.GetPayload()))
+		}
+
+		if offset = resp.GetNextPageOffset(); offset == nil {
+			return stats, nil
+		}
+	}
+}
+
+func (s *QdrantVectorStore) Delete(ctx context.Context, collection string, ids []string) error {
+	return s.delete(ctx, collection, &pb.PointsSelector{
+		PointsSelectorOneOf: &pb.PointsSelector_Points{Points: &pb.PointsIdsList{Ids: pointIDs(ids)}},
+	})
+}
+
+func (s *QdrantVectorStore) DeleteByFilter(ctx context.Context, collection string, filter *MemoryFilter) error {
+	f := filter.qdrant()
+	// an empty filter matches all the points
+	if f == nil {
+		return ErrEmptyFilter
+	}
+
+	return s.delete(ctx, collection, &pb.PointsSelector{
+		PointsSelectorOneOf: &pb.PointsSelector_Filter{Filter: f},
+	})
+}
+
+func (s *QdrantVectorStore) delete(ctx context.Context, collection string, selector *pb.PointsSelector) error {
+	wait := true
+	_, err := s.points.Delete(ctx, &pb.DeletePoints{
+		CollectionName: collection,
+		Wait:           &wait,
+		Points:         selector,
+	})
+	return qdrantError(err)
+}
+
+// qdrantError converts the not found status into ErrCollectionNotFound, same as the in-memory store
+func qdrantError(err error) error {
+	if st, ok := status.FromError(err); ok && st.Code() == codes.NotFound {
+		return ErrCollectionNotFound
+	}
+	return err
+}
+
+func pointIDs(ids []string) []*pb.PointId {
+	points := make([]*pb.PointId, 0, len(ids))
+	for _, id := range ids {
+		points = append(points, &pb.PointId{PointIdOptions: &pb.PointId_Uuid{Uuid: id}})
+	}
+	return points
+}
@@ -0,0 +1,127 @@
+package memo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// reconcilePageSize is the number of sessions checked at once
+const reconcilePageSize = 100
+
+// ReconcileReport lists the ids of the inconsistent sessions found by the reconciler, and how they are fixed
+type ReconcileReport struct {
+	Creating            []string `json:"creating"`             // failed to be created, rolled back
+	Deleting            []string `json:"deleting"`             // failed to be deleted, deleted
+	MissingCollections  []string `json:"missing_collections"`  // active sessions without collection, the empty collections are created
+	OrphanedCollections []string `json:"orphaned_collections"` // collections named by the ids of no session, deleted
+}
+
+// Reconcile finds the sessions which are inconsistent between the session store and the vector store,
+// the ones changed within the grace period are skipped since they may be in flight, they are only fixed if fix is true,
+// the fixes which failed are returned together,
+// the collections named by the ids of other sessions are orphaned, so the vector store must not be shared when fixing
+func (hs *Handlers) Reconcile(ctx context.Context, grace time.Duration, fix bool) (*ReconcileReport, error) {
+	report := &ReconcileReport{}
+	now := time.Now()
+
+	collections, err := hs.vectors.Collections(ctx)
+	if err != nil {
+		return nil, err
+	}
+	exists := map[string]bool{}
+	for _, name := range collections {
+		exists[name] = true
+	}
+
+	var errs []error
+	failed := func(name string, err error) {
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", name, err))
+		}
+	}
+
+	sessions := map[string]bool{}
+	q := SessionQuery{All: true, Limit: reconcilePageSize}
+	for {
+		page, err := hs.sessions.List(ctx, q)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, sess := range page {
+			sid := sess.ID.Hex()
+			sessions[sid] = true
+
+			changed := sess.UpdatedAt
+			if changed == 0 {
+				changed = sess.CreatedAt
+			}
+			stale := now.Sub(changed.Time()) >= grace
+
+			switch {
+			case sess.State == SessionCreating && stale:
+				report.Creating = append(report.Creating, sid)
+				if fix {
+					failed(sid, hs.purgeSession(ctx, sess.ID, exists[sid]))
+				}
+			case sess.State == SessionDeleting && stale:
+				report.Deleting = append(report.Deleting, sid)
+				if fix {
+					failed(sid, hs.purgeSession(ctx, sess.ID, exists[sid]))
+				}
+			case sess.active() && !exists[sid]:
+				report.MissingCollections = append(report.MissingCollections, sid)
+				if fix {
+					_, err := hs.vectors.EnsureCollection(ctx, sid, hs.embedder.Dimension())
+					failed(sid, err)
+				}
+			case sess.active() && fix:
+				// the payload indexes added later are created for the existing collections
+				_, err := hs.vectors.EnsureCollection(ctx, sid, hs.embedder.Dimension())
+				failed(sid, err)
+			}
+		}
+
+		if len(page) < reconcilePageSize {
+			break
+		}
+		q.Offset = page[len(page)-1].ID
+	}
+
+	for _, name := range collections {
+		if sessions[name] {
+			continue
+		}
+		// not a collection of the sessions
+		id, err := primitive.ObjectIDFromHex(name)
+		if err != nil {
+			continue
+		}
+		// the session id is created right before the collection
+		if now.Sub(id.Timestamp()) < grace {
+			continue
+		}
+
+		report.OrphanedCollections = append(report.OrphanedCollections, name)
+		if fix {
+			_, err := hs.vectors.DeleteCollection(ctx, name)
+			failed(name, err)
+		}
+	}
+
+	return report, errors.Join(errs...)
+}
+
+// purgeSession deletes the collection if it exists, then the session
+func (hs *Handlers) purgeSession(ctx context.Context, sid primitive.ObjectID, collection bool) error {
+	if collection {
+		if _, err := hs.vectors.DeleteCollection(ctx, sid.Hex()); err != nil {
+			return err
+		}
+	}
+	return hs.sessions.Delete(ctx, sid)
+}
@@ -0,0 +1,202 @@
+package memo
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// failingVectorStore fails to create or delete the collections, or to index the created collections
+type failingVectorStore struct {
+	*InMemoryVectorStore
+	ensure, index, delete bool
+}
+
+func (s failingVectorStore) EnsureCollection(ctx context.Context, name string, dimension int) (bool, error) {
+	if s.ensure {
+		return false, errors.New("can't create collection")
+	}
+	created, err := s.InMemoryVectorStore.EnsureCollection(ctx, name, dimension)
+	if s.index && err == nil {
+		err = errors.New("can't create index")
+	}
+	return created, err
+}
+
+func (s failingVectorStore) DeleteCollection(ctx context.Context, name string) (bool, error) {
+	if s.delete {
+		return false, errors.New("can't delete collection")
+	}
+	return s.InMemoryVectorStore.DeleteCollection(ctx, name)
+}
+
+// ensuringVectorStore records the existing collections which are ensured
+type ensuringVectorStore struct {
+	*InMemoryVectorStore
+	ensured []string
+}
+
+func (s *ensuringVectorStore) EnsureCollection(ctx context.Context, name string, dimension int) (bool, error) {
+	created, err := s.InMemoryVectorStore.EnsureCollection(ctx, name, dimension)
+	if !created {
+		s.ensured = append(s.ensured, name)
+	}
+	return created, err
+}
+
+func TestSessionRollback(t *testing.T) {
+	hs := newTestHandlers(t)
+	router := newTestRouter(hs)
+
+	vectors := NewInMemoryVectorStore()
+	hs.vectors = failingVectorStore{InMemoryVectorStore: vectors, ensure: true}
+
+	// the document is removed if the collection can't be created
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/s/add", bytes.NewBufferString(`{"name":"aspirin2d"}`))
+	router.ServeHTTP(w, req)
+	assert.Equal(t, 500, w.Code)
+
+	all, err := hs.sessions.List(context.TODO(), SessionQuery{All: true})
+	assert.NoError(t, err)
+	assert.Zero(t, len(all))
+
+	// the collection is removed too if it's created, but can't be indexed
+	hs.vectors = failingVectorStore{InMemoryVectorStore: vectors, index: true}
+	assert.Equal(t, 500, serve(router, "POST", "/s/add", strings.NewReader(`{"name":"aspirin2d"}`)).Code)
+	all, err = hs.sessions.List(context.TODO(), SessionQuery{All: true})
+	assert.NoError(t, err)
+	assert.Zero(t, len(all))
+	names, err := vectors.Collections(context.TODO())
+	assert.NoError(t, err)
+	assert.Zero(t, len(names))
+
+	// the session is restored if the collection can't be deleted
+	hs.vectors = failingVectorStore{InMemoryVectorStore: vectors, delete: true}
+	sess := &Session{Name: "aspirin2d"}
+	sid, err := hs.createSession(context.TODO(), sess)
+	assert.NoError(t, err)
+	assert.Equal(t, SessionActive, sess.State)
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("DELETE", "/s/"+sid.Hex()+"/del", nil)
+	router.ServeHTTP(w, req)
+	assert.Equal(t, 500, w.Code)
+
+	got, err := hs.sessions.Get(context.TODO(), sid)
+	assert.NoError(t, err)
+	assert.Equal(t, SessionActive, got.State)
+
+	// a session stuck in deleting is hidden, but can be deleted again
+	assert.NoError(t, hs.sessions.SetState(context.TODO(), sid, SessionDeleting, time.Now()))
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/s/"+sid.Hex(), nil)
+	router.ServeHTTP(w, req)
+	assert.Equal(t, 404, w.Code)
+
+	hs.vectors = vectors
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("DELETE", "/s/"+sid.Hex()+"/del", nil)
+	router.ServeHTTP(w, req)
+	assert.Equal(t, 200, w.Code)
+
+	_, err = hs.sessions.Get(context.TODO(), sid)
+	assert.ErrorIs(t, err, ErrSessionNotFound)
+	names, err = vectors.Collections(context.TODO())
+	assert.NoError(t, err)
+	assert.Zero(t, len(names))
+}
+
+func TestReconcile(t *testing.T) {
+	ctx := context.TODO()
+	hs := newTestHandlers(t)
+	old := time.Now().Add(-time.Hour)
+	dim := hs.embedder.Dimension()
+
+	create := func(state SessionState, at time.Time, collection bool) string {
+		sess := &Session{Name: "aspirin2d", State: state, CreatedAt: primitive.NewDateTimeFromTime(at), UpdatedAt: primitive.NewDateTimeFromTime(at)}
+		sid, err := hs.sessions.Create(ctx, sess)
+		assert.NoError(t, err)
+		if collection {
+			_, err = hs.vectors.EnsureCollection(ctx, sid.Hex(), dim)
+			assert.NoError(t, err)
+		}
+		return sid.Hex()
+	}
+
+	active := create(SessionActive, old, true)
+	legacy := create("", old, true)
+	missing := create(SessionActive, old, false)
+	creating := create(SessionCreating, old, true)
+	deleting := create(SessionDeleting, old, false)
+	// in flight
+	create(SessionCreating, time.Now(), false)
+	create(SessionDeleting, time.Now(), true)
+
+	orphaned := primitive.NewObjectIDFromTimestamp(old).Hex()
+	_, err := hs.vectors.EnsureCollection(ctx, orphaned, dim)
+	assert.NoError(t, err)
+	_, err = hs.vectors.EnsureCollection(ctx, primitive.NewObjectID().Hex(), dim)
+	assert.NoError(t, err)
+	_, err = hs.vectors.EnsureCollection(ctx, "not-a-session", dim)
+	assert.NoError(t, err)
+
+	expected := &ReconcileReport{
+		Creating:            []string{creating},
+		Deleting:            []string{deleting},
+		MissingCollections:  []string{missing},
+		OrphanedCollections: []string{orphaned},
+	}
+
+	// nothing is changed without fix
+	report, err := hs.Reconcile(ctx, 10*time.Minute, false)
+	assert.NoError(t, err)
+	assert.Equal(t, expected, report)
+
+	report, err = hs.Reconcile(ctx, 10*time.Minute, true)
+	assert.NoError(t, err)
+	assert.Equal(t, expected, report)
+
+	// the created time given by the client doesn't make a new session stale
+	w := serve(newTestRouter(hs), "POST", "/s/add", strings.NewReader(`{"name":"bob", "created_at":"2020-01-01T00:00:00Z"}`))
+	assert.Equal(t, 200, w.Code)
+	var added SessionAddResponse
+	assert.NoError(t, json.NewDecoder(w.Body).Decode(&added))
+	sess, err := hs.sessions.Get(ctx, added.ID)
+	assert.NoError(t, err)
+	assert.WithinDuration(t, time.Now(), sess.UpdatedAt.Time(), time.Minute)
+	assert.NoError(t, hs.sessions.SetState(ctx, added.ID, SessionCreating, sess.UpdatedAt.Time()))
+
+	// everything is consistent now, the indexes of the existing collections are ensured
+	vectors := &ensuringVectorStore{InMemoryVectorStore: hs.vectors.(*InMemoryVectorStore)}
+	hs.vectors = vectors
+	report, err = hs.Reconcile(ctx, 10*time.Minute, true)
+	assert.NoError(t, err)
+	assert.Equal(t, &ReconcileReport{}, report)
+	assert.ElementsMatch(t, []string{active, legacy, missing}, vectors.ensured)
+	hs.vectors = vectors.InMemoryVectorStore
+
+	_, err = hs.sessions.Get(ctx, added.ID)
+	assert.NoError(t, err)
+
+	all, err := hs.sessions.List(ctx, SessionQuery{All: true})
+	assert.NoError(t, err)
+	assert.Equal(t, 6, len(all))
+
+	names, err := hs.vectors.Collections(ctx)
+	assert.NoError(t, err)
+	assert.NotContains(t, names, orphaned)
+	assert.NotContains(t, names, creating)
+	for _, sid := range []string{active, legacy, missing, "not-a-session"} {
+		assert.Contains(t, names, sid)
+	}
+}
@@ -0,0 +1,333 @@
+package memo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	defaultReflectThreshold = 150 // accumulated importance which triggers a reflection
+
+	reflectRecentLimit   = 100 // how many recent memories to ask questions about
+	reflectQuestionLimit = 3   // how many questions to ask
+	reflectEvidenceLimit = 10  // how many memories to retrieve for each question
+	reflectTimeout       = 2 * time.Minute
+
+	reflectWindow    = 1 * time.Hour              // the first window of the recent memories, doubled until it has enough of them
+	reflectMaxWindow = 100 * 365 * 24 * time.Hour // the memories older than it are never recent
+)
+
+var (
+	listPrefix = regexp.MustCompile(`^\s*(?:[-*•]|\d+\s*[.、:：)）])\s*`)
+	citation   = regexp.MustCompile(`[(（]([^()（）]*)[)）]\s*[。.]?\s*$`)
+	number     = regexp.MustCompile(`\d+`)
+)
+
+type ReflectResponse struct {
+	Questions []string `bson:"questions" json:"questions"` // the salient questions about recent memories
+	Memories  []Memory `bson:"memories" json:"memories"`   // the reflected insights
+}
+
+// insight is a parsed reflection with the indices (from 1) of its evidence
+type insight struct {
+	Content  string
+	Evidence []int
+}
+
+// @Summary		reflect memories
+// @Description	synthesize higher-level reflections from the session's recent memories
+// @Tags			memories
+// @Produce		json
+// @Param			session	path		string	true	"memory belonging to which session"
+// @Success		200	{object}	ReflectResponse
+// @Failure		default	{object}	APIError
+// @Security		ApiKeyAuth
+// @Router			/m/{session}/reflect [post]
+func (hs *Handlers) Reflect(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	// the session is read again, since the accumulated importance is reset atomically
+	now := time.Now()
+	sess, err := hs.sessions.ResetImportance(ctx, authorizedSession(c).ID, 0, now)
+	if err != nil {
+		sessionError(c, err)
+		return
+	}
+
+	res, err := hs.reflectOrRestore(ctx, sess, now)
+	if err != nil {
+		NewError(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, res)
+}
+
+// accumulateImportance adds the importance of new memories to the session,
+// and reflects in background when it reaches the threshold
+func (hs *Handlers) accumulateImportance(ctx context.Context, sid string, memories []Memory) {
+	if hs.ReflectThreshold <= 0 {
+		return
+	}
+
+	sum := 0
+	for _, m := range memories {
+		// reflections don't trigger other reflections
+		if m.Metadata.Type != ReflectionMemory {
+			sum += m.Metadata.Importance
+		}
+	}
+
+	id, err := primitive.ObjectIDFromHex(sid)
+	if sum == 0 || err != nil {
+		return
+	}
+
+	if err = hs.sessions.AddImportance(ctx, id, sum); err != nil {
+		if !errors.Is(err, ErrSessionNotFound) {
+			log.Println(err)
+		}
+		return
+	}
+
+	// only one of the concurrent requests can claim the reflection
+	now := time.Now()
+	sess, err := hs.sessions.ResetImportance(ctx, id, hs.ReflectThreshold, now)
+	if err != nil {
+		if !errors.Is(err, ErrSessionNotFound) {
+			log.Println(err)
+		}
+		return
+	}
+
+	hs.background.Add(1)
+	go func() {
+		defer hs.background.Done()
+
+		ctx, cancel := context.WithTimeout(context.Background(), reflectTimeout)
+		defer cancel()
+
+		if _, err := hs.reflectOrRestore(ctx, sess, now); err != nil {
+			log.Printf("can't reflect session %s: %v", sid, err)
+		}
+	}()
+}
+
+// reflectOrRestore reflects the session which is reset at the given time, or restores its accumulated importance
+// and reflected time if it fails, so the reflection is triggered again
+func (hs *Handlers) reflectOrRestore(ctx context.Context, sess *Session, at time.Time) (*ReflectResponse, error) {
+	res, err := hs.reflect(ctx, sess)
+	if err == nil {
+		return res, nil
+	}
+
+	// the request may be canceled
+	rctx, cancel := context.WithTimeout(context.Background(), rollbackTimeout)
+	defer cancel()
+	if err := hs.sessions.RestoreImportance(rctx, sess.ID, sess.AccImportance, sess.ReflectedAt, at); err != nil && !errors.Is(err, ErrSessionNotFound) {
+		log.Printf("can't restore the importance of session %s: %v", sess.ID.Hex(), err)
+	}
+	return nil, err
+}
+
+// reflect asks the salient questions about the memories since last reflection,
+// then stores the insights of each question as reflection memories
+func (hs *Handlers) reflect(ctx context.Context, sess *Session) (*ReflectResponse, error) {
+	sid := sess.ID.Hex()
+	res := &ReflectResponse{Questions: []string{}, Memories: []Memory{}}
+
+	var since time.Time
+	if sess.ReflectedAt != 0 {
+		since = sess.ReflectedAt.Time()
+	}
+
+	recent, err := hs.recentMemories(ctx, sid, since, reflectRecentLimit)
+	if err != nil {
+		return nil, err
+	}
+	if len(recent) == 0 {
+		return res, nil
+	}
+
+	var statements []string
+	for _, m := range recent {
+		statements = append(statements, m.Metadata.Content)
+	}
+
+	reply, err := hs.llm.complete(ctx, TaskReflection, hs.prompts.ReflectQuestions, strings.Join(statements, "\n"))
+	if err != nil {
+		return nil, err
+	}
+	res.Questions = parseQuestions(reply, reflectQuestionLimit)
+
+	var reflections []Memory
+	for _, q := range res.Questions {
+		evidence, err := hs.search(ctx, sid, SearchMemoryRequest{Query: q, Limit: reflectEvidenceLimit, Weights: &RetrievalWeights{}})
+		if err != nil {
+			return nil, err
+		}
+		if len(evidence) == 0 {
+			continue
+		}
+
+		reply, err := hs.llm.complete(ctx, TaskReflection, hs.prompts.ReflectInsights, numberStatements(q, evidence))
+		if err != nil {
+			return nil, err
+		}
+
+		for _, in := range parseInsights(reply) {
+			reflections = append(reflections, Memory{
+				Metadata: MemoryMetadata{
+					Type:     ReflectionMemory,
+					Content:  in.Content,
+					Evidence: evidenceIDs(in.Evidence, evidence),
+				},
+			})
+		}
+	}
+
+	if len(reflections) == 0 {
+		return res, nil
+	}
+
+	if _, err := hs.addMemories(ctx, sid, reflections, false); err != nil {
+		return nil, err
+	}
+
+	// the vectors are not returned
+	for i := range reflections {
+		reflections[i].Embedding = nil
+	}
+	res.Memories = reflections
+	return res, nil
+}
+
+// recentMemories returns the newest memories created after since (all of them if it's zero), at most limit,
+// the window is doubled from reflectWindow until it has enough memories, so the sessions never reflected
+// don't load all their memories, and only the newest ones are kept while scrolling the window
+func (hs *Handlers) recentMemories(ctx context.Context, sid string, since time.Time, limit int) ([]Memory, error) {
+	var filter *MemoryFilter
+	if !since.IsZero() {
+		filter = &MemoryFilter{CreatedAfter: &since}
+	}
+
+	total, err := hs.vectors.Count(ctx, sid, filter)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	for window := reflectWindow; total > uint64(limit) && window <= reflectMaxWindow; window *= 2 {
+		from := now.Add(-window)
+		if !from.After(since) {
+			break
+		}
+
+		count, err := hs.vectors.Count(ctx, sid, &MemoryFilter{CreatedAfter: &from})
+		if err != nil {
+			return nil, err
+		}
+		if count >= uint64(limit) {
+			filter = &MemoryFilter{CreatedAfter: &from}
+			break
+		}
+	}
+
+	var recent []Memory
+	err = hs.scrollPages(ctx, sid, filter, false, func(page []Memory) error {
+		recent = append(recent, page...)
+		// the most recent memories first
+		sort.SliceStable(recent, func(i, j int) bool {
+			return recent[i].Metadata.CreatedAt.After(recent[j].Metadata.CreatedAt)
+		})
+		if len(recent) > limit {
+			recent = recent[:limit]
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, ErrQdrantScroll
+	}
+	return recent, nil
+}
+
+// numberStatements lists the memories with their indices (from 1) under the question
+func numberStatements(question string, memories []Memory) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s\n", question)
+	for i, m := range memories {
+		fmt.Fprintf(&b, "%d. %s\n", i+1, m.Metadata.Content)
+	}
+	return b.String()
+}
+
+// parseQuestions reads one question per line, the list markers are removed
+func parseQuestions(reply string, limit int) []string {
+	questions := []string{}
+	for _, line := range strings.Split(reply, "\n") {
+		q := strings.TrimSpace(listPrefix.ReplaceAllString(line, ""))
+		if q == "" {
+			continue
+		}
+
+		questions = append(questions, q)
+		if len(questions) == limit {
+			break
+		}
+	}
+	return questions
+}
+
+// parseInsights reads one insight per line, with the cited statement numbers
+// at the end of line, e.g. "insight (because of 1, 5, 3)"
+func parseInsights(reply string) []insight {
+	var insights []insight
+	for _, line := range strings.Split(reply, "\n") {
+		line = strings.TrimSpace(listPrefix.ReplaceAllString(line, ""))
+		if line == "" {
+			continue
+		}
+
+		in := insight{Content: line}
+		if loc := citation.FindStringSubmatchIndex(line); loc != nil {
+			for _, n := range number.FindAllString(line[loc[2]:loc[3]], -1) {
+				if i, err := strconv.Atoi(n); err == nil {
+					in.Evidence = append(in.Evidence, i)
+				}
+			}
+			if len(in.Evidence) > 0 {
+				in.Content = strings.TrimSpace(line[:loc[0]])
+			}
+		}
+
+		if in.Content != "" {
+			insights = append(insights, in)
+		}
+	}
+	return insights
+}
+
+// evidenceIDs maps the cited indices (from 1) to memory ids, invalid or duplicated ones are ignored
+func evidenceIDs(indices []int, memories []Memory) []string {
+	var ids []string
+	seen := map[int]bool{}
+	for _, i := range indices {
+		if i < 1 || i > len(memories) || seen[i] {
+			continue
+		}
+		seen[i] = true
+		ids = append(ids, memories[i-1].ID)
+	}
+	return ids
+}
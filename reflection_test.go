@@ -0,0 +1,196 @@
+package memo
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	pb "github.com/qdrant/go-client/qdrant"
+	openai "github.com/sashabaranov/go-openai"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestParseQuestions(t *testing.T) {
+	reply := "1. 小明喜欢什么？\n\n2) 小明住在哪里？\n- 小明的朋友是谁？\n4、小明几岁？"
+	assert.Equal(t, []string{"小明喜欢什么？", "小明住在哪里？", "小明的朋友是谁？"}, parseQuestions(reply, 3))
+	assert.Equal(t, []string{}, parseQuestions("", 3))
+}
+
+func TestParseInsights(t *testing.T) {
+	reply := `1. 小明热爱运动（因为 1, 3）
+2. Klaus is dedicated to his research (because of 2, 5, 3).
+小明是一个学生
+小明喜欢 (篮球)`
+
+	insights := parseInsights(reply)
+	assert.Equal(t, 4, len(insights))
+	assert.Equal(t, insight{Content: "小明热爱运动", Evidence: []int{1, 3}}, insights[0])
+	assert.Equal(t, insight{Content: "Klaus is dedicated to his research", Evidence: []int{2, 5, 3}}, insights[1])
+	assert.Equal(t, insight{Content: "小明是一个学生"}, insights[2])
+	assert.Equal(t, insight{Content: "小明喜欢 (篮球)"}, insights[3])
+
+	memories := []Memory{{ID: "a"}, {ID: "b"}, {ID: "c"}}
+	assert.Equal(t, []string{"b", "a"}, evidenceIDs([]int{2, 1, 2, 0, 4}, memories))
+}
+
+func TestEvidencePayload(t *testing.T) {
+	md := MemoryMetadata{Type: ReflectionMemory, Content: "小明热爱运动", Evidence: []string{"a", "b"}}
+	m := MemoryFromRetrievedPoint(&pb.RetrievedPoint{Payload: md.Payload()})
+	assert.Equal(t, ReflectionMemory, m.Metadata.Type)
+	assert.Equal(t, []string{"a", "b"}, m.Metadata.Evidence)
+}
+
+func TestReflect(t *testing.T) {
+	hs := newTestHandlers(t)
+	hs.llm.provider = NewOpenAILLM(&StubClient{Dimension: 1536, Reply: func(messages []openai.ChatCompletionMessage) string {
+		switch messages[0].Content {
+		case hs.prompts.ReflectQuestions[0].Content:
+			return "1. what does aspirin like?"
+		case hs.prompts.ReflectInsights[0].Content:
+			return "1. aspirin loves sports (because of 1, 2)"
+		}
+		return stubScores(messages)
+	}}, "")
+
+	router := newTestRouter(hs)
+
+	ctx := context.TODO()
+	sess := &Session{Name: "aspirin"}
+	id, err := hs.sessions.Create(ctx, sess)
+	assert.NoError(t, err)
+	_, err = hs.vectors.EnsureCollection(ctx, id.Hex(), hs.embedder.Dimension())
+	assert.NoError(t, err)
+
+	_, err = hs.addMemories(ctx, id.Hex(), []Memory{
+		{Metadata: MemoryMetadata{Content: "aspirin likes playing basketball."}},
+		{Metadata: MemoryMetadata{Content: "aspirin likes swimming."}},
+	}, false)
+	assert.NoError(t, err)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/m/"+id.Hex()+"/reflect", nil)
+	router.ServeHTTP(w, req)
+	assert.Equal(t, 200, w.Code)
+	assert.NotContains(t, w.Body.String(), `"embedding"`)
+
+	var res ReflectResponse
+	assert.NoError(t, json.NewDecoder(w.Body).Decode(&res))
+	assert.Equal(t, []string{"what does aspirin like?"}, res.Questions)
+	assert.Equal(t, 1, len(res.Memories))
+	assert.Equal(t, "aspirin loves sports", res.Memories[0].Metadata.Content)
+	assert.Equal(t, ReflectionMemory, res.Memories[0].Metadata.Type)
+	assert.Equal(t, 2, len(res.Memories[0].Metadata.Evidence))
+
+	// nothing new since last reflection
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("POST", "/m/"+id.Hex()+"/reflect", nil)
+	router.ServeHTTP(w, req)
+	assert.Equal(t, 200, w.Code)
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("POST", "/m/123/reflect", nil)
+	router.ServeHTTP(w, req)
+	assert.Equal(t, 400, w.Code)
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("POST", "/m/"+primitive.NewObjectID().Hex()+"/reflect", nil)
+	router.ServeHTTP(w, req)
+	assert.Equal(t, 404, w.Code)
+}
+
+// scrollCounter counts the memories scrolled from the store
+type scrollCounter struct {
+	VectorStore
+	scrolled int
+}
+
+func (s *scrollCounter) Scroll(ctx context.Context, collection string, filter *MemoryFilter, offset string, limit uint32) ([]Memory, string, error) {
+	memories, next, err := s.VectorStore.Scroll(ctx, collection, filter, offset, limit)
+	s.scrolled += len(memories)
+	return memories, next, err
+}
+
+func TestRecentMemories(t *testing.T) {
+	hs := newTestHandlers(t)
+	ctx := context.TODO()
+
+	id, err := hs.sessions.Create(ctx, &Session{Name: "aspirin"})
+	assert.NoError(t, err)
+	_, err = hs.vectors.EnsureCollection(ctx, id.Hex(), hs.embedder.Dimension())
+	assert.NoError(t, err)
+
+	// a year of memories, one each day, then one each minute in the last hours
+	now := time.Now()
+	var memories []Memory
+	for i := 1; i <= 365; i++ {
+		memories = append(memories, Memory{Metadata: MemoryMetadata{Content: "old", Importance: 1, CreatedAt: now.AddDate(0, 0, -i)}})
+	}
+	for i := 0; i < 3*reflectRecentLimit/2; i++ {
+		memories = append(memories, Memory{Metadata: MemoryMetadata{Content: "new", Importance: 1, CreatedAt: now.Add(-time.Duration(i) * time.Minute)}})
+	}
+	_, err = hs.addMemories(ctx, id.Hex(), memories, true)
+	assert.NoError(t, err)
+
+	// the session is never reflected, only the window of the newest memories is scrolled
+	counter := &scrollCounter{VectorStore: hs.vectors}
+	hs.vectors = counter
+	recent, err := hs.recentMemories(ctx, id.Hex(), time.Time{}, reflectRecentLimit)
+	assert.NoError(t, err)
+	assert.Equal(t, reflectRecentLimit, len(recent))
+	assert.WithinDuration(t, now, recent[0].Metadata.CreatedAt, time.Millisecond)
+	assert.Equal(t, "new", recent[len(recent)-1].Metadata.Content)
+	assert.Less(t, counter.scrolled, 2*reflectRecentLimit)
+
+	// fewer memories than the limit since the last reflection
+	counter.scrolled = 0
+	recent, err = hs.recentMemories(ctx, id.Hex(), now.Add(-30*time.Minute), reflectRecentLimit)
+	assert.NoError(t, err)
+	assert.Equal(t, 31, len(recent))
+	assert.Equal(t, 31, counter.scrolled)
+
+	// the window is not needed if the limit covers all of them
+	recent, err = hs.recentMemories(ctx, id.Hex(), time.Time{}, len(memories))
+	assert.NoError(t, err)
+	assert.Equal(t, len(memories), len(recent))
+	assert.Equal(t, "old", recent[len(recent)-1].Metadata.Content)
+}
+
+func TestReflectFailed(t *testing.T) {
+	hs := newTestHandlers(t)
+	hs.ReflectThreshold = 10
+	ctx := context.TODO()
+
+	reflectedAt := primitive.NewDateTimeFromTime(time.Now().Add(-time.Hour))
+	id, err := hs.sessions.Create(ctx, &Session{Name: "aspirin", ReflectedAt: reflectedAt})
+	assert.NoError(t, err)
+	_, err = hs.vectors.EnsureCollection(ctx, id.Hex(), hs.embedder.Dimension())
+	assert.NoError(t, err)
+
+	_, err = hs.addMemories(ctx, id.Hex(), []Memory{
+		{Metadata: MemoryMetadata{Content: "aspirin likes swimming.", Importance: 4}},
+	}, false)
+	assert.NoError(t, err)
+	hs.accumulateImportance(ctx, id.Hex(), []Memory{{Metadata: MemoryMetadata{Importance: 4}}})
+
+	// the reflection fails, the accumulated importance and reflected time are restored
+	hs.llm.provider = &rateLimitedLLM{LLM: hs.llm.provider, limit: 100}
+	w := serve(newTestRouter(hs), "POST", "/m/"+id.Hex()+"/reflect", nil)
+	assert.Equal(t, 500, w.Code)
+
+	sess, err := hs.sessions.Get(ctx, id)
+	assert.NoError(t, err)
+	assert.Equal(t, 4, sess.AccImportance)
+	assert.Equal(t, reflectedAt, sess.ReflectedAt)
+
+	// so does the reflection in background, then it's triggered again
+	hs.accumulateImportance(ctx, id.Hex(), []Memory{{Metadata: MemoryMetadata{Importance: 6}}})
+	hs.background.Wait()
+	sess, err = hs.sessions.Get(ctx, id)
+	assert.NoError(t, err)
+	assert.Equal(t, 10, sess.AccImportance)
+	assert.Equal(t, reflectedAt, sess.ReflectedAt)
+}
@@ -0,0 +1,134 @@
+package memo
+
+import (
+	"context"
+	"math"
+	"sort"
+	"time"
+)
+
+const (
+	defaultRecencyDecay = 0.995 // recency decay factor per hour
+	defaultCandidates   = 100   // minimum candidates to rank
+	maxCandidates       = 1000  // maximum candidates to rank, the limit is not raised to rank more
+)
+
+// RetrievalWeights enables the generative agents retrieval, which ranks memories by
+// the weighted sum of recency, importance and relevance, see: https://arxiv.org/abs/2304.03442
+type RetrievalWeights struct {
+	Recency    float64 `bson:"recency" json:"recency" example:"1"`         // weight of the recency score
+	Importance float64 `bson:"importance" json:"importance" example:"1"`   // weight of the importance score
+	Relevance  float64 `bson:"relevance" json:"relevance" example:"1"`     // weight of the relevance score
+	Decay      float64 `bson:"decay" json:"decay" example:"0.995"`         // recency decay factor per hour since last accessed
+	Candidates int64   `bson:"candidates" json:"candidates" example:"100"` // how many similar memories to rank, at most 1000
+}
+
+// RetrievalScores are the normalized component scores of a retrieved memory
+type RetrievalScores struct {
+	Recency    float64 `bson:"recency" json:"recency"`
+	Importance float64 `bson:"importance" json:"importance"`
+	Relevance  float64 `bson:"relevance" json:"relevance"`
+}
+
+// withDefaults fills the unset fields of the weights
+func (w RetrievalWeights) withDefaults(limit int64) RetrievalWeights {
+	if w.Recency == 0 && w.Importance == 0 && w.Relevance == 0 {
+		w.Recency, w.Importance, w.Relevance = 1, 1, 1
+	}
+
+	if w.Decay <= 0 || w.Decay > 1 {
+		w.Decay = defaultRecencyDecay
+	}
+
+	if w.Candidates < limit {
+		w.Candidates = limit
+	}
+	if w.Candidates < defaultCandidates {
+		w.Candidates = defaultCandidates
+	}
+	if w.Candidates > maxCandidates {
+		w.Candidates = maxCandidates
+	}
+
+	return w
+}
+
+// rankMemories scores the candidates with the given weights, and returns the top k of them
+func rankMemories(candidates []Memory, w RetrievalWeights, now time.Time, k int) []Memory {
+	if len(candidates) == 0 {
+		return candidates
+	}
+
+	recency := make([]float64, len(candidates))
+	importance := make([]float64, len(candidates))
+	relevance := make([]float64, len(candidates))
+
+	for i, m := range candidates {
+		accessed := m.Metadata.AccessedAt
+		if accessed.IsZero() {
+			accessed = m.Metadata.CreatedAt
+		}
+
+		hours := now.Sub(accessed).Hours()
+		if hours < 0 {
+			hours = 0
+		}
+
+		recency[i] = math.Pow(w.Decay, hours)
+		importance[i] = float64(m.Metadata.Importance)
+		relevance[i] = float64(m.Score)
+	}
+
+	normalize(recency)
+	normalize(importance)
+	normalize(relevance)
+
+	ranked := make([]Memory, len(candidates))
+	for i, m := range candidates {
+		m.Scores = &RetrievalScores{
+			Recency:    recency[i],
+			Importance: importance[i],
+			Relevance:  relevance[i],
+		}
+		m.Score = float32(w.Recency*recency[i] + w.Importance*importance[i] + w.Relevance*relevance[i])
+		ranked[i] = m
+	}
+
+	sort.SliceStable(ranked, func(i, j int) bool { return ranked[i].Score > ranked[j].Score })
+
+	if k > 0 && len(ranked) > k {
+		ranked = ranked[:k]
+	}
+	return ranked
+}
+
+// normalize scales the values into [0, 1] with min-max normalization,
+// if all values are the same, they are all set to 1
+func normalize(values []float64) {
+	min, max := math.Inf(1), math.Inf(-1)
+	for _, v := range values {
+		min = math.Min(min, v)
+		max = math.Max(max, v)
+	}
+
+	for i, v := range values {
+		if max == min {
+			values[i] = 1
+		} else {
+			values[i] = (v - min) / (max - min)
+		}
+	}
+}
+
+// touch updates the last accessed time of the memories
+func (hs *Handlers) touch(ctx context.Context, collection string, memories []Memory, at time.Time) error {
+	if len(memories) == 0 {
+		return nil
+	}
+
+	var ids []string
+	for _, m := range memories {
+		ids = append(ids, m.ID)
+	}
+	return hs.vectors.Touch(ctx, collection, ids, at)
+}
@@ -0,0 +1,46 @@
+package memo
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRankMemories(t *testing.T) {
+	now := time.Date(2023, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	candidates := []Memory{
+		{ID: "relevant", Score: 0.9, Metadata: MemoryMetadata{Importance: 1, CreatedAt: now.Add(-48 * time.Hour)}},
+		{ID: "important", Score: 0.5, Metadata: MemoryMetadata{Importance: 10, CreatedAt: now.Add(-48 * time.Hour)}},
+		{ID: "recent", Score: 0.5, Metadata: MemoryMetadata{Importance: 1, CreatedAt: now.Add(-48 * time.Hour), AccessedAt: now}},
+	}
+
+	w := RetrievalWeights{}.withDefaults(2)
+	assert.Equal(t, 1.0, w.Recency)
+	assert.Equal(t, defaultRecencyDecay, w.Decay)
+	assert.Equal(t, int64(defaultCandidates), w.Candidates)
+	w = RetrievalWeights{Candidates: 1 << 40}.withDefaults(2)
+	assert.Equal(t, int64(maxCandidates), w.Candidates)
+
+	// only relevance
+	res := rankMemories(candidates, RetrievalWeights{Relevance: 1, Decay: 0.99}, now, 2)
+	assert.Equal(t, 2, len(res))
+	assert.Equal(t, "relevant", res[0].ID)
+	assert.Equal(t, 1.0, res[0].Scores.Relevance)
+	assert.Equal(t, 0.0, res[1].Scores.Relevance)
+
+	// only importance
+	res = rankMemories(candidates, RetrievalWeights{Importance: 1, Decay: 0.99}, now, 1)
+	assert.Equal(t, "important", res[0].ID)
+
+	// only recency, last accessed time has priority over created time
+	res = rankMemories(candidates, RetrievalWeights{Recency: 1, Decay: 0.99}, now, 0)
+	assert.Equal(t, 3, len(res))
+	assert.Equal(t, "recent", res[0].ID)
+	assert.Equal(t, 1.0, res[0].Scores.Recency)
+
+	// all the same
+	res = rankMemories(candidates[:1], w, now, 5)
+	assert.Equal(t, float32(3), res[0].Score)
+}
@@ -0,0 +1,58 @@
+package memo
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	openai "github.com/sashabaranov/go-openai"
+)
+
+const (
+	startupAttempts = 5               // how many times to try connecting the services on startup
+	startupBackoff  = 1 * time.Second // the first backoff, doubled after each failure
+	maxBackoff      = 30 * time.Second
+)
+
+// retry calls fn until it succeeds, the attempts run out or the context is done,
+// the backoff is doubled after each failure, and the last error is returned
+func retry(ctx context.Context, name string, attempts int, backoff time.Duration, fn func(ctx context.Context) error) error {
+	var err error
+	for i := 0; i < attempts; i++ {
+		if err = fn(ctx); err == nil {
+			return nil
+		}
+
+		if i == attempts-1 {
+			break
+		}
+		log.Printf("can't setup %s (attempt %d/%d), retry in %v: %v", name, i+1, attempts, backoff, err)
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+
+		backoff *= 2
+		if backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+	}
+	return err
+}
+
+// isRateLimited reports whether the provider rejects the request by its rate limit, so it can be retried later
+func isRateLimited(err error) bool {
+	var apiErr *openai.APIError
+	if errors.As(err, &apiErr) && apiErr.HTTPStatusCode == http.StatusTooManyRequests {
+		return true
+	}
+	var reqErr *openai.RequestError
+	if errors.As(err, &reqErr) && reqErr.HTTPStatusCode == http.StatusTooManyRequests {
+		return true
+	}
+	return errors.Is(err, ErrRateLimited)
+}
@@ -0,0 +1,38 @@
+package memo
+
+import "github.com/gin-gonic/gin"
+
+// RegisterRoutes mounts all the handlers on the router, e.g. an "/api/v1" group,
+// keep it in sync with the swagger annotations
+func RegisterRoutes(r gin.IRouter, hs *Handlers) {
+	r.GET("/healthz", hs.Healthz)
+	r.GET("/readyz", hs.Readyz)
+	r.GET("/metrics", hs.Metrics)
+
+	s := r.Group("/s", hs.Authenticate)
+	s.GET("", hs.GetSessions)
+	s.POST("/add", hs.AddSession)
+	s.POST("/import", hs.ImportSession)
+	s.GET("/:id", hs.GetSession)
+	s.PATCH("/:id", hs.UpdateSession)
+	s.GET("/:id/stats", hs.GetSessionStats)
+	s.GET("/:id/export", hs.ExportSession)
+	s.POST("/:id/fork", hs.ForkSession)
+	s.DELETE("/:id/del", hs.DeleteSession)
+
+	j := r.Group("/jobs", hs.Authenticate)
+	j.GET("/:id", hs.GetJob)
+
+	m := r.Group("/m/:session", hs.Authenticate, hs.AuthorizeSession)
+	m.GET("", hs.GetAllMemories)
+	m.POST("/add", hs.AddMemories)
+	m.POST("/search", hs.SearchMemories)
+	m.POST("/get", hs.GetMemories)
+	m.POST("/del", hs.DeleteMemories)
+	m.POST("/reflect", hs.Reflect)
+	m.POST("/plan", hs.Plan)
+	m.GET("/plan", hs.GetPlan)
+	m.GET("/:id", hs.GetMemory)
+	m.PATCH("/:id", hs.UpdateMemory)
+	m.DELETE("/:id", hs.DeleteMemory)
+}
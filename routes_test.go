@@ -0,0 +1,46 @@
+package memo
+
+import (
+	"encoding/json"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+// the swagger spec is generated by "make docs", it should agree with the router
+func TestRoutesMatchSpec(t *testing.T) {
+	data, err := os.ReadFile("docs/swagger.json")
+	assert.NoError(t, err)
+
+	var spec struct {
+		Paths map[string]map[string]json.RawMessage `json:"paths"`
+	}
+	assert.NoError(t, json.Unmarshal(data, &spec))
+
+	// "/m/{session}/{id}" -> "/m/:session/:id"
+	param := regexp.MustCompile(`{([^}]+)}`)
+	var documented []string
+	for path, methods := range spec.Paths {
+		for method := range methods {
+			documented = append(documented, strings.ToUpper(method)+" "+param.ReplaceAllString(path, ":$1"))
+		}
+	}
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	RegisterRoutes(router, &Handlers{})
+
+	var registered []string
+	for _, r := range router.Routes() {
+		registered = append(registered, r.Method+" "+r.Path)
+	}
+
+	sort.Strings(documented)
+	sort.Strings(registered)
+	assert.Equal(t, documented, registered)
+}
@@ -1,21 +1,18 @@
 package memo
 
 import (
+	"context"
 	"errors"
+	"fmt"
+	"log"
 	"net/http"
+	"sort"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
-	pb "github.com/qdrant/go-client/qdrant"
-	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
-	"go.mongodb.org/mongo-driver/mongo"
-	"go.mongodb.org/mongo-driver/mongo/options"
-)
-
-var (
-	errSessionNotFound = errors.New("Session Not Found")
 )
 
 // the agent session
@@ -24,8 +21,118 @@ type Session struct {
 	Name      string             `bson:"name" json:"name"`                                 // agent's name
 	Desc      string             `bson:"desc,omitempty" json:"desc,omitempty"`             // agent's description
 	CreatedAt primitive.DateTime `bson:"created_at,omitempty" json:"created_at,omitempty"` // auto-generated created time
+	UpdatedAt primitive.DateTime `bson:"updated_at,omitempty" json:"updated_at,omitempty"` // last updated time
+	Owner     string             `bson:"owner" json:"owner,omitempty"`                     // tenant which owns the session, set by the api key
+	State     SessionState       `bson:"state,omitempty" json:"state,omitempty"`           // lifecycle state, set by the server
+
+	Tags       []string          `bson:"tags,omitempty" json:"tags,omitempty"`             // for filtering the sessions
+	Attributes map[string]string `bson:"attributes,omitempty" json:"attributes,omitempty"` // free-form traits, e.g. persona, location or current status
+
+	AccImportance int                `bson:"acc_importance" json:"acc_importance"`                 // accumulated importance since last reflection
+	ReflectedAt   primitive.DateTime `bson:"reflected_at,omitempty" json:"reflected_at,omitempty"` // last reflected time
+}
+
+// SessionState is the lifecycle of a session, which keeps the mongodb document and the qdrant collection consistent
+type SessionState string
+
+const (
+	SessionCreating SessionState = "creating" // the document is inserted, the collection may not be created
+	SessionActive   SessionState = "active"   // both the document and the collection exist
+	SessionDeleting SessionState = "deleting" // the collection may be deleted, the document is going to be deleted
+)
+
+// active reports whether the session can be used, the sessions before the state was introduced are active
+func (s *Session) active() bool {
+	return s.State == SessionActive || s.State == ""
+}
+
+// describe the agent for prompts, with its attributes one per line
+func (s *Session) describe() string {
+	var b strings.Builder
+	b.WriteString(s.Name)
+	if s.Desc != "" {
+		b.WriteString(": " + s.Desc)
+	}
+
+	keys := make([]string, 0, len(s.Attributes))
+	for k := range s.Attributes {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		b.WriteString("\n" + k + ": " + s.Attributes[k])
+	}
+	return b.String()
+}
+
+// AddSessionRequest is the session to be created, the other fields are set by the server
+type AddSessionRequest struct {
+	Name       string             `bson:"name" json:"name"`                                 // agent's name
+	Desc       string             `bson:"desc,omitempty" json:"desc,omitempty"`             // agent's description
+	CreatedAt  primitive.DateTime `bson:"created_at,omitempty" json:"created_at,omitempty"` // created time, now if it's not given
+	Tags       []string           `bson:"tags,omitempty" json:"tags,omitempty"`             // for filtering the sessions
+	Attributes map[string]string  `bson:"attributes,omitempty" json:"attributes,omitempty"` // free-form traits, e.g. persona, location or current status
+}
+
+// UpdateSessionRequest changes the given fields of the session, the others are kept,
+// the attributes are merged into the existing ones, and a null attribute is removed
+type UpdateSessionRequest struct {
+	Name       *string            `bson:"name,omitempty" json:"name,omitempty"`
+	Desc       *string            `bson:"desc,omitempty" json:"desc,omitempty"`
+	Tags       *[]string          `bson:"tags,omitempty" json:"tags,omitempty"` // replaces all the tags
+	Attributes map[string]*string `bson:"attributes,omitempty" json:"attributes,omitempty"`
+}
+
+func (r *UpdateSessionRequest) validate() error {
+	if r.Name != nil && *r.Name == "" {
+		return ErrEmptySessionName
+	}
+	if r.Tags != nil {
+		if err := validateTags(*r.Tags); err != nil {
+			return err
+		}
+	}
+	for k := range r.Attributes {
+		if err := validateAttribute(k); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// validate the tags and attributes of a new session
+func (s *Session) validate() error {
+	if err := validateTags(s.Tags); err != nil {
+		return err
+	}
+	for k := range s.Attributes {
+		if err := validateAttribute(k); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func validateTags(tags []string) error {
+	for _, t := range tags {
+		if strings.TrimSpace(t) == "" {
+			return ErrInvalidTag
+		}
+	}
+	return nil
 }
 
+// validateAttribute rejects the keys which can't be a mongodb field name
+func validateAttribute(key string) error {
+	if key == "" || strings.Contains(key, ".") || strings.HasPrefix(key, "$") {
+		return fmt.Errorf("%w: %q", ErrInvalidAttribute, key)
+	}
+	return nil
+}
+
+// rollbackTimeout limits the compensation of a failed creation or deletion
+const rollbackTimeout = 10 * time.Second
+
 type OK struct {
 	OK bool `json:"ok" bson:"ok"`
 }
@@ -40,8 +147,10 @@ type SessionAddResponse struct {
 // @Produce		json
 // @Param			offset	query		string	false	"pagination offset id"
 // @Param			limit	query		int		false	"pagination limit, default is 5"
+// @Param			tag		query		[]string	false	"only the sessions with all the tags, repeated or separated by commas"	collectionFormat(multi)
 // @Success		200		{array}		Session
 // @Failure		default		{object}	APIError
+// @Security		ApiKeyAuth
 // @Router			/s [get]
 func (h *Handlers) GetSessions(c *gin.Context) {
 	ctx := c.Request.Context()
@@ -49,7 +158,7 @@ func (h *Handlers) GetSessions(c *gin.Context) {
 	offset := c.Query("offset")
 	limit := c.Query("limit")
 
-	filter := bson.M{}
+	q := SessionQuery{Owner: tenant(c), Limit: h.SearchLimit}
 
 	// set search offset id
 	if offset != "" {
@@ -58,39 +167,27 @@ func (h *Handlers) GetSessions(c *gin.Context) {
 			NewError(c, http.StatusBadRequest, err)
 			return
 		}
-		filter["_id"] = bson.M{"$lt": o}
+		q.Offset = o
 	}
 
 	// set search limit
-	l := h.SearchLimit
 	if limit != "" {
 		li, err := strconv.Atoi(limit)
 		if err != nil {
 			NewError(c, http.StatusBadRequest, err)
 			return
 		}
-		l = int64(li)
+		q.Limit = int64(li)
 	}
 
-	opts := options.Find().SetSort(bson.M{"_id": -1}).SetLimit(l)
+	q.Tags = queryList(c, "tag")
 
-	cur, err := h.sessions.Find(ctx, filter, opts)
+	results, err := h.sessions.List(ctx, q)
 	if err != nil {
-		NewError(c, http.StatusNotFound, err)
-		return
-	}
-
-	var results []Session
-
-	if err = cur.All(ctx, &results); err != nil {
 		NewError(c, http.StatusInternalServerError, err)
 		return
 	}
 
-	if results == nil {
-		results = []Session{}
-	}
-
 	c.JSON(http.StatusOK, results)
 }
 
@@ -99,50 +196,113 @@ func (h *Handlers) GetSessions(c *gin.Context) {
 // @Tags			sessions
 // @Accept			json
 // @Produce		json
-// @Param			session	body		Session	true	"the session to be created"
-// @Success		200		{object}	mongo.InsertOneResult
+// @Param			session	body		AddSessionRequest	true	"the session to be created"
+// @Success		200		{object}	SessionAddResponse
 // @Failure		default	{object}	APIError
-// @Router			/s/add [put]
+// @Security		ApiKeyAuth
+// @Router			/s/add [post]
 func (h *Handlers) AddSession(c *gin.Context) {
 	ctx := c.Request.Context()
 
-	var p Session
-	err := c.ShouldBindJSON(&p)
+	var req AddSessionRequest
+	err := c.ShouldBindJSON(&req)
 	if err != nil {
 		NewError(c, http.StatusInternalServerError, err)
 		return
 	}
 
+	// the id, state and reflection of the session are set by the server, and it always belongs to the tenant
+	p := Session{
+		Name:       req.Name,
+		Desc:       req.Desc,
+		CreatedAt:  req.CreatedAt,
+		Owner:      tenant(c),
+		Tags:       req.Tags,
+		Attributes: req.Attributes,
+	}
+	if err := p.validate(); err != nil {
+		NewError(c, http.StatusBadRequest, err)
+		return
+	}
+
 	// set create time
 	if p.CreatedAt == 0 {
 		p.CreatedAt = primitive.NewDateTimeFromTime(time.Now())
 	}
 
-	// insert the session
-	res, err := h.sessions.InsertOne(
-		ctx,
-		p,
-	)
-
+	sid, err := h.createSession(ctx, &p)
 	if err != nil {
 		NewError(c, http.StatusInternalServerError, err)
 		return
 	}
 
-	sid, ok := res.InsertedID.(primitive.ObjectID)
-	if !ok {
-		NewError(c, http.StatusBadRequest, ErrInvalidID)
-		return
+	c.JSON(http.StatusOK, SessionAddResponse{ID: sid})
+}
+
+// createSession inserts the session as creating, creates its collection, then activates it,
+// the finished steps are rolled back if any of them fails
+func (h *Handlers) createSession(ctx context.Context, sess *Session) (primitive.ObjectID, error) {
+	sid, err := h.prepareSession(ctx, sess)
+	if err != nil {
+		return primitive.NilObjectID, err
+	}
+	if err := h.activateSession(ctx, sid, sess); err != nil {
+		return primitive.NilObjectID, err
 	}
+	return sid, nil
+}
 
-	// create qdrant collection
-	_, err = h.EnsureQCollection(ctx, sid.Hex())
+// prepareSession inserts the session as creating, and creates its collection,
+// so it can be filled before it's activated, e.g. by import and fork, it's rolled back if any step fails
+func (h *Handlers) prepareSession(ctx context.Context, sess *Session) (primitive.ObjectID, error) {
+	// the reconciler measures the grace period from it, the created time may be given by the client
+	sess.State = SessionCreating
+	sess.UpdatedAt = primitive.NewDateTimeFromTime(time.Now())
+	sid, err := h.sessions.Create(ctx, sess)
 	if err != nil {
-		NewError(c, http.StatusInternalServerError, err)
-		return
+		return primitive.NilObjectID, err
 	}
 
-	c.JSON(http.StatusOK, SessionAddResponse{ID: sid})
+	// create the collection with the embedder's dimension
+	created, err := h.vectors.EnsureCollection(ctx, sid.Hex(), h.embedder.Dimension())
+	if err != nil {
+		// the collection may be created before the indexes fail
+		h.rollback(sid, created)
+		return primitive.NilObjectID, err
+	}
+	return sid, nil
+}
+
+// renewSession keeps the session being filled from being stale, so the reconciler doesn't roll it back
+func (h *Handlers) renewSession(ctx context.Context, sid primitive.ObjectID) error {
+	return h.sessions.SetState(ctx, sid, SessionCreating, time.Now())
+}
+
+// activateSession makes the prepared session visible, it's rolled back with its collection if it fails
+func (h *Handlers) activateSession(ctx context.Context, sid primitive.ObjectID, sess *Session) error {
+	if err := h.sessions.SetState(ctx, sid, SessionActive, time.Now()); err != nil {
+		h.rollback(sid, true)
+		return err
+	}
+	sess.State = SessionActive
+	return nil
+}
+
+// rollback removes the session which failed to be created, the request may be canceled,
+// so it's done with a new context, and left to the reconciler if it fails again
+func (h *Handlers) rollback(sid primitive.ObjectID, collection bool) {
+	ctx, cancel := context.WithTimeout(context.Background(), rollbackTimeout)
+	defer cancel()
+
+	if collection {
+		if _, err := h.vectors.DeleteCollection(ctx, sid.Hex()); err != nil {
+			log.Printf("can't roll back the collection of session %s: %v", sid.Hex(), err)
+			return
+		}
+	}
+	if err := h.sessions.Delete(ctx, sid); err != nil {
+		log.Printf("can't roll back session %s: %v", sid.Hex(), err)
+	}
 }
 
 // @Summary		get one session by id
@@ -153,82 +313,167 @@ func (h *Handlers) AddSession(c *gin.Context) {
 // @Param			id	path		string	true	"the session to get"
 // @Success		200	{object}	Session
 // @Failure		default	{object}	APIError
-// @Router			/s/:id [get]
+// @Security		ApiKeyAuth
+// @Router			/s/{id} [get]
 func (h *Handlers) GetSession(c *gin.Context) {
 	ctx := c.Request.Context()
 
-	pid := c.Param("id")
-	sid, err := primitive.ObjectIDFromHex(pid)
+	// find the session
+	sess, err := h.findSession(ctx, tenant(c), c.Param("id"))
 	if err != nil {
-		NewError(c, http.StatusBadRequest, err)
+		sessionError(c, err)
 		return
 	}
-	// find the session
-	res := h.sessions.FindOne(
-		ctx,
-		bson.M{"_id": sid},
-	)
 
-	err = res.Err()
+	c.JSON(http.StatusOK, sess)
+}
+
+// @Summary		update one session
+// @Description	change the given fields of the session, the attributes are merged and the null ones are removed
+// @Tags			sessions
+// @Accept			json
+// @Produce		json
+// @Param			id		path		string					true	"the session to update"
+// @Param			session	body		UpdateSessionRequest	true	"the fields to update"
+// @Success		200		{object}	Session
+// @Failure		default	{object}	APIError
+// @Security		ApiKeyAuth
+// @Router			/s/{id} [patch]
+func (h *Handlers) UpdateSession(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	sess, err := h.findSession(ctx, tenant(c), c.Param("id"))
 	if err != nil {
-		if err == mongo.ErrNoDocuments {
-			NewError(c, http.StatusNotFound, errSessionNotFound)
-			return
-		} else {
-			NewError(c, http.StatusInternalServerError, err)
-			return
-		}
+		sessionError(c, err)
+		return
 	}
 
-	var sess Session
-	err = res.Decode(&sess)
+	var req UpdateSessionRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		NewError(c, http.StatusBadRequest, err)
+		return
+	}
+	if err := req.validate(); err != nil {
+		NewError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	updated, err := h.sessions.Update(ctx, sess.ID, &req, time.Now())
 	if err != nil {
-		NewError(c, http.StatusInternalServerError, err)
+		sessionError(c, err)
 		return
 	}
 
-	c.JSON(http.StatusOK, sess)
+	c.JSON(http.StatusOK, updated)
 }
 
 // @Summary		remove one session
-// @Description	remove one sessions
+// @Description	remove one sessions, 409 if it's being created
 // @Tags			sessions
 // @Accept			json
 // @Produce		json
 // @Param			id	path		string	true	"the session to delete"
 // @Success		200	{object}	OK
 // @Failure		default	{object}	APIError
-// @Router			/s/:id/del [delete]
+// @Security		ApiKeyAuth
+// @Router			/s/{id}/del [delete]
 func (h *Handlers) DeleteSession(c *gin.Context) {
 	ctx := c.Request.Context()
 
-	pid := c.Param("id")
-	sid, err := primitive.ObjectIDFromHex(pid)
+	// only the owner can delete the session, the ones failed to be deleted can be deleted again,
+	// the ones being created may be filled by import or fork, the failed ones are removed by the reconciler
+	sess, err := h.ownedSession(ctx, tenant(c), c.Param("id"))
+	if err == nil && sess.State == SessionCreating {
+		err = ErrSessionCreating
+	}
 	if err != nil {
-		NewError(c, http.StatusBadRequest, err)
+		sessionError(c, err)
 		return
 	}
-	// delete the session
-	res := h.sessions.FindOneAndDelete(
-		ctx,
-		bson.M{"_id": sid},
-	)
-	if err = res.Err(); err != nil {
-		// if session not exist, return 404
-		if err == mongo.ErrNoDocuments {
-			NewError(c, http.StatusNotFound, errSessionNotFound)
-			return
-		}
-		NewError(c, http.StatusBadRequest, err)
+
+	ok, err := h.deleteSession(ctx, sess)
+	if err != nil {
+		sessionError(c, err)
 		return
 	}
 
+	c.JSON(http.StatusOK, OK{OK: ok})
+}
+
+// deleteSession marks the session as deleting, deletes its collection, then the document,
+// the session is restored if its collection can't be deleted
+func (h *Handlers) deleteSession(ctx context.Context, sess *Session) (bool, error) {
+	sid := sess.ID
+	if err := h.sessions.SetState(ctx, sid, SessionDeleting, time.Now()); err != nil {
+		return false, err
+	}
+
 	// delete the memories from qdrant
-	resp, err := h.qCollections.Delete(ctx, &pb.DeleteCollection{CollectionName: sid.Hex()})
+	ok, err := h.vectors.DeleteCollection(ctx, sid.Hex())
 	if err != nil {
-		NewError(c, http.StatusInternalServerError, err)
-		return
+		if sess.active() {
+			h.restore(sid)
+		}
+		return false, err
+	}
+
+	// the collection is gone, the reconciler deletes the document if it fails here
+	if err := h.sessions.Delete(ctx, sid); err != nil {
+		return false, err
+	}
+	return ok, nil
+}
+
+// restore activates the session which failed to be deleted
+func (h *Handlers) restore(sid primitive.ObjectID) {
+	ctx, cancel := context.WithTimeout(context.Background(), rollbackTimeout)
+	defer cancel()
+
+	if err := h.sessions.SetState(ctx, sid, SessionActive, time.Now()); err != nil {
+		log.Printf("can't restore session %s: %v", sid.Hex(), err)
+	}
+}
+
+// findSession gets the active session by its hex id,
+// the sessions of other owners, or being created or deleted are not found
+func (h *Handlers) findSession(ctx context.Context, owner string, id string) (*Session, error) {
+	sess, err := h.ownedSession(ctx, owner, id)
+	if err != nil {
+		return nil, err
 	}
+	if !sess.active() {
+		return nil, ErrSessionNotFound
+	}
+	return sess, nil
+}
 
-	c.JSON(http.StatusOK, OK{OK: resp.Result})
+// ownedSession gets the session of the owner in any state
+func (h *Handlers) ownedSession(ctx context.Context, owner string, id string) (*Session, error) {
+	sid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, ErrInvalidID
+	}
+
+	sess, err := h.sessions.Get(ctx, sid)
+	if err != nil {
+		return nil, err
+	}
+	if sess.Owner != owner {
+		return nil, ErrSessionNotFound
+	}
+	return sess, nil
+}
+
+// sessionError sends the error of finding session with proper status code
+func sessionError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, ErrInvalidID):
+		NewError(c, http.StatusBadRequest, err)
+	case errors.Is(err, ErrSessionNotFound):
+		NewError(c, http.StatusNotFound, err)
+	case errors.Is(err, ErrSessionCreating):
+		NewError(c, http.StatusConflict, err)
+	default:
+		NewError(c, http.StatusInternalServerError, err)
+	}
 }
@@ -6,8 +6,9 @@ import (
 	"encoding/json"
 	"net/http"
 	"net/http/httptest"
-	"os"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/stretchr/testify/assert"
@@ -22,22 +23,14 @@ type SessionTestSuite struct {
 }
 
 func (s *SessionTestSuite) SetupSuite() {
-	os.Setenv("MONGO_SESSIONS", "test_sessions")
-
-	s.router = gin.New()
-	hs := Default()
-	s.hs = hs
-
-	s.router.GET("/s", s.hs.GetSessions)
-	s.router.PUT("/s/add", s.hs.AddSession)
-	s.router.DELETE("/s/:id/del", s.hs.DeleteSession)
-	s.router.GET("/s/:id", s.hs.GetSession)
+	s.hs = newTestHandlers(s.T())
+	s.router = newTestRouter(s.hs)
 }
 
 func (s *SessionTestSuite) TearDownTest() {
 	// clear testing data here
-	err := s.hs.sessions.Drop(context.TODO())
-	assert.NoError(s.T(), err)
+	s.hs.sessions = NewInMemorySessionStore()
+	s.hs.vectors = NewInMemoryVectorStore()
 }
 
 func (s *SessionTestSuite) TestSession() {
@@ -57,7 +50,7 @@ func (s *SessionTestSuite) TestSession() {
 	for i := 0; i < 15; i++ {
 		w := httptest.NewRecorder()
 		jsonStr := []byte(`{"name":"aspirin2d", "tags":["hello", "world"]}`)
-		req, _ = http.NewRequest("PUT", "/s/add", bytes.NewBuffer(jsonStr))
+		req, _ = http.NewRequest("POST", "/s/add", bytes.NewBuffer(jsonStr))
 		s.router.ServeHTTP(w, req)
 		assert.Equal(t, 200, w.Code)
 
@@ -132,7 +125,7 @@ func (s *SessionTestSuite) TestSession() {
 
 	w = httptest.NewRecorder()
 	jsonStr := []byte(`{"name":"aspirin2ds", "desc":"Aspirin2ds is a girl."}`)
-	req, _ = http.NewRequest("PUT", "/s/add", bytes.NewBuffer(jsonStr))
+	req, _ = http.NewRequest("POST", "/s/add", bytes.NewBuffer(jsonStr))
 	s.router.ServeHTTP(w, req)
 	assert.Equal(t, 200, w.Code)
 
@@ -159,12 +152,97 @@ func (s *SessionTestSuite) TestSession() {
 	s.router.ServeHTTP(w, req)
 	assert.Equal(t, 404, w.Code)
 
+	// the session being created can't be deleted
+	assert.NoError(t, s.hs.sessions.SetState(context.TODO(), ir.ID, SessionCreating, time.Now()))
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("DELETE", "/s/"+ir.ID.Hex()+"/del", nil)
+	s.router.ServeHTTP(w, req)
+	assert.Equal(t, 409, w.Code)
+
+	assert.NoError(t, s.hs.sessions.SetState(context.TODO(), ir.ID, SessionActive, time.Now()))
 	w = httptest.NewRecorder()
 	req, _ = http.NewRequest("DELETE", "/s/"+ir.ID.Hex()+"/del", nil)
 	s.router.ServeHTTP(w, req)
 	assert.Equal(t, 200, w.Code)
 }
 
+func (s *SessionTestSuite) TestUpdateSession() {
+	t := s.T()
+	w := serve(s.router, "POST", "/s/add", strings.NewReader(`{"name":"aspirin2d", "tags":["hello", "world"], "attributes":{"location":"home"}}`))
+	assert.Equal(t, 200, w.Code)
+	var ir SessionAddResponse
+	assert.NoError(t, json.NewDecoder(w.Body).Decode(&ir))
+	id := ir.ID.Hex()
+
+	w = serve(s.router, "POST", "/s/add", strings.NewReader(`{"name":"bob", "tags":["hello"]}`))
+	assert.Equal(t, 200, w.Code)
+
+	// filter by tags
+	var p []Session
+	w = serve(s.router, "GET", "/s?tag=hello", nil)
+	assert.Equal(t, 200, w.Code)
+	json.NewDecoder(w.Body).Decode(&p)
+	assert.Equal(t, 2, len(p))
+
+	w = serve(s.router, "GET", "/s?tag=hello,world", nil)
+	assert.Equal(t, 200, w.Code)
+	json.NewDecoder(w.Body).Decode(&p)
+	assert.Equal(t, 1, len(p))
+	assert.Equal(t, "aspirin2d", p[0].Name)
+	assert.Equal(t, "home", p[0].Attributes["location"])
+	assert.Equal(t, p[0].CreatedAt, p[0].UpdatedAt)
+
+	// merge the attributes, remove the null ones, and replace the tags
+	w = serve(s.router, "PATCH", "/s/"+id, strings.NewReader(`{"desc":"a girl", "tags":["world"], "attributes":{"location":null, "status":"sleeping"}}`))
+	assert.Equal(t, 200, w.Code)
+	var sess Session
+	assert.NoError(t, json.NewDecoder(w.Body).Decode(&sess))
+	assert.Equal(t, "aspirin2d", sess.Name)
+	assert.Equal(t, "a girl", sess.Desc)
+	assert.Equal(t, []string{"world"}, sess.Tags)
+	assert.Equal(t, map[string]string{"status": "sleeping"}, sess.Attributes)
+	assert.True(t, sess.UpdatedAt >= sess.CreatedAt)
+	assert.Equal(t, "aspirin2d: a girl\nstatus: sleeping", sess.describe())
+
+	w = serve(s.router, "GET", "/s?tag=hello", nil)
+	json.NewDecoder(w.Body).Decode(&p)
+	assert.Equal(t, 1, len(p))
+	assert.Equal(t, "bob", p[0].Name)
+
+	// invalid updates
+	assert.Equal(t, 400, serve(s.router, "PATCH", "/s/"+id, strings.NewReader(`{"name":""}`)).Code)
+	assert.Equal(t, 400, serve(s.router, "PATCH", "/s/"+id, strings.NewReader(`{"tags":[" "]}`)).Code)
+	assert.Equal(t, 400, serve(s.router, "PATCH", "/s/"+id, strings.NewReader(`{"attributes":{"a.b":"c"}}`)).Code)
+	assert.Equal(t, 400, serve(s.router, "PATCH", "/s/"+id, strings.NewReader(`{"attributes":{"$set":"c"}}`)).Code)
+	assert.Equal(t, 400, serve(s.router, "POST", "/s/add", strings.NewReader(`{"name":"bob", "attributes":{"a.b":"c"}}`)).Code)
+	assert.Equal(t, 400, serve(s.router, "PATCH", "/s/"+id, strings.NewReader(`{"name":`)).Code)
+	assert.Equal(t, 400, serve(s.router, "PATCH", "/s/123", strings.NewReader(`{}`)).Code)
+	assert.Equal(t, 404, serve(s.router, "PATCH", "/s/"+primitive.NewObjectID().Hex(), strings.NewReader(`{}`)).Code)
+}
+
+func (s *SessionTestSuite) TestAddSessionServerFields() {
+	t := s.T()
+	forged := primitive.NewObjectID()
+	body := `{"_id":"` + forged.Hex() + `", "name":"aspirin", "created_at":"2023-06-01T08:00:00Z", "updated_at":"2023-06-02T08:00:00Z", "state":"deleting", "acc_importance":149, "reflected_at":"2023-06-03T08:00:00Z"}`
+	w := serve(s.router, "POST", "/s/add", strings.NewReader(body))
+	assert.Equal(t, 200, w.Code)
+	var ir SessionAddResponse
+	assert.NoError(t, json.NewDecoder(w.Body).Decode(&ir))
+	assert.NotEqual(t, forged, ir.ID)
+
+	// only the created time can be given
+	w = serve(s.router, "GET", "/s/"+ir.ID.Hex(), nil)
+	assert.Equal(t, 200, w.Code)
+	var sess Session
+	assert.NoError(t, json.NewDecoder(w.Body).Decode(&sess))
+	assert.Equal(t, "aspirin", sess.Name)
+	assert.Equal(t, "2023-06-01T08:00:00Z", sess.CreatedAt.Time().UTC().Format(time.RFC3339))
+	assert.True(t, sess.UpdatedAt.Time().After(sess.CreatedAt.Time().AddDate(0, 0, 1)))
+	assert.Equal(t, SessionActive, sess.State)
+	assert.Zero(t, sess.AccImportance)
+	assert.Zero(t, sess.ReflectedAt)
+}
+
 func TestSessionTestSuite(t *testing.T) {
 	suite.Run(t, new(SessionTestSuite))
 }
@@ -0,0 +1,99 @@
+package memo
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// MemoryStats aggregates the memories of a collection
+type MemoryStats struct {
+	Count      uint64            `json:"count"`
+	Types      map[string]uint64 `json:"types"`               // count by memory type
+	Importance map[int]uint64    `json:"importance"`          // count by importance score
+	OldestAt   *time.Time        `json:"oldest_at,omitempty"` // created time of the oldest memory
+	NewestAt   *time.Time        `json:"newest_at,omitempty"` // created time of the newest memory
+	Collection CollectionInfo    `json:"collection"`
+}
+
+// CollectionInfo is the status and size of a collection reported by the vector store
+type CollectionInfo struct {
+	Status    string `json:"status"` // green, yellow or red
+	Points    uint64 `json:"points"`
+	Vectors   uint64 `json:"vectors"`
+	Segments  uint64 `json:"segments"`
+	Dimension int    `json:"dimension"`
+}
+
+func newMemoryStats() *MemoryStats {
+	stats := &MemoryStats{Types: map[string]uint64{}, Importance: map[int]uint64{}}
+	for _, name := range MemoryTypeStr {
+		stats.Types[name] = 0
+	}
+	return stats
+}
+
+// add counts the metadata of one memory
+func (s *MemoryStats) add(m MemoryMetadata) {
+	s.Count++
+	s.Types[m.Type.String()]++
+	s.Importance[m.Importance]++
+
+	if m.CreatedAt.IsZero() {
+		return
+	}
+	created := m.CreatedAt
+	if s.OldestAt == nil || created.Before(*s.OldestAt) {
+		s.OldestAt = &created
+	}
+	if s.NewestAt == nil || created.After(*s.NewestAt) {
+		s.NewestAt = &created
+	}
+}
+
+// SessionStats is the summary of a session and its memories
+type SessionStats struct {
+	ID            primitive.ObjectID `json:"_id"`
+	AccImportance int                `json:"acc_importance"`         // accumulated importance since last reflection
+	ReflectedAt   primitive.DateTime `json:"reflected_at,omitempty"` // last reflected time
+	Memories      MemoryStats        `json:"memories"`
+}
+
+// @Summary		get the statistics of one session
+// @Description	count the memories by type and importance, and report the status of the collection
+// @Tags			sessions
+// @Produce		json
+// @Param			id	path		string	true	"the session to get"
+// @Success		200	{object}	SessionStats
+// @Failure		default	{object}	APIError
+// @Security		ApiKeyAuth
+// @Router			/s/{id}/stats [get]
+func (h *Handlers) GetSessionStats(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	sess, err := h.findSession(ctx, tenant(c), c.Param("id"))
+	if err != nil {
+		sessionError(c, err)
+		return
+	}
+
+	stats, err := h.vectors.Stats(ctx, sess.ID.Hex())
+	if err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, ErrCollectionNotFound) {
+			status = http.StatusNotFound
+		}
+		NewError(c, status, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, SessionStats{
+		ID:            sess.ID,
+		AccImportance: sess.AccImportance,
+		ReflectedAt:   sess.ReflectedAt,
+		Memories:      *stats,
+	})
+}
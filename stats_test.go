@@ -0,0 +1,81 @@
+package memo
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetSessionStats(t *testing.T) {
+	hs := newTestHandlers(t)
+	hs.APIKeys = map[string]string{"key-a": "a", "key-b": "b"}
+	hs.AuthDisabled = false
+	router := newTestRouter(hs)
+
+	w := serveAs(router, "key-a", "POST", "/s/add", strings.NewReader(`{"name":"aspirin"}`))
+	assert.Equal(t, 200, w.Code)
+	var added SessionAddResponse
+	assert.NoError(t, json.NewDecoder(w.Body).Decode(&added))
+	sid := added.ID.Hex()
+
+	oldest := time.Date(2023, 6, 1, 8, 0, 0, 0, time.UTC)
+	newest := oldest.Add(48 * time.Hour)
+	memory := func(typ MemoryType, importance int, created time.Time) Memory {
+		return Memory{
+			ID:        uuid.NewString(),
+			Embedding: hashEmbedding("memory", hs.embedder.Dimension()),
+			Metadata:  MemoryMetadata{Type: typ, Content: "memory", Importance: importance, CreatedAt: created},
+		}
+	}
+	err := hs.vectors.Upsert(context.TODO(), sid, []Memory{
+		memory(BasicMemory, 3, oldest.Add(time.Hour)),
+		memory(BasicMemory, 3, oldest),
+		memory(PlanMemory, 5, newest),
+		memory(ReflectionMemory, 8, oldest.Add(24*time.Hour)),
+		// without the created time
+		memory(BasicMemory, 8, time.Time{}),
+	})
+	assert.NoError(t, err)
+
+	w = serveAs(router, "key-a", "GET", "/s/"+sid+"/stats", nil)
+	assert.Equal(t, 200, w.Code)
+	var stats SessionStats
+	assert.NoError(t, json.NewDecoder(w.Body).Decode(&stats))
+	assert.Equal(t, added.ID, stats.ID)
+
+	m := stats.Memories
+	assert.Equal(t, uint64(5), m.Count)
+	assert.Equal(t, map[string]uint64{"undefined": 0, "basic": 3, "interact": 0, "plan": 1, "reflection": 1}, m.Types)
+	assert.Equal(t, map[int]uint64{3: 2, 5: 1, 8: 2}, m.Importance)
+	assert.True(t, oldest.Equal(*m.OldestAt))
+	assert.True(t, newest.Equal(*m.NewestAt))
+	assert.Equal(t, uint64(5), m.Collection.Points)
+	assert.Equal(t, hs.embedder.Dimension(), m.Collection.Dimension)
+
+	// the sessions of other tenants are not found
+	assert.Equal(t, 404, serveAs(router, "key-b", "GET", "/s/"+sid+"/stats", nil).Code)
+
+	// the collection is missing
+	missing, err := hs.sessions.Create(context.TODO(), &Session{Name: "bob", Owner: "a"})
+	assert.NoError(t, err)
+	w = serveAs(router, "key-a", "GET", "/s/"+missing.Hex()+"/stats", nil)
+	assert.Equal(t, 404, w.Code)
+	assert.Contains(t, w.Body.String(), ErrCollectionNotFound.Error())
+
+	// an empty collection has no time range
+	assert.Equal(t, 200, serveAs(router, "key-a", "DELETE", "/s/"+sid+"/del", nil).Code)
+	w = serveAs(router, "key-a", "POST", "/s/add", strings.NewReader(`{"name":"carol"}`))
+	assert.NoError(t, json.NewDecoder(w.Body).Decode(&added))
+	w = serveAs(router, "key-a", "GET", "/s/"+added.ID.Hex()+"/stats", nil)
+	assert.Equal(t, 200, w.Code)
+	stats = SessionStats{}
+	assert.NoError(t, json.NewDecoder(w.Body).Decode(&stats))
+	assert.Zero(t, stats.Memories.Count)
+	assert.Nil(t, stats.Memories.OldestAt)
+	assert.Nil(t, stats.Memories.NewestAt)
+}
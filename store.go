@@ -0,0 +1,83 @@
+package memo
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// SessionQuery is the pagination of listing sessions
+type SessionQuery struct {
+	Owner  string             // only the active sessions of the owner
+	Tags   []string           // only the sessions with all the tags
+	All    bool               // the sessions of all the owners in any state, the owner is ignored
+	Offset primitive.ObjectID // list the sessions before this id, zero for the first page
+	Limit  int64
+}
+
+// SessionStore persists the agent sessions,
+// methods return ErrSessionNotFound if the session doesn't exist
+type SessionStore interface {
+	Checker
+
+	// List the sessions, the newest first
+	List(ctx context.Context, q SessionQuery) ([]Session, error)
+	// Create the session, and returns its id
+	Create(ctx context.Context, sess *Session) (primitive.ObjectID, error)
+	Get(ctx context.Context, id primitive.ObjectID) (*Session, error)
+	Delete(ctx context.Context, id primitive.ObjectID) error
+	// SetState changes the lifecycle state of the session at the given time
+	SetState(ctx context.Context, id primitive.ObjectID, state SessionState, at time.Time) error
+	// Update the session with the request at the given time, and returns the updated one
+	Update(ctx context.Context, id primitive.ObjectID, req *UpdateSessionRequest, at time.Time) (*Session, error)
+
+	// AddImportance accumulates the importance of new memories
+	AddImportance(ctx context.Context, id primitive.ObjectID, delta int) error
+	// ResetImportance clears the accumulated importance if it reaches min (always if min <= 0),
+	// and marks the reflected time, returns the session before reset
+	ResetImportance(ctx context.Context, id primitive.ObjectID, min int, at time.Time) (*Session, error)
+	// RestoreImportance undoes the reset at the given time when its reflection fails, adds back the importance,
+	// and restores the reflected time unless the session is reflected again
+	RestoreImportance(ctx context.Context, id primitive.ObjectID, delta int, reflectedAt primitive.DateTime, at time.Time) error
+}
+
+// Checker reports the health of a dependency, and releases its connections when closed
+type Checker interface {
+	// Ping checks whether the dependency is reachable
+	Ping(ctx context.Context) error
+	// Close the connections, the checker can't be used after closed
+	Close(ctx context.Context) error
+}
+
+// VectorStore persists the memories with their embeddings, one collection for each session
+type VectorStore interface {
+	Checker
+
+	// EnsureCollection creates the collection with the vector dimension if it doesn't exist,
+	// and the missing payload indexes of an existing one
+	EnsureCollection(ctx context.Context, name string, dimension int) (created bool, err error)
+	DeleteCollection(ctx context.Context, name string) (bool, error)
+	// Collections lists the names of all the collections
+	Collections(ctx context.Context) ([]string, error)
+
+	// Upsert the memories, their ids must be set
+	Upsert(ctx context.Context, collection string, memories []Memory) error
+	// Search the most similar memories with the vector, the scores are set
+	Search(ctx context.Context, collection string, vector []float32, limit uint64, filter *MemoryFilter) ([]Memory, error)
+	// Scroll the memories page by page, returns the offset of next page, empty if it's the last page
+	Scroll(ctx context.Context, collection string, filter *MemoryFilter, offset string, limit uint32) ([]Memory, string, error)
+	// Stats aggregates the memories, and reports the status of the collection
+	Stats(ctx context.Context, collection string) (*MemoryStats, error)
+	// Touch updates the last accessed time of the memories
+	Touch(ctx context.Context, collection string, ids []string, at time.Time) error
+
+	// Get the memories by ids, the missing ones are skipped
+	Get(ctx context.Context, collection string, ids []string, withVectors bool) ([]Memory, error)
+	// Count the memories which match the filter, nil filter counts all
+	Count(ctx context.Context, collection string, filter *MemoryFilter) (uint64, error)
+	// Delete the memories by ids, the missing ones are ignored
+	Delete(ctx context.Context, collection string, ids []string) error
+	// DeleteByFilter deletes the memories which match the filter, the filter must not be empty
+	DeleteByFilter(ctx context.Context, collection string, filter *MemoryFilter) error
+}
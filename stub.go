@@ -0,0 +1,154 @@
+package memo
+
+import (
+	"context"
+	"encoding/json"
+	"hash/fnv"
+	"math"
+	"strconv"
+	"strings"
+	"unicode"
+
+	openai "github.com/sashabaranov/go-openai"
+)
+
+// StubClient is a deterministic and offline replacement of the openai client, it's useful for testing.
+// The embeddings are hashed from the words and characters of the inputs,
+// so the texts sharing words are similar to each other.
+type StubClient struct {
+	Dimension int // dimension of the embeddings
+
+	// Reply generates the chat completion, if it's nil,
+	// each memory of the importance scoring request is scored by its hash,
+	// in the arguments of the function if it's called
+	Reply func(messages []openai.ChatCompletionMessage) string
+}
+
+func NewStubClient() *StubClient {
+	return &StubClient{Dimension: 1536}
+}
+
+func (s *StubClient) CreateEmbeddings(ctx context.Context, request openai.EmbeddingRequest) (openai.EmbeddingResponse, error) {
+	resp := openai.EmbeddingResponse{Object: "list", Model: request.Model}
+	for i, input := range request.Input {
+		resp.Data = append(resp.Data, openai.Embedding{
+			Object:    "embedding",
+			Index:     i,
+			Embedding: hashEmbedding(input, s.Dimension),
+		})
+	}
+	return resp, nil
+}
+
+func (s *StubClient) CreateChatCompletion(ctx context.Context, request openai.ChatCompletionRequest) (openai.ChatCompletionResponse, error) {
+	msg := openai.ChatCompletionMessage{Role: openai.ChatMessageRoleAssistant}
+	reason := openai.FinishReasonStop
+	switch {
+	case s.Reply != nil:
+		msg.Content = s.Reply(request.Messages)
+	case len(request.Functions) > 0:
+		msg.FunctionCall = &openai.FunctionCall{Name: request.Functions[0].Name, Arguments: stubScoreArguments(request.Messages)}
+		reason = openai.FinishReasonFunctionCall
+	default:
+		msg.Content = stubScores(request.Messages)
+	}
+
+	return openai.ChatCompletionResponse{
+		Object:  "chat.completion",
+		Model:   request.Model,
+		Choices: []openai.ChatCompletionChoice{{Message: msg, FinishReason: reason}},
+	}, nil
+}
+
+func (s *StubClient) ListModels(ctx context.Context) (openai.ModelsList, error) {
+	return openai.ModelsList{Models: []openai.Model{{ID: openai.GPT3Dot5Turbo, Object: "model"}}}, nil
+}
+
+// stubScores scores each memory of the last message (separated by ";") from 1 to 10 by its hash
+func stubScores(messages []openai.ChatCompletionMessage) string {
+	var scores []string
+	for _, score := range stubScoreList(messages) {
+		scores = append(scores, strconv.Itoa(score))
+	}
+	return strings.Join(scores, ", ")
+}
+
+// stubScoreArguments is stubScores in the arguments of scoreFunction
+func stubScoreArguments(messages []openai.ChatCompletionMessage) string {
+	type item struct {
+		Index int `json:"index"`
+		Score int `json:"score"`
+	}
+
+	var args struct {
+		Scores []item `json:"scores"`
+	}
+	for i, score := range stubScoreList(messages) {
+		args.Scores = append(args.Scores, item{Index: i + 1, Score: score})
+	}
+
+	b, _ := json.Marshal(args)
+	return string(b)
+}
+
+func stubScoreList(messages []openai.ChatCompletionMessage) []int {
+	if len(messages) == 0 {
+		return nil
+	}
+
+	var scores []int
+	for _, m := range strings.Split(messages[len(messages)-1].Content, ";") {
+		h := fnv.New32a()
+		h.Write([]byte(m))
+		scores = append(scores, int(h.Sum32()%10)+1)
+	}
+	return scores
+}
+
+// hashEmbedding embeds the text by hashing its words and their character n-grams into the vector
+func hashEmbedding(text string, dim int) []float32 {
+	v := make([]float32, dim)
+	if dim == 0 {
+		return v
+	}
+
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r) && r != '\''
+	})
+
+	for _, w := range words {
+		addFeature(v, w, 1)
+
+		// n-grams are useful for the languages without spaces, e.g. chinese
+		runes := []rune(w)
+		for n := 2; n <= 3; n++ {
+			for i := 0; i+n <= len(runes); i++ {
+				addFeature(v, string(runes[i:i+n]), 0.5)
+			}
+		}
+	}
+
+	var norm float64
+	for _, x := range v {
+		norm += float64(x) * float64(x)
+	}
+	if norm > 0 {
+		norm = math.Sqrt(norm)
+		for i := range v {
+			v[i] = float32(float64(v[i]) / norm)
+		}
+	}
+	return v
+}
+
+// addFeature adds the weight to the hashed index, the sign is hashed too to reduce the collision bias
+func addFeature(v []float32, feature string, weight float32) {
+	h := fnv.New64a()
+	h.Write([]byte(feature))
+	sum := h.Sum64()
+
+	if sum>>63 == 1 {
+		weight = -weight
+	}
+	v[sum%uint64(len(v))] += weight
+}